@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go-proxy/exchanges"
+	"go-proxy/proxypool"
+)
+
+// Quarantine file name inside the data directory
+const quarantineFile = "quarantine.json"
+
+// exchangeHealth is the latest state of one proxy on one exchange
+type exchangeHealth struct {
+	Healthy      bool          `json:"healthy"`
+	ResponseTime time.Duration `json:"response_time,omitempty"`
+	Error        string        `json:"error,omitempty"`
+	FailureKind  string        `json:"failure_kind,omitempty"`
+}
+
+// proxyStatus is one entry of the admin proxy list
+type proxyStatus struct {
+	Proxy       string                     `json:"proxy"`
+	Address     string                     `json:"proxy_address"`
+	Port        int                        `json:"port"`
+	CountryCode string                     `json:"country_code"`
+	Quarantined bool                       `json:"quarantined"`
+	Health      map[string]*exchangeHealth `json:"health"`
+}
+
+// routeStatus is one entry of the admin routing rule list
+type routeStatus struct {
+	proxypool.Rule
+	Eligible int `json:"eligible_proxies"`
+}
+
+// quarantinePath returns the full path of the quarantine file
+func quarantinePath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, quarantineFile), nil
+}
+
+// loadQuarantine reads the persisted list of quarantined proxy IDs
+func loadQuarantine() ([]string, error) {
+	path, err := quarantinePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := proxypool.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// saveQuarantine persists the list of quarantined proxy IDs, atomically
+// and under a lock like the proxy cache
+func saveQuarantine(keys []string) error {
+	path, err := quarantinePath()
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(keys)
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return proxypool.WriteFile(path, data)
+}
+
+// registerAdminRoutes mounts the admin API on mux, protected by a bearer
+// token. {proxy} is a proxy ID, as listed by GET /admin/proxies.
+//
+//	GET  /admin/proxies                    every proxy with its health per exchange
+//	GET  /admin/proxies/{proxy}            recent test attempts of one proxy
+//	POST /admin/proxies/{proxy}/quarantine exclude a proxy from testing and the healthy pool
+//	POST /admin/proxies/{proxy}/release    undo a quarantine
+//	POST /admin/proxies/{proxy}/retest     test one proxy now and return the results
+//	POST /admin/retest                     start a full round as soon as possible
+//	POST /admin/reload                     reload the proxy list from the provider
+//	GET  /admin/routes                     routing rules with their eligible proxy counts
+func registerAdminRoutes(mux *http.ServeMux, m *monitor, token string) {
+	auth := func(handler http.HandlerFunc) http.Handler {
+		expected := []byte("Bearer " + token)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeJSONError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+				return
+			}
+			handler(w, r)
+		})
+	}
+
+	mux.Handle("GET /admin/proxies", auth(m.handleListProxies))
+	mux.Handle("GET /admin/proxies/{proxy}", auth(m.handleProxyAttempts))
+	mux.Handle("POST /admin/proxies/{proxy}/quarantine", auth(m.handleQuarantine(true)))
+	mux.Handle("POST /admin/proxies/{proxy}/release", auth(m.handleQuarantine(false)))
+	mux.Handle("POST /admin/proxies/{proxy}/retest", auth(m.handleRetestProxy))
+	mux.Handle("POST /admin/retest", auth(m.handleRetestAll))
+	mux.Handle("POST /admin/reload", auth(m.handleReload))
+	mux.Handle("GET /admin/routes", auth(m.handleListRoutes))
+}
+
+func (m *monitor) handleListProxies(w http.ResponseWriter, r *http.Request) {
+	m.mutex.RLock()
+	statuses := make([]proxyStatus, 0, len(m.proxies))
+	for _, proxy := range m.proxies {
+		status := proxyStatus{
+			Proxy:       proxy.ID(),
+			Address:     proxy.ProxyAddress,
+			Port:        proxy.Port,
+			CountryCode: proxy.CountryCode,
+			Quarantined: m.quarantined[proxy.ID()],
+			Health:      make(map[string]*exchangeHealth),
+		}
+		for _, tester := range m.testers {
+			result, exists := m.latest[latestKey(proxy, tester.GetName())]
+			if !exists {
+				continue
+			}
+			status.Health[exchangeLabel(tester.GetName())] = healthFromResult(result)
+		}
+		statuses = append(statuses, status)
+	}
+	m.mutex.RUnlock()
+
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (m *monitor) handleProxyAttempts(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("proxy")
+	if _, exists := m.findProxy(key); !exists {
+		writeJSONError(w, http.StatusNotFound, "unknown proxy "+key)
+		return
+	}
+
+	m.mutex.RLock()
+	attempts := append([]attempt{}, m.attempts[key]...)
+	m.mutex.RUnlock()
+
+	writeJSON(w, http.StatusOK, attempts)
+}
+
+func (m *monitor) handleQuarantine(quarantined bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("proxy")
+		if _, exists := m.findProxy(key); !exists {
+			writeJSONError(w, http.StatusNotFound, "unknown proxy "+key)
+			return
+		}
+		if err := m.setQuarantined(key, quarantined); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "failed to persist quarantine list: "+err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"proxy": key, "quarantined": quarantined})
+	}
+}
+
+func (m *monitor) handleRetestProxy(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("proxy")
+	proxy, exists := m.findProxy(key)
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, "unknown proxy "+key)
+		return
+	}
+
+	results := m.retestProxy(proxy)
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Exchange < results[j].Exchange
+	})
+	writeJSON(w, http.StatusOK, results)
+}
+
+func (m *monitor) handleRetestAll(w http.ResponseWriter, r *http.Request) {
+	m.requestRound()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "retest scheduled"})
+}
+
+func (m *monitor) handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := m.loadProxies(true); err != nil {
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	m.mutex.RLock()
+	count := len(m.proxies)
+	m.mutex.RUnlock()
+
+	writeJSON(w, http.StatusOK, map[string]int{"proxies": count})
+}
+
+func (m *monitor) handleListRoutes(w http.ResponseWriter, r *http.Request) {
+	rules := m.pool.Rules()
+	routes := make([]routeStatus, 0, len(rules))
+	for _, rule := range rules {
+		routes = append(routes, routeStatus{Rule: rule, Eligible: len(m.pool.Eligible(rule))})
+	}
+	writeJSON(w, http.StatusOK, routes)
+}
+
+// healthFromResult summarises a test result for the admin API
+func healthFromResult(result *exchanges.TestResult) *exchangeHealth {
+	health := &exchangeHealth{Healthy: result.Success}
+	if result.Success {
+		health.ResponseTime = result.ResponseTime
+	} else {
+		health.Error = result.Error
+		health.FailureKind = result.FailureKind
+	}
+	return health
+}
+
+// writeJSON writes value as an indented JSON response
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+// writeJSONError writes an error message as a JSON response
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": strings.TrimSpace(message)})
+}
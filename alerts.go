@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go-proxy/exchanges"
+	"go-proxy/notify"
+	"go-proxy/proxypool"
+)
+
+// alertStateFile keeps the firing alerts between runs, in the data directory
+const alertStateFile = "alerts.json"
+
+// Channel types accepted in notify.channels
+var channelTypes = []string{"webhook", "slack", "telegram"}
+
+// alertState is the persisted state of one rule
+type alertState struct {
+	Firing     bool      `json:"firing"`
+	Since      time.Time `json:"since"`
+	NotifiedAt time.Time `json:"notified_at,omitempty"` // zero until a firing notification was delivered
+}
+
+// alertManager evaluates the alert rules against each test run and notifies
+// their channels when a rule starts firing, while it keeps firing (every
+// repeat interval, if set) and when it resolves. Firing rules are remembered
+// in the data directory, so separate test runs do not notify twice.
+type alertManager struct {
+	rules    []alertRuleConfig
+	channels map[string]notify.Notifier
+	names    []string // channel names in config order
+	repeat   time.Duration
+
+	mutex sync.Mutex
+	state map[string]*alertState // by rule name
+}
+
+// newAlertManager creates the alert manager of the notify configuration, or
+// returns nil when no rules are configured
+func newAlertManager(cfg notifyConfig) *alertManager {
+	if len(cfg.Rules) == 0 {
+		return nil
+	}
+	a := &alertManager{
+		rules:    cfg.Rules,
+		channels: make(map[string]notify.Notifier),
+		repeat:   cfg.Repeat,
+		state:    make(map[string]*alertState),
+	}
+	for _, channel := range cfg.Channels {
+		a.names = append(a.names, channel.Name)
+		a.channels[channel.Name] = channel.notifier()
+	}
+
+	if err := a.load(); err != nil && !os.IsNotExist(err) {
+		logger.Warn("failed to load alert state", "error", err)
+	}
+	return a
+}
+
+// notifier creates the notifier of a channel
+func (c channelConfig) notifier() notify.Notifier {
+	switch c.Type {
+	case "slack":
+		return &notify.Slack{URL: c.URL}
+	case "telegram":
+		return &notify.Telegram{Token: c.Token, ChatID: c.ChatID, URL: c.URL}
+	}
+	return &notify.Webhook{URL: c.URL, Secret: c.Secret}
+}
+
+// alertStatePath returns the full path of the alert state file
+func alertStatePath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, alertStateFile), nil
+}
+
+// load reads the state saved by earlier runs
+func (a *alertManager) load() error {
+	path, err := alertStatePath()
+	if err != nil {
+		return err
+	}
+	data, err := proxypool.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return a.restore(data)
+}
+
+// restore replaces the state with the saved one, dropping rules that no
+// longer exist
+func (a *alertManager) restore(data []byte) error {
+	var saved map[string]*alertState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	a.state = make(map[string]*alertState)
+	for _, rule := range a.rules {
+		if state, exists := saved[rule.Name]; exists {
+			a.state[rule.Name] = state
+		}
+	}
+	return nil
+}
+
+// measure returns the value a rule watches in a run and a description of it.
+// ok is false when the run has no tests the rule covers.
+func (rule alertRuleConfig) measure(results []*exchanges.TestResult) (value float64, firing, ok bool, description string) {
+	healthy := make(map[string]bool)
+	tests, failed := 0, 0
+	for _, result := range results {
+		if rule.Exchange != "" && exchangeLabel(result.Exchange) != rule.Exchange {
+			continue
+		}
+		if rule.Country != "" && !strings.EqualFold(result.CountryCode, rule.Country) {
+			continue
+		}
+		tests++
+		if result.Success {
+			healthy[groupKey(result, "proxy")] = true
+		} else {
+			failed++
+		}
+	}
+
+	scope := rule.scope()
+	if rule.MinHealthy > 0 {
+		if tests == 0 {
+			return 0, false, false, ""
+		}
+		value = float64(len(healthy))
+		return value, len(healthy) < rule.MinHealthy,
+			true, fmt.Sprintf("healthy proxies%s: %d (minimum %d)", scope, len(healthy), rule.MinHealthy)
+	}
+
+	if tests == 0 || tests < rule.MinTests {
+		return 0, false, false, ""
+	}
+	value = percent(failed, tests)
+	return value, value > rule.MaxFailurePercent,
+		true, fmt.Sprintf("%.1f%% of %d tests failed%s (maximum %.1f%%)", value, tests, scope, rule.MaxFailurePercent)
+}
+
+// scope describes the exchange and country a rule covers
+func (rule alertRuleConfig) scope() string {
+	var parts []string
+	if rule.Exchange != "" {
+		parts = append(parts, "on "+rule.Exchange)
+	}
+	if rule.Country != "" {
+		parts = append(parts, "in "+strings.ToUpper(rule.Country))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " " + strings.Join(parts, " ")
+}
+
+// threshold returns the value a rule compares against
+func (rule alertRuleConfig) threshold() float64 {
+	if rule.MinHealthy > 0 {
+		return float64(rule.MinHealthy)
+	}
+	return rule.MaxFailurePercent
+}
+
+// evaluate checks every rule against the results of a run and sends the
+// notifications due. The saved state is reloaded and written back under
+// the state file's lock, so processes sharing a data directory, such as a
+// test run next to serve, take turns and never notify twice. A nil manager
+// does nothing.
+func (a *alertManager) evaluate(ctx context.Context, results []*exchanges.TestResult) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	checked := false
+	path, err := alertStatePath()
+	if err == nil {
+		err = proxypool.UpdateFile(path, func(data []byte) ([]byte, error) {
+			if data != nil {
+				if err := a.restore(data); err != nil {
+					logger.Warn("failed to load alert state", "error", err)
+				}
+			}
+			a.check(ctx, results)
+			checked = true
+			return json.MarshalIndent(a.state, "", "  ")
+		})
+	}
+	if err != nil {
+		logger.Warn("failed to save alert state", "error", err)
+	}
+	if !checked {
+		a.check(ctx, results)
+	}
+}
+
+// check evaluates every rule against results, updating the state and
+// sending the notifications due
+func (a *alertManager) check(ctx context.Context, results []*exchanges.TestResult) {
+	now := time.Now()
+	for _, rule := range a.rules {
+		value, firing, ok, description := rule.measure(results)
+		if !ok {
+			continue
+		}
+		notification := notify.Notification{
+			Rule:      rule.Name,
+			Status:    notify.StatusFiring,
+			Message:   description,
+			Value:     value,
+			Threshold: rule.threshold(),
+			Exchange:  rule.Exchange,
+			Country:   strings.ToUpper(rule.Country),
+			Time:      now,
+		}
+
+		state := a.state[rule.Name]
+		switch {
+		case firing && state == nil:
+			state = &alertState{Firing: true, Since: now}
+			a.state[rule.Name] = state
+			logger.Warn("alert firing", "rule", rule.Name, "value", value)
+			fallthrough
+		case firing && (state.NotifiedAt.IsZero() || a.repeat > 0 && now.Sub(state.NotifiedAt) >= a.repeat):
+			// Deliveries that failed entirely are retried on the next run
+			notification.Since = state.Since
+			if a.send(ctx, rule, notification) {
+				state.NotifiedAt = now
+			}
+		case !firing && state != nil:
+			logger.Info("alert resolved", "rule", rule.Name, "value", value)
+			if !state.NotifiedAt.IsZero() {
+				notification.Status = notify.StatusResolved
+				notification.Since = state.Since
+				a.send(ctx, rule, notification)
+			}
+			delete(a.state, rule.Name)
+		}
+	}
+}
+
+// checkAlerts evaluates the alert rules against a run of tested out of
+// pooled proxies. Runs that were aborted, or narrowed to part of the pool by
+// filters, sampling or a limit, would misstate the pool's health, so their
+// results are not checked. A nil manager does nothing.
+func checkAlerts(ctx context.Context, alerts *alertManager, results []*exchanges.TestResult, tested, pooled int, aborted bool) {
+	switch {
+	case alerts == nil:
+	case aborted:
+		logger.Info("alert rules not checked: the run did not complete")
+	case tested < pooled:
+		logger.Info("alert rules not checked: the run tested part of the pool", "proxies", tested, "pool", pooled)
+	default:
+		alerts.evaluate(ctx, results)
+	}
+}
+
+// send delivers a notification to the rule's channels, or to every channel
+// when the rule names none, and reports whether any delivery succeeded
+func (a *alertManager) send(ctx context.Context, rule alertRuleConfig, notification notify.Notification) bool {
+	names := rule.Channels
+	if len(names) == 0 {
+		names = a.names
+	}
+	delivered := false
+	for _, name := range names {
+		if err := a.channels[name].Notify(ctx, notification); err != nil {
+			logger.Warn("failed to send notification", "rule", rule.Name, "channel", name, "error", err)
+			continue
+		}
+		delivered = true
+	}
+	return delivered
+}
+
+// validateNotify reports problems in the notify section
+func validateNotify(cfg notifyConfig, report func(format string, args ...any), checkURL func(key, value string)) {
+	if cfg.Repeat < 0 {
+		report("notify.repeat: must not be negative, got %s", cfg.Repeat)
+	}
+
+	var channelNames []string
+	for i, channel := range cfg.Channels {
+		key := fmt.Sprintf("notify.channels.%d", i)
+		if channel.Name == "" {
+			report("%s.name: must not be empty", key)
+		} else if slices.Contains(channelNames, channel.Name) {
+			report("%s.name: duplicate channel '%s'", key, channel.Name)
+		}
+		channelNames = append(channelNames, channel.Name)
+
+		checkURL(key+".url", channel.URL)
+		switch channel.Type {
+		case "webhook", "slack":
+			if channel.URL == "" {
+				report("%s.url: required for %s channels", key, channel.Type)
+			}
+		case "telegram":
+			if channel.Token == "" || channel.ChatID == "" {
+				report("%s: telegram channels need token and chat_id", key)
+			}
+		default:
+			report("%s.type: must be one of %s, got '%s'", key, strings.Join(channelTypes, ", "), channel.Type)
+		}
+	}
+
+	known := exchanges.NewRegistry().List()
+	var ruleNames []string
+	for i, rule := range cfg.Rules {
+		key := fmt.Sprintf("notify.rules.%d", i)
+		if rule.Name == "" {
+			report("%s.name: must not be empty", key)
+		} else if slices.Contains(ruleNames, rule.Name) {
+			report("%s.name: duplicate rule '%s'", key, rule.Name)
+		}
+		ruleNames = append(ruleNames, rule.Name)
+
+		if rule.Exchange != "" && !slices.Contains(known, rule.Exchange) {
+			report("%s.exchange: unknown exchange '%s' (available: %s)", key, rule.Exchange, strings.Join(known, ", "))
+		}
+		if (rule.MinHealthy > 0) == (rule.MaxFailurePercent > 0) {
+			report("%s: set exactly one of min_healthy and max_failure_percent", key)
+		}
+		if rule.MinHealthy < 0 {
+			report("%s.min_healthy: must not be negative, got %d", key, rule.MinHealthy)
+		}
+		if rule.MaxFailurePercent < 0 || rule.MaxFailurePercent >= 100 {
+			report("%s.max_failure_percent: must be between 0 and 100, got %g", key, rule.MaxFailurePercent)
+		}
+		if rule.MinTests < 0 {
+			report("%s.min_tests: must not be negative, got %d", key, rule.MinTests)
+		}
+		for _, name := range rule.Channels {
+			if !slices.Contains(channelNames, name) {
+				report("%s.channels: unknown channel '%s'", key, name)
+			}
+		}
+		if len(cfg.Channels) == 0 {
+			report("%s: no channels configured under notify.channels", key)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-proxy/exchanges"
+	"go-proxy/notify"
+)
+
+// recorder is a notification channel that keeps what it was sent
+type recorder struct {
+	sent []notify.Notification
+	fail bool
+}
+
+func (r *recorder) Notify(ctx context.Context, n notify.Notification) error {
+	if r.fail {
+		return errors.New("channel down")
+	}
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+// newTestAlertManager creates an alert manager for rules whose only channel
+// is the returned recorder
+func newTestAlertManager(t *testing.T, repeat time.Duration, rules ...alertRuleConfig) (*alertManager, *recorder) {
+	t.Helper()
+	a := newAlertManager(notifyConfig{
+		Repeat:   repeat,
+		Channels: []channelConfig{{Name: "test", Type: "webhook", URL: "http://127.0.0.1:1/unused"}},
+		Rules:    rules,
+	})
+	r := &recorder{}
+	a.channels["test"] = r
+	return a, r
+}
+
+// binanceResults returns one Binance result per proxy, the first healthy of
+// them passing and the others failing
+func binanceResults(proxies, healthy int) []*exchanges.TestResult {
+	var results []*exchanges.TestResult
+	for i := range proxies {
+		results = append(results, &exchanges.TestResult{
+			Exchange:     "Binance",
+			ProxyAddress: "192.0.2.1",
+			Port:         8000 + i,
+			CountryCode:  "DE",
+			Success:      i < healthy,
+		})
+	}
+	return results
+}
+
+var minHealthyRule = alertRuleConfig{Name: "binance-healthy", Exchange: "binance", MinHealthy: 3}
+
+func TestAlertFiresOnce(t *testing.T) {
+	useTestConfig(t)
+	a, r := newTestAlertManager(t, 0, minHealthyRule)
+
+	a.evaluate(context.Background(), binanceResults(5, 1))
+	if len(r.sent) != 1 {
+		t.Fatalf("sent %d notifications, want 1", len(r.sent))
+	}
+	got := r.sent[0]
+	if got.Rule != "binance-healthy" || got.Status != notify.StatusFiring || got.Value != 1 || got.Threshold != 3 {
+		t.Errorf("notification = %+v, want binance-healthy firing with value 1 and threshold 3", got)
+	}
+	if got.Since.IsZero() {
+		t.Error("notification has no start time")
+	}
+
+	// Neither this manager nor a new one, as in a later run, notifies again
+	a.evaluate(context.Background(), binanceResults(5, 2))
+	next, nextRecorder := newTestAlertManager(t, 0, minHealthyRule)
+	next.evaluate(context.Background(), binanceResults(5, 0))
+	if len(r.sent) != 1 || len(nextRecorder.sent) != 0 {
+		t.Errorf("a firing alert was notified again: %d and %d notifications", len(r.sent)-1, len(nextRecorder.sent))
+	}
+}
+
+func TestAlertRepeats(t *testing.T) {
+	useTestConfig(t)
+	a, r := newTestAlertManager(t, time.Nanosecond, minHealthyRule)
+
+	a.evaluate(context.Background(), binanceResults(5, 1))
+	time.Sleep(time.Millisecond)
+	a.evaluate(context.Background(), binanceResults(5, 1))
+	if len(r.sent) != 2 {
+		t.Fatalf("sent %d notifications, want a firing one and a reminder", len(r.sent))
+	}
+	if !r.sent[1].Since.Equal(r.sent[0].Since) {
+		t.Errorf("reminder starts at %s, want the original %s", r.sent[1].Since, r.sent[0].Since)
+	}
+}
+
+func TestAlertResolves(t *testing.T) {
+	useTestConfig(t)
+	a, r := newTestAlertManager(t, 0, minHealthyRule)
+
+	a.evaluate(context.Background(), binanceResults(5, 1))
+	a.evaluate(context.Background(), binanceResults(5, 4))
+	if len(r.sent) != 2 {
+		t.Fatalf("sent %d notifications, want firing and resolved", len(r.sent))
+	}
+	if got := r.sent[1]; got.Status != notify.StatusResolved || got.Value != 4 {
+		t.Errorf("notification = %+v, want resolved with value 4", got)
+	}
+
+	// A resolved alert fires again as a new alert
+	a.evaluate(context.Background(), binanceResults(5, 4))
+	a.evaluate(context.Background(), binanceResults(5, 0))
+	if len(r.sent) != 3 || r.sent[2].Status != notify.StatusFiring {
+		t.Errorf("sent %d notifications, want a third, firing one", len(r.sent))
+	}
+}
+
+func TestAlertRetriesFailedDelivery(t *testing.T) {
+	useTestConfig(t)
+	a, r := newTestAlertManager(t, 0, minHealthyRule)
+
+	r.fail = true
+	a.evaluate(context.Background(), binanceResults(5, 1))
+	r.fail = false
+	a.evaluate(context.Background(), binanceResults(5, 1))
+	if len(r.sent) != 1 || r.sent[0].Status != notify.StatusFiring {
+		t.Fatalf("sent %v, want the firing notification on the second run", r.sent)
+	}
+
+	// An alert nobody heard about resolves silently
+	b, quiet := newTestAlertManager(t, 0, alertRuleConfig{Name: "other", MinHealthy: 3})
+	quiet.fail = true
+	b.evaluate(context.Background(), binanceResults(5, 1))
+	quiet.fail = false
+	b.evaluate(context.Background(), binanceResults(5, 5))
+	if len(quiet.sent) != 0 {
+		t.Errorf("sent %v for an alert that was never notified", quiet.sent)
+	}
+}
+
+func TestAlertRuleMeasure(t *testing.T) {
+	results := append(binanceResults(4, 1), &exchanges.TestResult{
+		Exchange: "Coinbase", ProxyAddress: "192.0.2.9", Port: 80, CountryCode: "US", Success: true,
+	})
+
+	tests := []struct {
+		name   string
+		rule   alertRuleConfig
+		value  float64
+		firing bool
+		ok     bool
+	}{
+		{"healthy on every exchange", alertRuleConfig{MinHealthy: 2}, 2, false, true},
+		{"healthy on one exchange", alertRuleConfig{Exchange: "binance", MinHealthy: 2}, 1, true, true},
+		{"healthy in a country", alertRuleConfig{Country: "us", MinHealthy: 2}, 1, true, true},
+		{"no tests in scope", alertRuleConfig{Country: "FR", MinHealthy: 2}, 0, false, false},
+		{"failure rate above maximum", alertRuleConfig{Exchange: "binance", MaxFailurePercent: 50}, 75, true, true},
+		{"failure rate within maximum", alertRuleConfig{MaxFailurePercent: 80}, 60, false, true},
+		{"too few tests for a rate", alertRuleConfig{MaxFailurePercent: 50, MinTests: 10}, 0, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, firing, ok, _ := test.rule.measure(results)
+			if value != test.value || firing != test.firing || ok != test.ok {
+				t.Errorf("measure() = %g, %t, %t; want %g, %t, %t", value, firing, ok, test.value, test.firing, test.ok)
+			}
+		})
+	}
+}
+
+func TestCheckAlertsSkipsPartialRuns(t *testing.T) {
+	useTestConfig(t)
+	a, r := newTestAlertManager(t, 0, minHealthyRule)
+	results := binanceResults(5, 1)
+
+	checkAlerts(context.Background(), a, results, 5, 20, false)
+	checkAlerts(context.Background(), a, results, 5, 5, true)
+	if len(r.sent) != 0 {
+		t.Fatalf("partial or aborted runs sent %v", r.sent)
+	}
+	checkAlerts(context.Background(), a, results, 5, 5, false)
+	if len(r.sent) != 1 {
+		t.Errorf("a complete run sent %d notifications, want 1", len(r.sent))
+	}
+}
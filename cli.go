@@ -0,0 +1,354 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// Name of the binary as shown in help and completion scripts
+const programName = "go-proxy"
+
+// globalFlag is a flag accepted anywhere on the command line, by every command
+type globalFlag struct {
+	name, value, usage string
+	completion         completion
+}
+
+// globalFlags are removed from the arguments before the command tree parses them
+var globalFlags = []globalFlag{
+	{"config", "file", "config file (default: PROXY_CONFIG, ./go-proxy.yaml, then the user config directory)", completion{files: true}},
+	{"profile", "name", "config profile to apply (default: PROXY_PROFILE)", completion{}},
+	{"pool", "name", "named proxy pool to use, with its own provider settings, cache and history (default: PROXY_POOL, then the default pool)", completion{}},
+	{"env-file", "file", "load environment variables from file; repeatable, later files win (default: .env and .env.local when present)", completion{files: true}},
+	{"log-level", "level", "diagnostics to log on stderr: debug, info, warn or error (default: PROXY_LOG_LEVEL, then info)", completion{words: logLevels}},
+	{"log-format", "format", "format of the diagnostics on stderr: text or json (default: PROXY_LOG_FORMAT, then text)", completion{words: logFormats}},
+}
+
+// globalOptions holds the values of the global flags
+type globalOptions struct {
+	configPath string
+	profile    string
+	pool       string
+	envFiles   []string
+	logLevel   string
+	logFormat  string
+}
+
+// parseGlobalFlags removes the global flags from args, wherever they appear,
+// and returns their values
+func parseGlobalFlags(args []string) ([]string, globalOptions, error) {
+	var rest []string
+	var options globalOptions
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if !slices.ContainsFunc(globalFlags, func(global globalFlag) bool { return name == "--"+global.name }) {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, options, fmt.Errorf("%s requires a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		switch name {
+		case "--config":
+			options.configPath = value
+		case "--profile":
+			options.profile = value
+		case "--pool":
+			options.pool = value
+		case "--env-file":
+			options.envFiles = append(options.envFiles, value)
+		case "--log-level":
+			options.logLevel = value
+		case "--log-format":
+			options.logFormat = value
+		}
+	}
+	return rest, options, nil
+}
+
+// completion describes the candidates for a positional argument or flag value
+type completion struct {
+	words []string // fixed candidates
+	files bool     // complete file names
+}
+
+// command is one node of the command tree. A command either runs itself or
+// dispatches to its subcommands.
+type command struct {
+	name    string
+	args    string // positional arguments as shown in usage, e.g. "<exchange>..."
+	summary string
+	details func() string // optional extra help text, built when shown
+
+	// Number of positional arguments accepted; maxArgs < 0 means unlimited
+	minArgs, maxArgs int
+
+	flags       *flag.FlagSet
+	run         func(args []string) error
+	subcommands []*command
+	parent      *command
+
+	// Shell completion candidates for positional arguments and flag values
+	argCompletion  completion
+	flagCompletion map[string]completion
+}
+
+// newCommand creates a command with an empty flag set
+func newCommand(name, args, summary string) *command {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.Usage = func() {}
+	return &command{
+		name:           name,
+		args:           args,
+		summary:        summary,
+		flags:          flags,
+		flagCompletion: make(map[string]completion),
+	}
+}
+
+// add attaches subcommands
+func (c *command) add(subcommands ...*command) *command {
+	for _, sub := range subcommands {
+		sub.parent = c
+		c.subcommands = append(c.subcommands, sub)
+	}
+	return c
+}
+
+// find returns the subcommand with the given name
+func (c *command) find(name string) *command {
+	for _, sub := range c.subcommands {
+		if sub.name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// path returns the full command path, e.g. "go-proxy config validate"
+func (c *command) path() string {
+	if c.parent == nil {
+		return c.name
+	}
+	return c.parent.path() + " " + c.name
+}
+
+// execute parses args for this command and runs it or the selected
+// subcommand, returning the error of the command that ran. Command-line
+// mistakes exit with exitUsage right away.
+func (c *command) execute(args []string) error {
+	if len(c.subcommands) > 0 {
+		if len(args) == 0 || isHelpFlag(args[0]) {
+			c.printHelp(os.Stdout)
+			return nil
+		}
+		if strings.HasPrefix(args[0], "-") {
+			c.usageError(fmt.Errorf("unknown flag: %s", args[0]))
+		}
+		sub := c.find(args[0])
+		if sub == nil {
+			c.usageError(fmt.Errorf("unknown command %q", args[0]))
+		}
+		return sub.execute(args[1:])
+	}
+
+	positional, err := c.parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		c.printHelp(os.Stdout)
+		return nil
+	}
+	if err != nil {
+		c.usageError(err)
+	}
+
+	switch {
+	case len(positional) < c.minArgs:
+		c.usageError(fmt.Errorf("expected %s", c.args))
+	case c.maxArgs >= 0 && len(positional) > c.maxArgs:
+		c.usageError(fmt.Errorf("unexpected argument %q", positional[c.maxArgs]))
+	}
+
+	err = c.run(positional)
+	var usage *usageError
+	if errors.As(err, &usage) {
+		c.usageError(usage.err)
+	}
+	return err
+}
+
+// twoArgFlag is implemented by flag values given as two arguments, as in
+// "--report html report.html". The parser passes both to Set as one value
+// joined by "=".
+type twoArgFlag interface {
+	secondArg() string // name of the second argument in help
+}
+
+// joinTwoArgFlags rewrites "--name first second" as "--name=first=second"
+// for flags taking two arguments, leaving everything after "--" alone
+func (c *command) joinTwoArgFlags(args []string) []string {
+	var joined []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(joined, args[i:]...)
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		f := c.flags.Lookup(name)
+		if f != nil && strings.HasPrefix(arg, "-") && i+2 < len(args) && !strings.Contains(args[i+1], "=") {
+			if _, ok := f.Value.(twoArgFlag); ok {
+				joined = append(joined, arg+"="+args[i+1]+"="+args[i+2])
+				i += 2
+				continue
+			}
+		}
+		joined = append(joined, arg)
+	}
+	return joined
+}
+
+// parse parses flags anywhere in args and returns the positional arguments.
+// Everything after "--" is positional.
+func (c *command) parse(args []string) ([]string, error) {
+	args = c.joinTwoArgFlags(args)
+	var positional []string
+	for {
+		if err := c.flags.Parse(args); err != nil {
+			if name, found := strings.CutPrefix(err.Error(), "flag provided but not defined: -"); found {
+				return nil, fmt.Errorf("unknown flag: --%s", strings.TrimPrefix(name, "-"))
+			}
+			return nil, err
+		}
+		rest := c.flags.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// usageError reports a command-line mistake and exits
+func (c *command) usageError(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	fmt.Fprintf(os.Stderr, "Run '%s --help' for usage.\n", c.path())
+	os.Exit(exitUsage)
+}
+
+// isHelpFlag reports whether arg asks for help
+func isHelpFlag(arg string) bool {
+	switch arg {
+	case "-h", "-help", "--help":
+		return true
+	}
+	return false
+}
+
+// printHelp writes the generated help for the command
+func (c *command) printHelp(w io.Writer) {
+	usage := c.path()
+	if len(c.subcommands) > 0 {
+		usage += " <command>"
+	}
+	if c.args != "" {
+		usage += " " + c.args
+	}
+	if hasFlags(c.flags) {
+		usage += " [flags]"
+	}
+	fmt.Fprintf(w, "Usage: %s\n\n", usage)
+	fmt.Fprintln(w, c.summary)
+	if c.details != nil {
+		fmt.Fprintf(w, "\n%s\n", strings.TrimRight(c.details(), "\n"))
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	if len(c.subcommands) > 0 {
+		fmt.Fprintln(table, "\nCommands:")
+		for _, sub := range c.subcommands {
+			fmt.Fprintf(table, "  %s\t%s\n", sub.name, sub.summary)
+		}
+	}
+
+	fmt.Fprintln(table, "\nFlags:")
+	c.flags.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(table, "  %s\t%s\n", flagSynopsis(f), flagDescription(f))
+	})
+	fmt.Fprintf(table, "  -h, --help\tshow help for %s\n", c.name)
+
+	fmt.Fprintln(table, "\nGlobal Flags:")
+	for _, global := range globalFlags {
+		fmt.Fprintf(table, "  --%s <%s>\t%s\n", global.name, global.value, global.usage)
+	}
+	table.Flush()
+
+	if len(c.subcommands) > 0 {
+		fmt.Fprintf(w, "\nRun '%s <command> --help' for more information on a command.\n", c.path())
+	}
+}
+
+// hasFlags reports whether the flag set defines any flag
+func hasFlags(flags *flag.FlagSet) bool {
+	found := false
+	flags.VisitAll(func(*flag.Flag) { found = true })
+	return found
+}
+
+// flagSet reports whether the named flag was given on the command line
+func flagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// flagSynopsis renders "--name <value>", using a `quoted` word of the usage
+// text as the value name
+func flagSynopsis(f *flag.Flag) string {
+	name, _ := flag.UnquoteUsage(f)
+	if name == "" {
+		return "--" + f.Name
+	}
+	if value, ok := f.Value.(twoArgFlag); ok {
+		return fmt.Sprintf("--%s <%s> <%s>", f.Name, name, value.secondArg())
+	}
+	return fmt.Sprintf("--%s <%s>", f.Name, name)
+}
+
+// flagDescription renders the usage text with its default, if it has one
+func flagDescription(f *flag.Flag) string {
+	_, usage := flag.UnquoteUsage(f)
+	switch f.DefValue {
+	case "", "0", "0s", "false":
+		return usage
+	}
+	return fmt.Sprintf("%s (default %s)", usage, f.DefValue)
+}
+
+// flagTakesValue reports whether a flag needs a value
+func flagTakesValue(f *flag.Flag) bool {
+	if boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && boolFlag.IsBoolFlag() {
+		return false
+	}
+	return true
+}
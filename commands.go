@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+)
+
+// newRootCommand builds the command tree. Flag defaults are taken from the
+// loaded configuration, so it must be called after the config is loaded.
+func newRootCommand(source configSource) *command {
+	exchangeNames := newRegistry().List()
+
+	root := newCommand(programName, "", "Manage proxy lists and test them against cryptocurrency exchange APIs.")
+	root.add(
+		newListCommand(),
+		newApiCommand(),
+		newTestCommand(exchangeNames),
+		newHistoryCommand(),
+		newDiffCommand(),
+		newServeCommand(exchangeNames),
+		newScheduleCommand(),
+		newPoolCommand(),
+		newConfigCommand(source),
+		newDoctorCommand(source),
+	)
+	root.add(newCompletionCommand(root), newHelpCommand(root))
+	return root
+}
+
+func newListCommand() *command {
+	cmd := newCommand("list", "", "Download and display the proxy list from PROXY_LIST")
+	cmd.run = func(args []string) error {
+		return handleListCommand()
+	}
+	return cmd
+}
+
+func newApiCommand() *command {
+	cmd := newCommand("api", "", "Fetch the proxy list from the Webshare API, using the cache when present")
+	refresh := cmd.flags.Bool("refresh", false, "ignore the cache and fetch a fresh proxy list")
+	cmd.run = func(args []string) error {
+		return handleApiCommand(*refresh)
+	}
+	return cmd
+}
+
+func newTestCommand(exchangeNames []string) *command {
+	cmd := newCommand("test", "<exchange>...", "Test the cached proxies against exchange APIs")
+	cmd.minArgs, cmd.maxArgs = 1, -1
+	cmd.details = func() string {
+		return fmt.Sprintf("Exchanges: %s, or '*' (quoted) for all", strings.Join(exchangeNames, ", "))
+	}
+	var options testOptions
+	cmd.flags.IntVar(&options.limit, "limit", 0, "test only the first `n` proxies from the cache")
+	cmd.flags.StringVar(&options.exportFile, "export", "", "write the results as JSON to `file` for later diffing")
+	cmd.flags.IntVar(&options.proxyFamily, "proxy-family", 0, "dial the proxies over IP `version` 4 or 6 only and skip proxies with an address of the other version (default: test.proxy_family, else either)")
+	cmd.flags.StringVar(&options.countries, "country", "", "test only proxies in these comma-separated country `codes`")
+	cmd.flags.StringVar(&options.excludeCountries, "exclude-country", "", "skip proxies in these comma-separated country `codes`")
+	cmd.flags.StringVar(&options.ports, "port", "", "test only proxies on these comma-separated `ports` or ranges, e.g. 80,8000-8100")
+	cmd.flags.StringVar(&options.cidrs, "cidr", "", "test only proxies in these comma-separated address `ranges`, e.g. 10.0.0.0/8")
+	cmd.flags.BoolVar(&options.onlyFailed, "only-failed-last-run", false, "test only proxies with a failed test in the last run")
+	cmd.flags.BoolVar(&options.onlyHealthy, "only-healthy-last-run", false, "test only proxies whose tests all passed in the last run")
+	cmd.flags.IntVar(&options.sample, "sample", 0, "test a random sample of `n` proxies after filtering")
+	cmd.flags.Uint64Var(&options.seed, "seed", 0, "random `seed` for --sample, to repeat a selection (default: random, printed)")
+	cmd.flags.BoolVar(&options.stratify, "stratify", false, "sample every country in proportion to its share of the pool")
+	cmd.flags.StringVar(&options.sortBy, "sort", "", "order the report by `key`: latency, country, exchange or proxy (default: completion order)")
+	cmd.flags.StringVar(&options.groupBy, "group-by", "", "split the report into sections by `key`: exchange, country, proxy or family")
+	cmd.flags.BoolVar(&options.matrix, "matrix", false, "report one row per proxy and one column per exchange")
+	options.reports = reportTargets{}
+	cmd.flags.Var(options.reports, "report", "also write the results as a report in `format` html or junit to file, e.g. html report.html (repeatable)")
+	cmd.flags.StringVar(&options.output, "output", "text", "output `format`: text, or junit to print JUnit XML on stdout and the text on stderr")
+	cmd.flags.Float64Var(&options.failUnder, "fail-under", 0, "fail with exit status 6 when fewer than `percent` of the tests pass")
+	cmd.flags.BoolVar(&options.tui, "tui", false, "show a live dashboard while testing (plain output when not on a terminal)")
+	cmd.flagCompletion["output"] = completion{words: testOutputFormats}
+	cmd.argCompletion = completion{words: exchangeNames}
+	cmd.flagCompletion["export"] = completion{files: true}
+	cmd.flagCompletion["sort"] = completion{words: reportSortKeys}
+	cmd.flagCompletion["group-by"] = completion{words: reportGroupKeys}
+	cmd.run = func(args []string) error {
+		if options.limit < 0 {
+			cmd.usageError(fmt.Errorf("invalid --limit %d: must be a positive integer", options.limit))
+		}
+		if flagSet(cmd.flags, "proxy-family") && options.proxyFamily != 4 && options.proxyFamily != 6 {
+			cmd.usageError(fmt.Errorf("invalid --proxy-family %d: must be 4 or 6", options.proxyFamily))
+		}
+		if options.sortBy != "" && !slices.Contains(reportSortKeys, options.sortBy) {
+			cmd.usageError(fmt.Errorf("invalid --sort %q: must be one of %s", options.sortBy, strings.Join(reportSortKeys, ", ")))
+		}
+		if options.groupBy != "" && !slices.Contains(reportGroupKeys, options.groupBy) {
+			cmd.usageError(fmt.Errorf("invalid --group-by %q: must be one of %s", options.groupBy, strings.Join(reportGroupKeys, ", ")))
+		}
+		if options.matrix && options.groupBy != "" && options.groupBy != "country" {
+			cmd.usageError(fmt.Errorf("--matrix can only be grouped by country"))
+		}
+		if !slices.Contains(testOutputFormats, options.output) {
+			cmd.usageError(fmt.Errorf("invalid --output %q: must be one of %s", options.output, strings.Join(testOutputFormats, ", ")))
+		}
+		if options.failUnder < 0 || options.failUnder > 100 {
+			cmd.usageError(fmt.Errorf("invalid --fail-under %g: must be between 0 and 100", options.failUnder))
+		}
+		if !flagSet(cmd.flags, "seed") {
+			options.seed = rand.Uint64()
+		}
+		filter, err := newProxyFilter(options)
+		if err != nil {
+			cmd.usageError(err)
+		}
+		return handleTestCommand(args, options, filter)
+	}
+	return cmd
+}
+
+func newHistoryCommand() *command {
+	cmd := newCommand("history", "[proxy[:port]]", "Show past test runs, one proxy's trend, or proxies that degraded")
+	cmd.maxArgs = 1
+	options := historyOptions{}
+	cmd.flags.IntVar(&options.runs, "runs", 10, "number of most recent `runs` to consider")
+	cmd.flags.BoolVar(&options.degraded, "degraded", false, "list proxies that degraded since the previous run")
+	cmd.flags.Float64Var(&options.latencyIncrease, "latency-increase", 50, "`percent` increase in latency counted as degradation")
+	cmd.run = func(args []string) error {
+		if options.runs <= 0 {
+			cmd.usageError(fmt.Errorf("--runs must be a positive integer"))
+		}
+		if len(args) > 0 {
+			options.proxy = args[0]
+		}
+		return handleHistoryCommand(options)
+	}
+	return cmd
+}
+
+func newDiffCommand() *command {
+	cmd := newCommand("diff", "<runA> <runB>", "Compare two test runs")
+	cmd.minArgs, cmd.maxArgs = 2, 2
+	cmd.details = func() string {
+		return "Runs are run IDs from history, 'latest', 'previous', or files written by 'test --export'."
+	}
+	options := diffOptions{}
+	cmd.flags.StringVar(&options.format, "format", "table", "output `format`: table or json")
+	cmd.flags.Float64Var(&options.latencyThreshold, "latency-threshold", 50, "`percent` latency increase reported as a regression")
+	cmd.argCompletion = completion{words: []string{"latest", "previous"}, files: true}
+	cmd.flagCompletion["format"] = completion{words: []string{"table", "json"}}
+	cmd.run = func(args []string) error {
+		if options.format != "table" && options.format != "json" {
+			cmd.usageError(fmt.Errorf("invalid --format %q: must be table or json", options.format))
+		}
+		return handleDiffCommand(args[0], args[1], options)
+	}
+	return cmd
+}
+
+func newServeCommand(exchangeNames []string) *command {
+	cmd := newCommand("serve", "", "Re-test the pool periodically and expose Prometheus metrics on /metrics")
+	cmd.maxArgs = 0
+
+	// Flag defaults come from the serve section of the configuration. Secrets
+	// fall back to the configuration after parsing so help never shows them.
+	options := appConfig.Serve
+	options.AdminToken, options.ProxyPassword = "", ""
+	cmd.flags.StringVar(&options.Listen, "listen", options.Listen, "`address` for the HTTP server exposing /metrics")
+	cmd.flags.DurationVar(&options.Interval, "interval", options.Interval, "time between test rounds")
+	cmd.flags.StringVar(&options.Exchanges, "exchanges", options.Exchanges, "comma-separated exchanges to test, or * for all")
+	cmd.flags.BoolVar(&options.Refresh, "refresh", options.Refresh, "refresh the proxy list from the provider before every round")
+	cmd.flags.StringVar(&options.AdminToken, "admin-token", "", "bearer `token` for the admin API; the API is disabled when empty (default PROXY_ADMIN_TOKEN)")
+	cmd.flags.StringVar(&options.ProxyListen, "proxy-listen", options.ProxyListen, "`address` for a forward proxy routing through healthy proxies; disabled when empty")
+	cmd.flags.StringVar(&options.ProxyPassword, "proxy-password", "", "`password` clients must send to the forward proxy (default PROXY_FRONTEND_PASSWORD)")
+	cmd.flags.DurationVar(&options.AffinityTTL, "affinity-ttl", options.AffinityTTL, "how long an idle session keeps its upstream proxy")
+	cmd.flags.BoolVar(&options.AffinitySourceIP, "affinity-source-ip", options.AffinitySourceIP, "pin clients without a session key to a proxy by source IP")
+	cmd.flags.StringVar(&options.Rules, "rules", options.Rules, "JSON `file` of routing rules for the forward proxy")
+	cmd.flagCompletion["exchanges"] = completion{words: exchangeNames}
+	cmd.flagCompletion["rules"] = completion{files: true}
+	cmd.run = func(args []string) error {
+		if options.Interval <= 0 {
+			cmd.usageError(fmt.Errorf("--interval must be positive"))
+		}
+		if options.AdminToken == "" {
+			options.AdminToken = appConfig.Serve.AdminToken
+		}
+		if options.ProxyPassword == "" {
+			options.ProxyPassword = appConfig.Serve.ProxyPassword
+		}
+		return handleServeCommand(options)
+	}
+	return cmd
+}
+
+func newScheduleCommand() *command {
+	cmd := newCommand("schedule", "[job...]", "Run the test jobs of the config file on their cron schedules")
+	cmd.maxArgs = -1
+	jobNames := make([]string, len(appConfig.Schedule.Jobs))
+	for i, job := range appConfig.Schedule.Jobs {
+		jobNames[i] = job.Name
+	}
+	cmd.details = func() string {
+		if len(jobNames) == 0 {
+			return "Jobs: none configured; add them under schedule.jobs in the config file"
+		}
+		return fmt.Sprintf("Jobs: %s", strings.Join(jobNames, ", "))
+	}
+	var once bool
+	cmd.flags.BoolVar(&once, "once", false, "run each job now, one after another, and exit")
+	cmd.argCompletion = completion{words: jobNames}
+	cmd.run = func(args []string) error {
+		return handleScheduleCommand(args, once)
+	}
+	return cmd
+}
+
+func newPoolCommand() *command {
+	poolNames := appConfig.poolNames()
+
+	list := newCommand("list", "", "List the pools with the size and age of their caches")
+	list.run = func(args []string) error {
+		return handlePoolList()
+	}
+
+	create := newCommand("create", "<name>", "Create an empty named pool")
+	create.minArgs, create.maxArgs = 1, 1
+	create.run = func(args []string) error {
+		return handlePoolCreate(args[0])
+	}
+
+	merge := newCommand("merge", "<source>... <target>", "Add the proxies of the source pools that the target pool lacks")
+	merge.minArgs, merge.maxArgs = 2, -1
+	merge.argCompletion = completion{words: poolNames}
+	merge.run = func(args []string) error {
+		return handlePoolMerge(args[:len(args)-1], args[len(args)-1])
+	}
+
+	copy := newCommand("copy", "<source> <target>", "Copy the proxies of one pool to another")
+	copy.minArgs, copy.maxArgs = 2, 2
+	force := copy.flags.Bool("force", false, "replace the proxies of an existing target pool")
+	copy.argCompletion = completion{words: poolNames}
+	copy.run = func(args []string) error {
+		return handlePoolCopy(args[0], args[1], *force)
+	}
+
+	stats := newCommand("stats", "[name]", "Show how many proxies each provider contributed and how they overlap")
+	stats.maxArgs = 1
+	stats.argCompletion = completion{words: poolNames}
+	stats.run = func(args []string) error {
+		name := appConfig.pool
+		if len(args) > 0 {
+			name = args[0]
+		}
+		return handlePoolStats(name)
+	}
+
+	remove := newCommand("delete", "<name>", "Delete a named pool's proxy cache")
+	remove.minArgs, remove.maxArgs = 1, 1
+	purge := remove.flags.Bool("purge", false, "also delete the pool's test history and other data")
+	remove.argCompletion = completion{words: poolNames[1:]}
+	remove.run = func(args []string) error {
+		return handlePoolDelete(args[0], *purge)
+	}
+
+	cmd := newCommand("pool", "", "Manage named proxy pools; select one for any command with --pool")
+	cmd.details = func() string {
+		return fmt.Sprintf("Pools: %s", strings.Join(poolNames, ", "))
+	}
+	return cmd.add(list, create, merge, copy, stats, remove)
+}
+
+func newConfigCommand(source configSource) *command {
+	validate := newCommand("validate", "[file]", "Check a config file and all of its profiles for unknown keys and bad values")
+	validate.maxArgs = 1
+	validate.argCompletion = completion{files: true}
+	validate.run = func(args []string) error {
+		path := source.Path
+		if len(args) > 0 {
+			path = args[0]
+		}
+		return handleConfigValidate(path, source.Profile)
+	}
+
+	show := newCommand("show", "[file]", "Print the effective configuration with secrets masked")
+	show.maxArgs = 1
+	show.argCompletion = completion{files: true}
+	show.run = func(args []string) error {
+		path := source.Path
+		if len(args) > 0 {
+			path = args[0]
+		}
+		return handleConfigShow(path, source.Profile, source.Pool)
+	}
+
+	return newCommand("config", "", "Validate or show the configuration").add(validate, show)
+}
+
+func newDoctorCommand(source configSource) *command {
+	cmd := newCommand("doctor", "[command]", "Report where each setting comes from and what each command is missing")
+	cmd.maxArgs = 1
+	var names []string
+	for _, entry := range commandRequirements {
+		names = append(names, entry.command)
+	}
+	cmd.details = func() string {
+		return fmt.Sprintf("With a command (%s), only that command is checked and doctor exits with status 3 if it is not ready.", strings.Join(names, ", "))
+	}
+	cmd.argCompletion = completion{words: names}
+	cmd.run = func(args []string) error {
+		only := ""
+		if len(args) > 0 {
+			only = args[0]
+			if !slices.Contains(names, only) {
+				cmd.usageError(fmt.Errorf("unknown command %q: must be one of %s", only, strings.Join(names, ", ")))
+			}
+		}
+		return handleDoctorCommand(source, only)
+	}
+	return cmd
+}
+
+func newCompletionCommand(root *command) *command {
+	shells := []string{"bash", "zsh", "fish"}
+	cmd := newCommand("completion", "<bash|zsh|fish>", "Print a shell completion script")
+	cmd.minArgs, cmd.maxArgs = 1, 1
+	cmd.details = func() string {
+		return strings.Join([]string{
+			"bash: source <(go-proxy completion bash)",
+			"zsh:  source <(go-proxy completion zsh)",
+			"fish: go-proxy completion fish | source",
+		}, "\n")
+	}
+	cmd.argCompletion = completion{words: shells}
+	cmd.run = func(args []string) error {
+		switch args[0] {
+		case "bash":
+			writeBashCompletion(os.Stdout, root)
+		case "zsh":
+			writeZshCompletion(os.Stdout, root)
+		case "fish":
+			writeFishCompletion(os.Stdout, root)
+		default:
+			cmd.usageError(fmt.Errorf("unsupported shell %q: must be one of %s", args[0], strings.Join(shells, ", ")))
+		}
+		return nil
+	}
+	return cmd
+}
+
+func newHelpCommand(root *command) *command {
+	cmd := newCommand("help", "[command]...", "Show help for a command")
+	cmd.maxArgs = -1
+	for _, sub := range root.subcommands {
+		cmd.argCompletion.words = append(cmd.argCompletion.words, sub.name)
+	}
+	cmd.run = func(args []string) error {
+		target := root
+		for _, name := range args {
+			sub := target.find(name)
+			if sub == nil {
+				cmd.usageError(fmt.Errorf("unknown command %q", strings.Join(args, " ")))
+			}
+			target = sub
+		}
+		target.printHelp(os.Stdout)
+		return nil
+	}
+	return cmd
+}
+
+// testOptions are the flags of the test command
+type testOptions struct {
+	limit       int // 0 means no limit
+	exportFile  string
+	proxyFamily int // 4 or 6 overrides test.proxy_family
+
+	// Proxy selection, see proxyFilter
+	countries, excludeCountries string
+	ports, cidrs                string
+	onlyFailed, onlyHealthy     bool
+	sample                      int
+	seed                        uint64
+	stratify                    bool
+
+	// Report layout
+	sortBy, groupBy string
+	matrix          bool
+	reports         reportTargets // report files by format
+	output          string
+	failUnder       float64 // minimum success rate in percent; 0 disables the check
+	tui             bool
+}
+
+// Output formats of the test command
+var testOutputFormats = []string{"text", "junit"}
+
+// historyOptions are the arguments of the history command
+type historyOptions struct {
+	proxy           string
+	runs            int
+	degraded        bool
+	latencyIncrease float64
+}
+
+// diffOptions are the flags of the diff command
+type diffOptions struct {
+	format           string
+	latencyThreshold float64
+}
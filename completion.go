@@ -0,0 +1,277 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// walkCommands calls visit for cmd and every command below it
+func walkCommands(cmd *command, visit func(*command)) {
+	visit(cmd)
+	for _, sub := range cmd.subcommands {
+		walkCommands(sub, visit)
+	}
+}
+
+// completionKey identifies a command in the generated scripts: its path
+// without the program name, joined by "/", e.g. "/config/validate"
+func completionKey(cmd *command) string {
+	if cmd.parent == nil {
+		return ""
+	}
+	return completionKey(cmd.parent) + "/" + cmd.name
+}
+
+// completionWords returns the words offered for cmd: subcommands, flags and
+// fixed positional candidates
+func completionWords(cmd *command) []string {
+	var words []string
+	for _, sub := range cmd.subcommands {
+		words = append(words, sub.name)
+	}
+	cmd.flags.VisitAll(func(f *flag.Flag) {
+		words = append(words, "--"+f.Name)
+	})
+	words = append(words, "--help")
+	for _, global := range globalFlags {
+		words = append(words, "--"+global.name)
+	}
+	return append(words, cmd.argCompletion.words...)
+}
+
+// valueFlags returns the flags of cmd that take a value, with their
+// completions, in name order
+func valueFlags(cmd *command) ([]string, map[string]completion) {
+	completions := make(map[string]completion)
+	cmd.flags.VisitAll(func(f *flag.Flag) {
+		if flagTakesValue(f) {
+			completions[f.Name] = cmd.flagCompletion[f.Name]
+		}
+	})
+	names := make([]string, 0, len(completions))
+	for name := range completions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, completions
+}
+
+// shellQuote quotes a word for bash and zsh
+func shellQuote(word string) string {
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// quoteWords quotes every word and joins them with spaces
+func quoteWords(words []string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = shellQuote(word)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// writeBashCompletion writes a bash completion script for the command tree
+func writeBashCompletion(w io.Writer, root *command) {
+	fmt.Fprintf(w, "# bash completion for %s\n", programName)
+	fmt.Fprintf(w, "# Load with: source <(%s completion bash)\n\n", programName)
+	fmt.Fprintln(w, "_go_proxy() {")
+	fmt.Fprintln(w, `    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"`)
+	fmt.Fprintln(w, `    local cmdpath="" word i`)
+	fmt.Fprintln(w, `    local -a candidates`)
+	fmt.Fprintln(w, `    for ((i = 1; i < COMP_CWORD; i++)); do`)
+	fmt.Fprintln(w, `        word="${COMP_WORDS[i]}"`)
+	fmt.Fprintln(w, `        case "$cmdpath/$word" in`)
+	var paths []string
+	walkCommands(root, func(cmd *command) {
+		if cmd != root {
+			paths = append(paths, completionKey(cmd))
+		}
+	})
+	fmt.Fprintf(w, "            %s) cmdpath=\"$cmdpath/$word\" ;;\n", strings.Join(paths, "|"))
+	fmt.Fprintln(w, `        esac`)
+	fmt.Fprintln(w, `    done`)
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, `    case "$cmdpath:$prev" in`)
+	for _, global := range globalFlags {
+		writeBashValueCase(w, "*:--"+global.name, global.completion)
+	}
+	walkCommands(root, func(cmd *command) {
+		names, completions := valueFlags(cmd)
+		for _, name := range names {
+			writeBashValueCase(w, completionKey(cmd)+":--"+name, completions[name])
+		}
+	})
+	fmt.Fprintln(w, `    esac`)
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, `    case "$cmdpath" in`)
+	walkCommands(root, func(cmd *command) {
+		fmt.Fprintf(w, "        %s) candidates=(%s) ;;\n", shellQuote(completionKey(cmd)), quoteWords(completionWords(cmd)))
+	})
+	fmt.Fprintln(w, `    esac`)
+	fmt.Fprintln(w, `    COMPREPLY=($(compgen -W "${candidates[*]}" -- "$cur"))`)
+	walkCommands(root, func(cmd *command) {
+		if cmd.argCompletion.files {
+			fmt.Fprintf(w, "    [[ $cmdpath == %s && $cur != -* ]] && COMPREPLY+=($(compgen -f -- \"$cur\"))\n", shellQuote(completionKey(cmd)))
+		}
+	})
+	fmt.Fprintln(w, "}")
+	fmt.Fprintf(w, "complete -F _go_proxy %s\n", programName)
+}
+
+// writeBashValueCase writes the completion of one flag value
+func writeBashValueCase(w io.Writer, pattern string, values completion) {
+	switch {
+	case values.files:
+		fmt.Fprintf(w, "        %s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", pattern)
+	case len(values.words) > 0:
+		fmt.Fprintf(w, "        %s) COMPREPLY=($(compgen -W %s -- \"$cur\")); return ;;\n", pattern, shellQuote(strings.Join(values.words, " ")))
+	default:
+		fmt.Fprintf(w, "        %s) COMPREPLY=(); return ;;\n", pattern)
+	}
+}
+
+// writeZshCompletion writes a zsh completion script for the command tree
+func writeZshCompletion(w io.Writer, root *command) {
+	fmt.Fprintf(w, "#compdef %s\n", programName)
+	fmt.Fprintf(w, "# zsh completion for %s\n", programName)
+	fmt.Fprintf(w, "# Load with: source <(%s completion zsh)\n\n", programName)
+	fmt.Fprintln(w, "_go_proxy() {")
+	fmt.Fprintln(w, `    local cmdpath="" word i prev="${words[CURRENT-1]}"`)
+	fmt.Fprintln(w, `    local -a candidates`)
+	fmt.Fprintln(w, `    for ((i = 2; i < CURRENT; i++)); do`)
+	fmt.Fprintln(w, `        word="${words[i]}"`)
+	fmt.Fprintln(w, `        case "$cmdpath/$word" in`)
+	var paths []string
+	walkCommands(root, func(cmd *command) {
+		if cmd != root {
+			paths = append(paths, completionKey(cmd))
+		}
+	})
+	fmt.Fprintf(w, "            %s) cmdpath=\"$cmdpath/$word\" ;;\n", strings.Join(paths, "|"))
+	fmt.Fprintln(w, `        esac`)
+	fmt.Fprintln(w, `    done`)
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, `    case "$cmdpath:$prev" in`)
+	for _, global := range globalFlags {
+		writeZshValueCase(w, "*:--"+global.name, global.completion)
+	}
+	walkCommands(root, func(cmd *command) {
+		names, completions := valueFlags(cmd)
+		for _, name := range names {
+			writeZshValueCase(w, completionKey(cmd)+":--"+name, completions[name])
+		}
+	})
+	fmt.Fprintln(w, `    esac`)
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, `    case "$cmdpath" in`)
+	walkCommands(root, func(cmd *command) {
+		fmt.Fprintf(w, "        %s) candidates=(%s) ;;\n", shellQuote(completionKey(cmd)), quoteWords(completionWords(cmd)))
+	})
+	fmt.Fprintln(w, `    esac`)
+	fmt.Fprintln(w, `    compadd -Q -- "${candidates[@]}"`)
+	walkCommands(root, func(cmd *command) {
+		if cmd.argCompletion.files {
+			fmt.Fprintf(w, "    [[ $cmdpath == %s ]] && _files\n", shellQuote(completionKey(cmd)))
+		}
+	})
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "compdef _go_proxy %s\n", programName)
+}
+
+// writeZshValueCase writes the completion of one flag value
+func writeZshValueCase(w io.Writer, pattern string, values completion) {
+	switch {
+	case values.files:
+		fmt.Fprintf(w, "        %s) _files; return ;;\n", pattern)
+	case len(values.words) > 0:
+		fmt.Fprintf(w, "        %s) compadd -Q -- %s; return ;;\n", pattern, quoteWords(values.words))
+	default:
+		fmt.Fprintf(w, "        %s) return ;;\n", pattern)
+	}
+}
+
+// writeFishCompletion writes a fish completion script for the command tree
+func writeFishCompletion(w io.Writer, root *command) {
+	fmt.Fprintf(w, "# fish completion for %s\n", programName)
+	fmt.Fprintf(w, "# Load with: %s completion fish | source\n\n", programName)
+	fmt.Fprintf(w, "complete -c %s -f\n", programName)
+	for _, global := range globalFlags {
+		options := " -x"
+		if global.completion.files {
+			options = " -r -F"
+		}
+		fmt.Fprintf(w, "complete -c %s -l %s%s -d %s\n", programName, global.name, options, fishQuote(global.usage))
+	}
+	fmt.Fprintf(w, "complete -c %s -s h -l help -d 'Show help'\n", programName)
+
+	walkCommands(root, func(cmd *command) {
+		condition := fishCondition(cmd)
+		for _, sub := range cmd.subcommands {
+			fmt.Fprintf(w, "complete -c %s -n %s -a %s -d %s\n",
+				programName, fishQuote(condition), sub.name, fishQuote(sub.summary))
+		}
+		if cmd == root {
+			return
+		}
+
+		cmd.flags.VisitAll(func(f *flag.Flag) {
+			_, usage := flag.UnquoteUsage(f)
+			options := ""
+			if flagTakesValue(f) {
+				values := cmd.flagCompletion[f.Name]
+				switch {
+				case values.files:
+					options = " -r -F"
+				case len(values.words) > 0:
+					options = " -x -a " + fishQuote(strings.Join(values.words, " "))
+				default:
+					options = " -x"
+				}
+			}
+			fmt.Fprintf(w, "complete -c %s -n %s -l %s%s -d %s\n",
+				programName, fishQuote(condition), f.Name, options, fishQuote(usage))
+		})
+		if len(cmd.argCompletion.words) > 0 {
+			fmt.Fprintf(w, "complete -c %s -n %s -a %s\n",
+				programName, fishQuote(condition), fishQuote(strings.Join(cmd.argCompletion.words, " ")))
+		}
+		if cmd.argCompletion.files {
+			fmt.Fprintf(w, "complete -c %s -n %s -F\n", programName, fishQuote(condition))
+		}
+	})
+}
+
+// fishCondition returns the fish condition that holds while completing
+// arguments of cmd
+func fishCondition(cmd *command) string {
+	if cmd.parent == nil {
+		return "__fish_use_subcommand"
+	}
+
+	var conditions []string
+	for c := cmd; c.parent != nil; c = c.parent {
+		conditions = append([]string{"__fish_seen_subcommand_from " + c.name}, conditions...)
+	}
+	if len(cmd.subcommands) > 0 {
+		names := make([]string, len(cmd.subcommands))
+		for i, sub := range cmd.subcommands {
+			names[i] = sub.name
+		}
+		conditions = append(conditions, "not __fish_seen_subcommand_from "+strings.Join(names, " "))
+	}
+	return strings.Join(conditions, "; and ")
+}
+
+// fishQuote quotes a string for fish
+func fishQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return "'" + strings.ReplaceAll(value, "'", `\'`) + "'"
+}
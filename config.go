@@ -0,0 +1,775 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-proxy/exchanges"
+	"go-proxy/proxypool"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config file names looked up when --config and PROXY_CONFIG are not set
+const (
+	localConfigFile = "go-proxy.yaml"
+	userConfigFile  = "config.yaml"
+)
+
+// cacheFileName is the name of the proxy cache in the cache directory
+const cacheFileName = "proxy_cache.json"
+
+// appConfig is the effective configuration, loaded by main before any command runs
+var appConfig = defaultConfig()
+
+// config is the effective configuration. It is built in layers, each
+// overriding the previous one: defaults, the config file, the selected
+// profile, the provider settings of the selected pool, environment
+// variables, and finally command-line flags, which each command applies
+// itself.
+type config struct {
+	Provider providerConfig        `yaml:"provider"`
+	Proxy    proxyConfig           `yaml:"proxy"`
+	Test     testConfig            `yaml:"test"`
+	Serve    serveConfig           `yaml:"serve"`
+	Schedule scheduleConfig        `yaml:"schedule,omitempty"`
+	Notify   notifyConfig          `yaml:"notify,omitempty"`
+	History  historyConfig         `yaml:"history"`
+	Pools    map[string]poolConfig `yaml:"pools,omitempty"`
+	DataDir  string                `yaml:"data_dir,omitempty"`
+
+	// The pool selected by usePool, and the provider settings it overrides
+	pool         string         `yaml:"-"`
+	baseProvider providerConfig `yaml:"-"`
+}
+
+// providerConfig is where proxy lists come from
+type providerConfig struct {
+	ListURL     string `yaml:"list_url,omitempty"`
+	APIKey      string `yaml:"api_key,omitempty"`
+	WebshareURL string `yaml:"webshare_url"`
+	CacheFile   string `yaml:"cache_file"`
+
+	// Further plain-text lists by name, and the providers api --refresh
+	// combines, highest priority first (default: webshare)
+	Lists   map[string]string `yaml:"lists,omitempty"`
+	Sources []string          `yaml:"sources,omitempty"`
+}
+
+// poolConfig holds the settings of one named pool. Empty provider values
+// inherit from the provider section, except the cache file, which defaults
+// to pools/<name>.json next to the default pool's cache. Lists and sources
+// replace those of the provider section rather than adding to them.
+type poolConfig struct {
+	Provider providerConfig `yaml:"provider,omitempty"`
+}
+
+// proxyConfig holds the credentials sent to every proxy
+type proxyConfig struct {
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+}
+
+// testConfig tunes the exchange testers and the fan-out
+type testConfig struct {
+	Timeout             time.Duration             `yaml:"timeout"`
+	Concurrency         int                       `yaml:"concurrency"`
+	ExchangeConcurrency int                       `yaml:"exchange_concurrency"`
+	ProxyConcurrency    int                       `yaml:"proxy_concurrency"`
+	Rate                float64                   `yaml:"rate"`
+	Burst               int                       `yaml:"burst"`
+	ProxyFamily         int                       `yaml:"proxy_family"` // 4 or 6 dials proxies over that IP version only; 0 allows either
+	Exchanges           map[string]exchangeConfig `yaml:"exchanges,omitempty"`
+}
+
+// exchangeConfig overrides the test settings of one exchange; zero values
+// inherit from the test section
+type exchangeConfig struct {
+	URL     string        `yaml:"url,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	Rate    float64       `yaml:"rate,omitempty"`
+	Burst   int           `yaml:"burst,omitempty"`
+}
+
+// serveConfig holds the defaults of the serve command's flags
+type serveConfig struct {
+	Listen           string        `yaml:"listen"`
+	Interval         time.Duration `yaml:"interval"`
+	Exchanges        string        `yaml:"exchanges"`
+	Refresh          bool          `yaml:"refresh"`
+	AdminToken       string        `yaml:"admin_token,omitempty"`
+	ProxyListen      string        `yaml:"proxy_listen,omitempty"`
+	ProxyPassword    string        `yaml:"proxy_password,omitempty"`
+	AffinityTTL      time.Duration `yaml:"affinity_ttl"`
+	AffinitySourceIP bool          `yaml:"affinity_source_ip"`
+	Rules            string        `yaml:"rules,omitempty"`
+}
+
+// historyConfig bounds the test history of each pool
+type historyConfig struct {
+	MaxRuns int `yaml:"max_runs"` // runs kept, newest first; 0 keeps every run
+}
+
+// scheduleConfig holds the jobs run by the schedule command
+type scheduleConfig struct {
+	Jobs []jobConfig `yaml:"jobs,omitempty"`
+}
+
+// jobConfig is one scheduled test run. The selection keys mirror the flags
+// of the test command.
+type jobConfig struct {
+	Name      string        `yaml:"name"`
+	Cron      string        `yaml:"cron"`
+	Timeout   time.Duration `yaml:"timeout,omitempty"` // 0 means defaultJobTimeout
+	Refresh   bool          `yaml:"refresh,omitempty"`
+	Exchanges string        `yaml:"exchanges,omitempty"` // comma-separated, or * for all (the default)
+
+	Limit              int    `yaml:"limit,omitempty"`
+	Country            string `yaml:"country,omitempty"`
+	ExcludeCountry     string `yaml:"exclude_country,omitempty"`
+	Port               string `yaml:"port,omitempty"`
+	CIDR               string `yaml:"cidr,omitempty"`
+	OnlyFailedLastRun  bool   `yaml:"only_failed_last_run,omitempty"`
+	OnlyHealthyLastRun bool   `yaml:"only_healthy_last_run,omitempty"`
+	Sample             int    `yaml:"sample,omitempty"`
+	Seed               uint64 `yaml:"seed,omitempty"` // 0 draws a new sample every run
+	Stratify           bool   `yaml:"stratify,omitempty"`
+
+	Export  string `yaml:"export,omitempty"`  // file name; {run} is replaced by the run ID
+	Webhook string `yaml:"webhook,omitempty"` // URL to POST a summary of every run to
+}
+
+// notifyConfig holds the alert rules and the channels they notify
+type notifyConfig struct {
+	Repeat   time.Duration     `yaml:"repeat,omitempty"` // remind while a rule keeps firing; 0 notifies once
+	Channels []channelConfig   `yaml:"channels,omitempty"`
+	Rules    []alertRuleConfig `yaml:"rules,omitempty"`
+}
+
+// channelConfig is one place notifications are sent to
+type channelConfig struct {
+	Name   string `yaml:"name"`
+	Type   string `yaml:"type"`             // webhook, slack or telegram
+	URL    string `yaml:"url,omitempty"`    // webhook URL, or the Bot API endpoint for telegram
+	Secret string `yaml:"secret,omitempty"` // HMAC key signing webhook requests
+	Token  string `yaml:"token,omitempty"`  // telegram bot token
+	ChatID string `yaml:"chat_id,omitempty"`
+}
+
+// alertRuleConfig is a health threshold checked after every test run.
+// Exactly one of MinHealthy and MaxFailurePercent is set.
+type alertRuleConfig struct {
+	Name              string   `yaml:"name"`
+	Exchange          string   `yaml:"exchange,omitempty"` // empty covers every exchange
+	Country           string   `yaml:"country,omitempty"`  // empty covers every country
+	MinHealthy        int      `yaml:"min_healthy,omitempty"`
+	MaxFailurePercent float64  `yaml:"max_failure_percent,omitempty"`
+	MinTests          int      `yaml:"min_tests,omitempty"` // tests needed before a failure rate counts
+	Channels          []string `yaml:"channels,omitempty"`  // empty notifies every channel
+}
+
+// configFile is the layout of the config file: the base settings plus
+// named profiles that override them
+type configFile struct {
+	config   `yaml:",inline"`
+	Profiles map[string]yaml.Node `yaml:"profiles,omitempty"`
+}
+
+// configSource records where the effective configuration came from
+type configSource struct {
+	Path     string // empty when no config file was found
+	Profile  string
+	Pool     string
+	EnvFiles []envFileStatus
+}
+
+// defaultConfig returns the built-in defaults
+func defaultConfig() *config {
+	return &config{
+		Provider: providerConfig{
+			WebshareURL: proxypool.DefaultWebshareURL,
+			CacheFile:   defaultCacheFile(),
+		},
+		Test: testConfig{
+			Timeout:     exchanges.DefaultTimeout,
+			Concurrency: 10,
+			Burst:       1,
+		},
+		Serve: serveConfig{
+			Listen:      ":9090",
+			Interval:    5 * time.Minute,
+			Exchanges:   "*",
+			AffinityTTL: proxypool.DefaultAffinityTTL,
+		},
+		History: historyConfig{
+			MaxRuns: defaultHistoryRuns,
+		},
+		pool: defaultPoolName,
+	}
+}
+
+// defaultCacheFile returns the proxy cache path in the user cache directory,
+// $XDG_CACHE_HOME/go-proxy or ~/.cache/go-proxy on Linux, or in the working
+// directory when there is none
+func defaultCacheFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return cacheFileName
+	}
+	return filepath.Join(dir, "go-proxy", cacheFileName)
+}
+
+// findConfigFile returns the config file to load: the explicit path, then
+// PROXY_CONFIG, then ./go-proxy.yaml, then the user config directory. It
+// returns "" when no file is configured and none of the defaults exist.
+func findConfigFile(explicit string) string {
+	if explicit != "" {
+		return explicit
+	}
+	if path := os.Getenv("PROXY_CONFIG"); path != "" {
+		return path
+	}
+	if _, err := os.Stat(localConfigFile); err == nil {
+		return localConfigFile
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		path := filepath.Join(dir, "go-proxy", userConfigFile)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// loadConfig builds the effective configuration from the defaults, the
+// config file at path (if any), the named profile, the settings of the named
+// pool, and the environment. Unknown keys in the file are returned as
+// warnings. The caller resolves PROXY_PROFILE and PROXY_POOL into profile
+// and pool.
+func loadConfig(path, profile, pool string) (*config, []string, error) {
+	cfg := defaultConfig()
+	var warnings []string
+	if path != "" {
+		file, problems, err := readConfigFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		warnings = problems
+		*cfg = file.config
+
+		if profile != "" {
+			node, exists := file.Profiles[profile]
+			if !exists {
+				return nil, nil, fmt.Errorf("profile '%s' not found in %s (available: %s)", profile, path, profileNames(file))
+			}
+			if err := node.Decode(cfg); err != nil {
+				return nil, nil, fmt.Errorf("profile '%s': %v", profile, err)
+			}
+		}
+	} else if profile != "" {
+		return nil, nil, fmt.Errorf("profile '%s' requested but no config file was found", profile)
+	}
+
+	// The environment applies to the shared provider settings, from which the
+	// pool's are derived, and poolProvider applies it again over the pool's own
+	applyEnv(cfg)
+	if err := cfg.usePool(pool); err != nil {
+		return nil, nil, err
+	}
+	return cfg, warnings, nil
+}
+
+// readConfigFile parses the config file on top of the defaults and lists its unknown keys
+func readConfigFile(path string) (*configFile, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file := &configFile{config: *defaultConfig()}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(root.Content) == 0 {
+		return file, nil, nil
+	}
+	if err := root.Decode(file); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var unknown []string
+	checkKeys(root.Content[0], reflect.TypeOf(configFile{}), "", &unknown)
+	return file, unknown, nil
+}
+
+// profileNames lists the profiles defined in a config file
+func profileNames(file *configFile) string {
+	if len(file.Profiles) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(file.Profiles))
+	for name := range file.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// checkKeys walks a YAML mapping alongside the struct type it decodes into
+// and reports every key the type does not know. Profiles are checked against
+// the base config.
+func checkKeys(node *yaml.Node, t reflect.Type, path string, unknown *[]string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if node.Kind == yaml.SequenceNode && t.Kind() == reflect.Slice {
+		for i, item := range node.Content {
+			checkKeys(item, t.Elem(), fmt.Sprintf("%s%d.", path, i), unknown)
+		}
+		return
+	}
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Map:
+		elem := t.Elem()
+		if elem == reflect.TypeOf(yaml.Node{}) {
+			elem = reflect.TypeOf(config{})
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			checkKeys(node.Content[i+1], elem, path+node.Content[i].Value+".", unknown)
+		}
+	case reflect.Struct:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			field, known := fields[key.Value]
+			if !known {
+				*unknown = append(*unknown, fmt.Sprintf("line %d: unknown key '%s%s'", key.Line, path, key.Value))
+				continue
+			}
+			checkKeys(node.Content[i+1], field, path+key.Value+".", unknown)
+		}
+	}
+}
+
+// yamlFields maps the YAML keys of a struct, including inlined structs, to their types
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if strings.Contains(options, "inline") {
+			for key, value := range yamlFields(field.Type) {
+				fields[key] = value
+			}
+			continue
+		}
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// applyEnv overrides the configuration with the environment variables the
+// tool has always read
+func applyEnv(cfg *config) {
+	applyProviderEnv(&cfg.Provider)
+	cfg.Provider.CacheFile = envString("PROXY_CACHE_FILE", cfg.Provider.CacheFile)
+	cfg.Proxy.Username = envString("PROXY_USER", cfg.Proxy.Username)
+	cfg.Proxy.Password = envString("PROXY_PASS", cfg.Proxy.Password)
+	cfg.DataDir = envString("PROXY_DATA_DIR", cfg.DataDir)
+	cfg.Serve.AdminToken = envString("PROXY_ADMIN_TOKEN", cfg.Serve.AdminToken)
+	cfg.Serve.ProxyPassword = envString("PROXY_FRONTEND_PASSWORD", cfg.Serve.ProxyPassword)
+
+	cfg.Test.Concurrency = envInt("PROXY_TEST_CONCURRENCY", cfg.Test.Concurrency)
+	cfg.Test.ExchangeConcurrency = envInt("PROXY_TEST_EXCHANGE_CONCURRENCY", cfg.Test.ExchangeConcurrency)
+	cfg.Test.ProxyConcurrency = envInt("PROXY_TEST_PROXY_CONCURRENCY", cfg.Test.ProxyConcurrency)
+	cfg.Test.Rate = envFloat("PROXY_TEST_RATE", cfg.Test.Rate)
+	cfg.Test.Burst = envInt("PROXY_TEST_BURST", cfg.Test.Burst)
+	cfg.Test.ProxyFamily = envInt("PROXY_TEST_PROXY_FAMILY", cfg.Test.ProxyFamily)
+	cfg.History.MaxRuns = envInt("PROXY_HISTORY_MAX_RUNS", cfg.History.MaxRuns)
+
+	for _, name := range exchanges.NewRegistry().List() {
+		suffix := strings.ToUpper(name)
+		exchange := cfg.Test.Exchanges[name]
+		exchange.Rate = envFloat("PROXY_TEST_RATE_"+suffix, exchange.Rate)
+		exchange.Burst = envInt("PROXY_TEST_BURST_"+suffix, exchange.Burst)
+		if exchange != (exchangeConfig{}) {
+			if cfg.Test.Exchanges == nil {
+				cfg.Test.Exchanges = make(map[string]exchangeConfig)
+			}
+			cfg.Test.Exchanges[name] = exchange
+		}
+	}
+}
+
+// applyProviderEnv overrides the provider settings that a pool can also set
+// with the environment
+func applyProviderEnv(provider *providerConfig) {
+	provider.ListURL = envString("PROXY_LIST", provider.ListURL)
+	provider.APIKey = envString("PROXY_API", provider.APIKey)
+}
+
+// envString returns a variable from the environment, or def if unset or empty
+func envString(name string, def string) string {
+	if val := os.Getenv(name); val != "" {
+		return val
+	}
+	return def
+}
+
+// envInt returns a positive integer from the environment, or def if unset or invalid
+func envInt(name string, def int) int {
+	if val := os.Getenv(name); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			return n
+		}
+	}
+	return def
+}
+
+// envFloat returns a positive number from the environment, or def if unset or invalid
+func envFloat(name string, def float64) float64 {
+	if val := os.Getenv(name); val != "" {
+		if n, err := strconv.ParseFloat(val, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return def
+}
+
+// validate reports values that are out of range or malformed
+func (c *config) validate() []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	checkURL := func(key, value string) {
+		if value == "" {
+			return
+		}
+		parsed, err := url.Parse(value)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			report("%s: '%s' is not an http(s) URL", key, value)
+		}
+	}
+	shared := c.sharedProvider()
+	validateProvider("provider", shared, shared.Lists, report, checkURL)
+	if shared.CacheFile == "" {
+		report("provider.cache_file: must not be empty")
+	}
+
+	if c.Test.Timeout <= 0 {
+		report("test.timeout: must be positive, got %s", c.Test.Timeout)
+	}
+	if c.Test.Concurrency <= 0 {
+		report("test.concurrency: must be positive, got %d", c.Test.Concurrency)
+	}
+	if c.Test.ExchangeConcurrency < 0 {
+		report("test.exchange_concurrency: must not be negative, got %d", c.Test.ExchangeConcurrency)
+	}
+	if c.Test.ProxyConcurrency < 0 {
+		report("test.proxy_concurrency: must not be negative, got %d", c.Test.ProxyConcurrency)
+	}
+	if c.Test.Rate < 0 {
+		report("test.rate: must not be negative, got %g", c.Test.Rate)
+	}
+	if c.Test.Burst <= 0 {
+		report("test.burst: must be positive, got %d", c.Test.Burst)
+	}
+	if c.Test.ProxyFamily != 0 && c.Test.ProxyFamily != 4 && c.Test.ProxyFamily != 6 {
+		report("test.proxy_family: must be 0, 4 or 6, got %d", c.Test.ProxyFamily)
+	}
+	if c.History.MaxRuns < 0 {
+		report("history.max_runs: must not be negative, got %d", c.History.MaxRuns)
+	}
+
+	known := exchanges.NewRegistry().List()
+	names := make([]string, 0, len(c.Test.Exchanges))
+	for name := range c.Test.Exchanges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		exchange := c.Test.Exchanges[name]
+		key := "test.exchanges." + name
+		if !slices.Contains(known, name) {
+			report("%s: unknown exchange (available: %s)", key, strings.Join(known, ", "))
+		}
+		checkURL(key+".url", exchange.URL)
+		if exchange.Timeout < 0 {
+			report("%s.timeout: must not be negative, got %s", key, exchange.Timeout)
+		}
+		if exchange.Rate < 0 {
+			report("%s.rate: must not be negative, got %g", key, exchange.Rate)
+		}
+		if exchange.Burst < 0 {
+			report("%s.burst: must not be negative, got %d", key, exchange.Burst)
+		}
+	}
+
+	if c.Serve.Interval <= 0 {
+		report("serve.interval: must be positive, got %s", c.Serve.Interval)
+	}
+	if c.Serve.AffinityTTL < 0 {
+		report("serve.affinity_ttl: must not be negative, got %s", c.Serve.AffinityTTL)
+	}
+	if c.Serve.Exchanges != "*" {
+		for _, name := range strings.Split(c.Serve.Exchanges, ",") {
+			name = strings.TrimSpace(name)
+			if !slices.Contains(known, name) {
+				report("serve.exchanges: unknown exchange '%s'", name)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, job := range c.Schedule.Jobs {
+		key := fmt.Sprintf("schedule.jobs.%d", i)
+		if job.Name == "" {
+			report("%s.name: must not be empty", key)
+		} else if seen[job.Name] {
+			report("%s.name: duplicate job '%s'", key, job.Name)
+		}
+		seen[job.Name] = true
+		if schedule, err := parseCron(job.Cron); err != nil {
+			report("%s.cron: %v", key, err)
+		} else if schedule.next(time.Now()).IsZero() {
+			report("%s.cron: '%s' never fires", key, job.Cron)
+		}
+		if job.Timeout < 0 {
+			report("%s.timeout: must not be negative, got %s", key, job.Timeout)
+		}
+		if job.Limit < 0 {
+			report("%s.limit: must not be negative, got %d", key, job.Limit)
+		}
+		if job.Exchanges != "" && job.Exchanges != "*" {
+			for _, name := range splitList(job.Exchanges) {
+				if !slices.Contains(known, name) {
+					report("%s.exchanges: unknown exchange '%s'", key, name)
+				}
+			}
+		}
+		if _, err := newProxyFilter(job.testOptions()); err != nil {
+			report("%s: %v", key, err)
+		}
+		checkURL(key+".webhook", job.Webhook)
+	}
+
+	validateNotify(c.Notify, report, checkURL)
+
+	pools := make([]string, 0, len(c.Pools))
+	for name := range c.Pools {
+		pools = append(pools, name)
+	}
+	sort.Strings(pools)
+	for _, name := range pools {
+		key := "pools." + name
+		if err := checkPoolName(name); err != nil {
+			report("%s: %v", key, err)
+		}
+		validateProvider(key+".provider", c.Pools[name].Provider, c.poolProvider(name).Lists, report, checkURL)
+	}
+
+	return problems
+}
+
+// withCredentials returns proxies with the configured credentials given to
+// those listed without their own. The proxies are copied, so the cache and
+// the provider's listing keep them as listed.
+func (p proxyConfig) withCredentials(proxies []proxypool.Proxy) []proxypool.Proxy {
+	if p.Username == "" || p.Password == "" {
+		return proxies
+	}
+	filled := make([]proxypool.Proxy, len(proxies))
+	for i, proxy := range proxies {
+		if proxy.Username == "" {
+			proxy.Username, proxy.Password = p.Username, p.Password
+		}
+		filled[i] = proxy
+	}
+	return filled
+}
+
+// testerOptions returns the endpoint, timeout and proxy family for one exchange
+func (c *config) testerOptions(name string) exchanges.TesterOptions {
+	exchange := c.Test.Exchanges[name]
+	options := exchanges.TesterOptions{URL: exchange.URL, Timeout: c.Test.Timeout, ProxyFamily: c.Test.ProxyFamily, Logger: logger}
+	if exchange.Timeout > 0 {
+		options.Timeout = exchange.Timeout
+	}
+	return options
+}
+
+// newRegistry creates the exchange registry with the configured endpoints and timeouts
+func newRegistry() *exchanges.Registry {
+	registry := exchanges.NewRegistry()
+	for _, name := range registry.List() {
+		tester, _ := registry.Get(name)
+		if configurable, ok := tester.(exchanges.Configurable); ok {
+			configurable.Configure(appConfig.testerOptions(name))
+		}
+	}
+	return registry
+}
+
+// redacted returns a copy of the configuration with secrets masked
+func (c *config) redacted() config {
+	copy := *c
+	mask := func(value *string) {
+		if *value != "" {
+			*value = "********"
+		}
+	}
+	mask(&copy.Provider.APIKey)
+	mask(&copy.Proxy.Password)
+	mask(&copy.Serve.AdminToken)
+	mask(&copy.Serve.ProxyPassword)
+
+	// Webhook URLs usually embed a secret token
+	copy.Schedule.Jobs = slices.Clone(c.Schedule.Jobs)
+	for i := range copy.Schedule.Jobs {
+		mask(&copy.Schedule.Jobs[i].Webhook)
+	}
+	copy.Notify.Channels = slices.Clone(c.Notify.Channels)
+	for i := range copy.Notify.Channels {
+		channel := &copy.Notify.Channels[i]
+		mask(&channel.Secret)
+		mask(&channel.Token)
+		if channel.Type == "slack" {
+			mask(&channel.URL)
+		}
+	}
+	copy.Pools = make(map[string]poolConfig, len(c.Pools))
+	for name, pool := range c.Pools {
+		mask(&pool.Provider.APIKey)
+		copy.Pools[name] = pool
+	}
+	return copy
+}
+
+// handleConfigValidate checks the config file at path and fails when it has problems
+func handleConfigValidate(path, profile string) error {
+	if path == "" {
+		return configErrorf("no config file found; pass one with --config or as an argument")
+	}
+	if !validateConfigFile(path, profile) {
+		return configErrorf("%s is not valid", path)
+	}
+	return nil
+}
+
+// handleConfigShow prints the effective configuration built from path,
+// profile and pool
+func handleConfigShow(path, profile, pool string) error {
+	cfg, _, err := loadConfig(path, profile, pool)
+	if err != nil {
+		return configErrorf("loading config: %v", err)
+	}
+	if path == "" {
+		fmt.Println("# No config file; defaults and environment only")
+	} else {
+		fmt.Printf("# Config file: %s\n", path)
+	}
+	if profile != "" {
+		fmt.Printf("# Profile: %s\n", profile)
+	}
+	if cfg.pool != defaultPoolName {
+		fmt.Printf("# Pool: %s\n", cfg.pool)
+	}
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	encoder.Encode(cfg.redacted())
+	fmt.Print(buffer.String())
+	return nil
+}
+
+// validateConfigFile checks the base settings and every profile of the file
+// at path, printing each problem, and reports whether the file is valid
+func validateConfigFile(path, selected string) bool {
+	file, unknown, err := readConfigFile(path)
+	if err != nil {
+		// Report every bad value, not only the first
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			for _, problem := range typeErr.Errors {
+				fmt.Printf("%s: %s\n", path, problem)
+			}
+		} else {
+			fmt.Printf("%v\n", err)
+		}
+		return false
+	}
+
+	baseProblems := file.validate()
+	problems := append(unknown, baseProblems...)
+
+	names := make([]string, 0, len(file.Profiles))
+	for name := range file.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		node := file.Profiles[name]
+		profile := file.config
+		profile.Test.Exchanges = copyExchanges(file.Test.Exchanges)
+		profile.Pools = maps.Clone(file.Pools)
+		if err := node.Decode(&profile); err != nil {
+			problems = append(problems, fmt.Sprintf("profile %s: %v", name, err))
+			continue
+		}
+		for _, problem := range profile.validate() {
+			// Problems inherited from the base settings are reported once
+			if !slices.Contains(baseProblems, problem) {
+				problems = append(problems, fmt.Sprintf("profile %s: %s", name, problem))
+			}
+		}
+	}
+	if selected != "" && file.Profiles[selected].Kind == 0 {
+		problems = append(problems, fmt.Sprintf("profile '%s' not found (available: %s)", selected, profileNames(file)))
+	}
+
+	if len(problems) == 0 {
+		fmt.Printf("%s: OK (%d profiles)\n", path, len(file.Profiles))
+		return true
+	}
+	for _, problem := range problems {
+		fmt.Printf("%s: %s\n", path, problem)
+	}
+	return false
+}
+
+// copyExchanges copies the per-exchange overrides so a profile can be
+// decoded without changing the base configuration
+func copyExchanges(source map[string]exchangeConfig) map[string]exchangeConfig {
+	if source == nil {
+		return nil
+	}
+	copied := make(map[string]exchangeConfig, len(source))
+	for name, exchange := range source {
+		copied[name] = exchange
+	}
+	return copied
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go-proxy/proxypool"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *config)
+		want   string // a reported problem; empty for a valid config
+	}{
+		{"defaults", func(c *config) {}, ""},
+		{"list URL", func(c *config) { c.Provider.ListURL = "ftp://example.com/list" }, "provider.list_url: 'ftp://example.com/list' is not an http(s) URL"},
+		{"empty cache file", func(c *config) { c.Provider.CacheFile = "" }, "provider.cache_file: must not be empty"},
+		{"timeout", func(c *config) { c.Test.Timeout = 0 }, "test.timeout: must be positive"},
+		{"concurrency", func(c *config) { c.Test.Concurrency = 0 }, "test.concurrency: must be positive"},
+		{"negative rate", func(c *config) { c.Test.Rate = -1 }, "test.rate: must not be negative"},
+		{"burst", func(c *config) { c.Test.Burst = 0 }, "test.burst: must be positive"},
+		{"proxy family", func(c *config) { c.Test.ProxyFamily = 5 }, "test.proxy_family: must be 0, 4 or 6, got 5"},
+		{"IPv6 proxy family", func(c *config) { c.Test.ProxyFamily = 6 }, ""},
+		{"history", func(c *config) { c.History.MaxRuns = -1 }, "history.max_runs: must not be negative"},
+		{"unlimited history", func(c *config) { c.History.MaxRuns = 0 }, ""},
+		{"unknown exchange", func(c *config) { c.Test.Exchanges = map[string]exchangeConfig{"nyse": {}} }, "test.exchanges.nyse: unknown exchange"},
+		{"exchange burst", func(c *config) { c.Test.Exchanges = map[string]exchangeConfig{"binance": {Burst: -1}} }, "test.exchanges.binance.burst: must not be negative"},
+		{"serve exchanges", func(c *config) { c.Serve.Exchanges = "binance, nyse" }, "serve.exchanges: unknown exchange 'nyse'"},
+		{"affinity TTL", func(c *config) { c.Serve.AffinityTTL = -time.Second }, "serve.affinity_ttl: must not be negative"},
+		{"job cron", func(c *config) { c.Schedule.Jobs = []jobConfig{{Name: "hourly", Cron: "61 * * * *"}} }, "schedule.jobs.0.cron:"},
+		{"duplicate job", func(c *config) {
+			c.Schedule.Jobs = []jobConfig{{Name: "hourly", Cron: "0 * * * *"}, {Name: "hourly", Cron: "30 * * * *"}}
+		}, "schedule.jobs.1.name: duplicate job 'hourly'"},
+		{"job filter", func(c *config) { c.Schedule.Jobs = []jobConfig{{Name: "hourly", Cron: "0 * * * *", Port: "http"}} }, "schedule.jobs.0: invalid --port"},
+		{"channel type", func(c *config) { c.Notify.Channels = []channelConfig{{Name: "ops", Type: "email"}} }, "notify.channels.0.type: must be one of"},
+		{"pool name", func(c *config) { c.Pools = map[string]poolConfig{"../eu": {}} }, "pools.../eu:"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := defaultConfig()
+			test.change(c)
+			problems := c.validate()
+
+			if test.want == "" {
+				if len(problems) > 0 {
+					t.Errorf("validate() = %q, want no problems", problems)
+				}
+				return
+			}
+			for _, problem := range problems {
+				if strings.Contains(problem, test.want) {
+					return
+				}
+			}
+			t.Errorf("validate() = %q, want a problem containing %q", problems, test.want)
+		})
+	}
+}
+
+func TestProxyConfigWithCredentials(t *testing.T) {
+	listed := []proxypool.Proxy{
+		{ProxyAddress: "192.0.2.1", Port: 80},
+		{ProxyAddress: "192.0.2.2", Port: 80, Username: "alice", Password: "own"},
+	}
+
+	tests := []struct {
+		name  string
+		proxy proxyConfig
+		want  []string // username:password of each proxy
+	}{
+		{"no credentials", proxyConfig{}, []string{":", "alice:own"}},
+		{"username only", proxyConfig{Username: "bob"}, []string{":", "alice:own"}},
+		{"filled in", proxyConfig{Username: "bob", Password: "secret"}, []string{"bob:secret", "alice:own"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxies := test.proxy.withCredentials(listed)
+			for i, proxy := range proxies {
+				if got := proxy.Username + ":" + proxy.Password; got != test.want[i] {
+					t.Errorf("proxy %s has credentials %q, want %q", proxy.Key(), got, test.want[i])
+				}
+			}
+			if listed[0].Username != "" {
+				t.Errorf("withCredentials() changed the listed proxy to %+v", listed[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"30-10 * * * *",
+		"* * * foo *",
+		"@fortnightly",
+	}
+	for _, expr := range tests {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2026, time.January, 14, 10, 17, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, time.January, 14, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, time.January, 14, 10, 30, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2026, time.January, 14, 10, 25, 0, 0, time.UTC)},
+		{"0,45 9-17 * * *", time.Date(2026, time.January, 14, 10, 45, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, time.January, 14, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, time.January, 15, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2026, time.January, 18, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 2 * * mon-fri", time.Date(2026, time.January, 15, 2, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, time.January, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * MAR *", time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: either one matches
+		{"0 0 20 * fri", time.Date(2026, time.January, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		schedule, err := parseCron(test.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q) error: %v", test.expr, err)
+			continue
+		}
+		if got := schedule.next(from); !got.Equal(test.want) {
+			t.Errorf("%q: next(%s) = %s, want %s", test.expr, from, got, test.want)
+		}
+	}
+}
+
+func TestCronNextKeepsLocation(t *testing.T) {
+	location := time.FixedZone("UTC+5", 5*60*60)
+	schedule, err := parseCron("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2026, time.January, 14, 10, 0, 0, 0, location)
+	want := time.Date(2026, time.January, 15, 9, 0, 0, 0, location)
+	if got := schedule.next(from); !got.Equal(want) || got.Location() != location {
+		t.Errorf("next(%s) = %s, want %s", from, got, want)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go-proxy/exchanges"
+)
+
+// resultFlip is a (proxy, exchange) pair whose outcome changed between runs
+type resultFlip struct {
+	Exchange     string `json:"exchange"`
+	ProxyAddress string `json:"proxy_address"`
+	Port         int    `json:"port"`
+	CountryCode  string `json:"country_code,omitempty"`
+	Before       bool   `json:"before"`
+	After        bool   `json:"after"`
+	Error        string `json:"error,omitempty"`
+}
+
+// latencyChange is a (proxy, exchange) pair that passed in both runs but got slower
+type latencyChange struct {
+	Exchange     string        `json:"exchange"`
+	ProxyAddress string        `json:"proxy_address"`
+	Port         int           `json:"port"`
+	CountryCode  string        `json:"country_code,omitempty"`
+	Before       time.Duration `json:"before"`
+	After        time.Duration `json:"after"`
+	Increase     float64       `json:"increase_percent"`
+}
+
+// runDiff describes what changed from one run to another. Added and
+// Removed compare the pools of the runs when both recorded theirs, and
+// otherwise list the proxies tested in only one of them, since either run
+// may have tested part of the pool. Only the tests both runs made are
+// compared.
+type runDiff struct {
+	From               string          `json:"from"`
+	To                 string          `json:"to"`
+	Compared           int             `json:"compared"`    // tests made in both runs
+	PoolsKnown         bool            `json:"pools_known"` // Added and Removed compare pools
+	Added              []string        `json:"added"`
+	Removed            []string        `json:"removed"`
+	Flipped            []resultFlip    `json:"flipped"`
+	LatencyRegressions []latencyChange `json:"latency_regressions"`
+}
+
+// addressWidth returns the width of the proxy address column of the
+// flipped results and latency regressions
+func (d *runDiff) addressWidth() int {
+	var addresses []string
+	for _, flip := range d.Flipped {
+		addresses = append(addresses, flip.ProxyAddress)
+	}
+	for _, change := range d.LatencyRegressions {
+		addresses = append(addresses, change.ProxyAddress)
+	}
+	return addressWidth(addresses...)
+}
+
+// writeRunExport writes a run to a JSON file that diff can read back
+func writeRunExport(path string, run *historyRun) error {
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// loadRun resolves a diff argument to a run. The argument is either the path
+// of an exported JSON file or a run ID from the history store; "latest" and
+// "previous" refer to the two most recent runs in history.
+func loadRun(arg string) (*historyRun, error) {
+	if data, err := os.ReadFile(arg); err == nil {
+		var run historyRun
+		if err := json.Unmarshal(data, &run); err != nil {
+			// Also accept a bare array of results
+			var results []*exchanges.TestResult
+			if err := json.Unmarshal(data, &results); err != nil {
+				return nil, fmt.Errorf("%s is not a valid run export: %v", arg, err)
+			}
+			run.Results = results
+		}
+		if run.ID == "" {
+			run.ID = arg
+		}
+		return &run, nil
+	}
+
+	runs, err := loadHistory()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s is neither a file nor a run in history (history is empty)", arg)
+		}
+		return nil, fmt.Errorf("failed to load history: %v", err)
+	}
+
+	switch arg {
+	case "latest":
+		if len(runs) >= 1 {
+			return runs[len(runs)-1], nil
+		}
+	case "previous":
+		if len(runs) >= 2 {
+			return runs[len(runs)-2], nil
+		}
+	default:
+		for _, run := range runs {
+			if run.ID == arg {
+				return run, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%s is neither a file nor a run in history", arg)
+}
+
+// diffRuns compares two runs on the (proxy, exchange) pairs tested in both,
+// and their pools, or the proxies they tested if a run has no pool. A latency regression is a slowdown of more than latencyThreshold percent
+// on a pair that passed in both runs.
+func diffRuns(from, to *historyRun, latencyThreshold float64) *runDiff {
+	diff := &runDiff{
+		From:               from.ID,
+		To:                 to.ID,
+		PoolsKnown:         from.Pool != nil && to.Pool != nil,
+		Added:              []string{},
+		Removed:            []string{},
+		Flipped:            []resultFlip{},
+		LatencyRegressions: []latencyChange{},
+	}
+
+	before := make(map[string]*exchanges.TestResult)
+	for _, result := range from.Results {
+		before[resultKey(result)] = result
+	}
+
+	for _, result := range to.Results {
+		old, exists := before[resultKey(result)]
+		if !exists {
+			continue
+		}
+		diff.Compared++
+
+		if old.Success != result.Success {
+			flip := resultFlip{
+				Exchange:     result.Exchange,
+				ProxyAddress: result.ProxyAddress,
+				Port:         result.Port,
+				CountryCode:  result.CountryCode,
+				Before:       old.Success,
+				After:        result.Success,
+			}
+			if !result.Success {
+				flip.Error = result.Error
+			}
+			diff.Flipped = append(diff.Flipped, flip)
+			continue
+		}
+
+		if result.Success && old.ResponseTime > 0 {
+			increase := float64(result.ResponseTime-old.ResponseTime) / float64(old.ResponseTime) * 100
+			if increase > latencyThreshold {
+				diff.LatencyRegressions = append(diff.LatencyRegressions, latencyChange{
+					Exchange:     result.Exchange,
+					ProxyAddress: result.ProxyAddress,
+					Port:         result.Port,
+					CountryCode:  result.CountryCode,
+					Before:       old.ResponseTime,
+					After:        result.ResponseTime,
+					Increase:     increase,
+				})
+			}
+		}
+	}
+
+	fromProxies, toProxies := from.Pool, to.Pool
+	if !diff.PoolsKnown {
+		fromProxies, toProxies = testedProxies(from), testedProxies(to)
+	}
+	inFrom := make(map[string]bool)
+	for _, proxy := range fromProxies {
+		inFrom[proxy] = true
+	}
+	inTo := make(map[string]bool)
+	for _, proxy := range toProxies {
+		inTo[proxy] = true
+		if !inFrom[proxy] {
+			diff.Added = append(diff.Added, proxy)
+		}
+	}
+	for _, proxy := range fromProxies {
+		if !inTo[proxy] {
+			diff.Removed = append(diff.Removed, proxy)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.Flipped, func(i, j int) bool {
+		a, b := diff.Flipped[i], diff.Flipped[j]
+		if a.Exchange != b.Exchange {
+			return a.Exchange < b.Exchange
+		}
+		return a.ProxyAddress < b.ProxyAddress
+	})
+	sort.Slice(diff.LatencyRegressions, func(i, j int) bool {
+		return diff.LatencyRegressions[i].Increase > diff.LatencyRegressions[j].Increase
+	})
+
+	return diff
+}
+
+// testedProxies returns the distinct proxies a run tested
+func testedProxies(run *historyRun) []string {
+	var proxies []string
+	seen := make(map[string]bool)
+	for _, result := range run.Results {
+		proxy := exchanges.ProxyHostPort(result.ProxyAddress, result.Port)
+		if !seen[proxy] {
+			seen[proxy] = true
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+func handleDiffCommand(fromArg, toArg string, options diffOptions) error {
+	from, err := loadRun(fromArg)
+	if err != nil {
+		return configErrorf("%v", err)
+	}
+	to, err := loadRun(toArg)
+	if err != nil {
+		return configErrorf("%v", err)
+	}
+
+	diff := diffRuns(from, to, options.latencyThreshold)
+
+	if options.format == "json" {
+		data, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encoding diff: %v", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	printRunDiff(diff)
+	return nil
+}
+
+// printRunDiff prints a diff as tables
+func printRunDiff(diff *runDiff) {
+	fmt.Printf("Comparing run %s → %s on the %d tests both made\n", diff.From, diff.To, diff.Compared)
+
+	addedTitle := "Proxies Added to the Pool"
+	removedTitle := "Proxies Removed from the Pool"
+	if !diff.PoolsKnown {
+		// Without both pools, a proxy missing from a run may have been
+		// left out by its selection flags rather than the pool
+		addedTitle = "Proxies Tested Only in " + diff.To
+		removedTitle = "Proxies Tested Only in " + diff.From
+	}
+
+	fmt.Printf("\n=== %s (%d) ===\n", addedTitle, len(diff.Added))
+	for _, proxy := range diff.Added {
+		fmt.Printf("  + %s\n", proxy)
+	}
+
+	fmt.Printf("\n=== %s (%d) ===\n", removedTitle, len(diff.Removed))
+	for _, proxy := range diff.Removed {
+		fmt.Printf("  - %s\n", proxy)
+	}
+
+	width := diff.addressWidth()
+	fmt.Printf("\n=== Flipped Results (%d) ===\n", len(diff.Flipped))
+	if len(diff.Flipped) > 0 {
+		fmt.Printf("%-12s %-*s %-6s %-8s %-12s %s\n", "Exchange", width, "Proxy Address", "Port", "Country", "Change", "Error")
+		fmt.Println(strings.Repeat("-", 65+width)) // Separator line
+		for _, flip := range diff.Flipped {
+			change := "fail → pass"
+			if flip.Before {
+				change = "pass → fail"
+			}
+			fmt.Printf("%-12s %-*s %-6d %-8s %-12s %s\n",
+				flip.Exchange,
+				width, flip.ProxyAddress,
+				flip.Port,
+				flip.CountryCode,
+				change,
+				flip.Error)
+		}
+	}
+
+	fmt.Printf("\n=== Latency Regressions (%d) ===\n", len(diff.LatencyRegressions))
+	if len(diff.LatencyRegressions) > 0 {
+		fmt.Printf("%-12s %-*s %-6s %-8s %-12s %-12s %s\n", "Exchange", width, "Proxy Address", "Port", "Country", "Before", "After", "Increase")
+		fmt.Println(strings.Repeat("-", 70+width)) // Separator line
+		for _, change := range diff.LatencyRegressions {
+			fmt.Printf("%-12s %-*s %-6d %-8s %-12s %-12s +%.0f%%\n",
+				change.Exchange,
+				width, change.ProxyAddress,
+				change.Port,
+				change.CountryCode,
+				change.Before.Round(time.Millisecond).String(),
+				change.After.Round(time.Millisecond).String(),
+				change.Increase)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"go-proxy/exchanges"
+)
+
+// result returns a test result of a proxy on 192.0.2.1
+func result(exchange string, port int, success bool, responseTime time.Duration) *exchanges.TestResult {
+	return &exchanges.TestResult{
+		Exchange:     exchange,
+		ProxyAddress: "192.0.2.1",
+		Port:         port,
+		Success:      success,
+		ResponseTime: responseTime,
+	}
+}
+
+func TestDiffRuns(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name        string
+		from, to    []*exchanges.TestResult
+		pools       [][]string // pools of the from and to runs; none if unknown
+		compared    int
+		added       []string
+		removed     []string
+		flipped     []string // exchange/port, before → after
+		regressions []string // exchange/port
+	}{
+		{
+			name:     "identical runs",
+			from:     []*exchanges.TestResult{result("Binance", 80, true, 100*ms), result("Bybit", 80, false, 0)},
+			to:       []*exchanges.TestResult{result("Binance", 80, true, 100*ms), result("Bybit", 80, false, 0)},
+			compared: 2,
+		},
+		{
+			name:     "flips both ways",
+			from:     []*exchanges.TestResult{result("Binance", 80, true, 100*ms), result("Bybit", 80, false, 0)},
+			to:       []*exchanges.TestResult{result("Binance", 80, false, 0), result("Bybit", 80, true, 100*ms)},
+			compared: 2,
+			flipped:  []string{"Binance/80 true→false", "Bybit/80 false→true"},
+		},
+		{
+			name:        "latency over the threshold",
+			from:        []*exchanges.TestResult{result("Binance", 80, true, 100*ms), result("Binance", 81, true, 100*ms)},
+			to:          []*exchanges.TestResult{result("Binance", 80, true, 200*ms), result("Binance", 81, true, 140*ms)},
+			compared:    2,
+			regressions: []string{"Binance/80"},
+		},
+		{
+			name:     "partial runs",
+			from:     []*exchanges.TestResult{result("Binance", 80, true, 100*ms), result("Binance", 81, true, 100*ms)},
+			to:       []*exchanges.TestResult{result("Binance", 81, false, 0), result("Binance", 82, true, 100*ms)},
+			compared: 1,
+			added:    []string{"192.0.2.1:82"},
+			removed:  []string{"192.0.2.1:80"},
+			flipped:  []string{"Binance/81 true→false"},
+		},
+		{
+			name:     "pools recorded",
+			from:     []*exchanges.TestResult{result("Binance", 80, true, 100*ms), result("Binance", 81, true, 100*ms)},
+			to:       []*exchanges.TestResult{result("Binance", 81, true, 100*ms)},
+			pools:    [][]string{{"192.0.2.1:80", "192.0.2.1:81", "192.0.2.1:83"}, {"192.0.2.1:81", "192.0.2.1:82", "192.0.2.1:83"}},
+			compared: 1,
+			added:    []string{"192.0.2.1:82"},
+			removed:  []string{"192.0.2.1:80"},
+		},
+		{
+			name:     "pools unchanged",
+			from:     []*exchanges.TestResult{result("Binance", 80, true, 100*ms)},
+			to:       []*exchanges.TestResult{result("Binance", 81, true, 100*ms)},
+			pools:    [][]string{{"192.0.2.1:80", "192.0.2.1:81"}, {"192.0.2.1:80", "192.0.2.1:81"}},
+			compared: 0,
+		},
+		{
+			name:     "exchange tested in one run only",
+			from:     []*exchanges.TestResult{result("Binance", 80, true, 100*ms)},
+			to:       []*exchanges.TestResult{result("Binance", 80, true, 100*ms), result("Bybit", 80, false, 0)},
+			compared: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			from, to := &historyRun{ID: "a", Results: test.from}, &historyRun{ID: "b", Results: test.to}
+			if test.pools != nil {
+				from.Pool, to.Pool = test.pools[0], test.pools[1]
+			}
+			diff := diffRuns(from, to, 50)
+
+			if diff.Compared != test.compared {
+				t.Errorf("compared %d tests, want %d", diff.Compared, test.compared)
+			}
+			if diff.PoolsKnown != (test.pools != nil) {
+				t.Errorf("pools known = %t, want %t", diff.PoolsKnown, test.pools != nil)
+			}
+			if !slices.Equal(diff.Added, test.added) {
+				t.Errorf("added = %v, want %v", diff.Added, test.added)
+			}
+			if !slices.Equal(diff.Removed, test.removed) {
+				t.Errorf("removed = %v, want %v", diff.Removed, test.removed)
+			}
+
+			var flipped []string
+			for _, flip := range diff.Flipped {
+				flipped = append(flipped, fmt.Sprintf("%s/%d %t→%t", flip.Exchange, flip.Port, flip.Before, flip.After))
+			}
+			if !slices.Equal(flipped, test.flipped) {
+				t.Errorf("flipped = %v, want %v", flipped, test.flipped)
+			}
+
+			var regressions []string
+			for _, change := range diff.LatencyRegressions {
+				regressions = append(regressions, fmt.Sprintf("%s/%d", change.Exchange, change.Port))
+			}
+			if !slices.Equal(regressions, test.regressions) {
+				t.Errorf("latency regressions = %v, want %v", regressions, test.regressions)
+			}
+		})
+	}
+}
+
+func TestDiffRunsKeepsTheErrorOfNewFailures(t *testing.T) {
+	failed := result("Binance", 80, false, 0)
+	failed.Error = "connection refused"
+	diff := diffRuns(
+		&historyRun{Results: []*exchanges.TestResult{result("Binance", 80, true, time.Millisecond)}},
+		&historyRun{Results: []*exchanges.TestResult{failed}},
+		50,
+	)
+	if len(diff.Flipped) != 1 || diff.Flipped[0].Error != "connection refused" {
+		t.Errorf("flipped = %+v, want the failure with its error", diff.Flipped)
+	}
+}
+
+func TestLoadRun(t *testing.T) {
+	useTestConfig(t)
+	dir := t.TempDir()
+
+	export := filepath.Join(dir, "run.json")
+	if err := writeRunExport(export, &historyRun{ID: "exported", Results: []*exchanges.TestResult{result("Binance", 80, true, 0)}}); err != nil {
+		t.Fatal(err)
+	}
+	bare := filepath.Join(dir, "results.json")
+	if err := os.WriteFile(bare, []byte(`[{"exchange":"Binance","proxy_address":"192.0.2.1","port":80}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Date(2026, 1, 14, 10, 0, 0, 0, time.UTC)
+	for i, id := range []string{"first", "second", "third"} {
+		run := &historyRun{ID: id, Timestamp: started.Add(time.Duration(i) * time.Hour), Results: []*exchanges.TestResult{result("Binance", 80, true, 0)}}
+		if err := appendHistory(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{export, "exported"},
+		{bare, bare},
+		{"latest", "third"},
+		{"previous", "second"},
+		{"first", "first"},
+	}
+	for _, test := range tests {
+		run, err := loadRun(test.arg)
+		if err != nil {
+			t.Errorf("loadRun(%q) error: %v", test.arg, err)
+			continue
+		}
+		if run.ID != test.want || len(run.Results) != 1 {
+			t.Errorf("loadRun(%q) = run %s with %d results, want run %s with 1", test.arg, run.ID, len(run.Results), test.want)
+		}
+	}
+
+	if _, err := loadRun("missing"); err == nil {
+		t.Error("loadRun() of an unknown run succeeded")
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"go-proxy/proxypool"
+
+	"gopkg.in/yaml.v3"
+)
+
+// setting is one configuration value reported by doctor
+type setting struct {
+	key    string // dotted config file key
+	env    string // environment variable that overrides it, if any
+	secret bool
+	value  func(c *config) string
+}
+
+// doctorSettings are the values doctor reports, in display order
+var doctorSettings = []setting{
+	{key: "provider.list_url", env: "PROXY_LIST", value: func(c *config) string { return c.Provider.ListURL }},
+	{key: "provider.api_key", env: "PROXY_API", secret: true, value: func(c *config) string { return c.Provider.APIKey }},
+	{key: "provider.webshare_url", value: func(c *config) string { return c.Provider.WebshareURL }},
+	{key: "provider.cache_file", env: "PROXY_CACHE_FILE", value: func(c *config) string { return c.Provider.CacheFile }},
+	{key: "provider.sources", value: func(c *config) string { return strings.Join(c.Provider.sourceNames(), ", ") }},
+	{key: "proxy.username", env: "PROXY_USER", value: func(c *config) string { return c.Proxy.Username }},
+	{key: "proxy.password", env: "PROXY_PASS", secret: true, value: func(c *config) string { return c.Proxy.Password }},
+	{key: "test.timeout", value: func(c *config) string { return c.Test.Timeout.String() }},
+	{key: "test.concurrency", env: "PROXY_TEST_CONCURRENCY", value: func(c *config) string { return fmt.Sprint(c.Test.Concurrency) }},
+	{key: "test.exchange_concurrency", env: "PROXY_TEST_EXCHANGE_CONCURRENCY", value: func(c *config) string { return fmt.Sprint(c.Test.ExchangeConcurrency) }},
+	{key: "test.proxy_concurrency", env: "PROXY_TEST_PROXY_CONCURRENCY", value: func(c *config) string { return fmt.Sprint(c.Test.ProxyConcurrency) }},
+	{key: "test.rate", env: "PROXY_TEST_RATE", value: func(c *config) string { return fmt.Sprint(c.Test.Rate) }},
+	{key: "test.burst", env: "PROXY_TEST_BURST", value: func(c *config) string { return fmt.Sprint(c.Test.Burst) }},
+	{key: "test.proxy_family", env: "PROXY_TEST_PROXY_FAMILY", value: func(c *config) string { return fmt.Sprint(c.Test.ProxyFamily) }},
+	{key: "serve.listen", value: func(c *config) string { return c.Serve.Listen }},
+	{key: "serve.interval", value: func(c *config) string { return c.Serve.Interval.String() }},
+	{key: "serve.admin_token", env: "PROXY_ADMIN_TOKEN", secret: true, value: func(c *config) string { return c.Serve.AdminToken }},
+	{key: "serve.proxy_listen", value: func(c *config) string { return c.Serve.ProxyListen }},
+	{key: "serve.proxy_password", env: "PROXY_FRONTEND_PASSWORD", secret: true, value: func(c *config) string { return c.Serve.ProxyPassword }},
+	{key: "serve.rules", value: func(c *config) string { return c.Serve.Rules }},
+	{key: "history.max_runs", env: "PROXY_HISTORY_MAX_RUNS", value: func(c *config) string { return fmt.Sprint(c.History.MaxRuns) }},
+	{key: "data_dir", env: "PROXY_DATA_DIR", value: func(c *config) string { return c.DataDir }},
+}
+
+// requirement is something a command needs before it can run
+type requirement struct {
+	description string
+	met         func(c *config) bool
+}
+
+// hasProxyCache reports whether the proxy cache file exists
+func hasProxyCache(c *config) bool {
+	_, err := os.Stat(c.Provider.CacheFile)
+	return err == nil
+}
+
+// hasDataDir reports whether a data directory for test history can be determined
+func hasDataDir(c *config) bool {
+	_, err := dataDir()
+	return err == nil
+}
+
+// commandRequirements lists what each command needs
+var commandRequirements = []struct {
+	command      string
+	requirements []requirement
+}{
+	{"list", []requirement{
+		{"provider.list_url (PROXY_LIST)", func(c *config) bool { return c.Provider.ListURL != "" }},
+	}},
+	{"api", []requirement{
+		{"the settings of every provider in provider.sources (by default provider.api_key, PROXY_API), or a proxy cache without --refresh", func(c *config) bool {
+			return c.Provider.checkSources() == nil || hasProxyCache(c)
+		}},
+	}},
+	{"test", []requirement{
+		{"a proxy cache; run 'go-proxy api' first", hasProxyCache},
+	}},
+	{"history", []requirement{
+		{"a data directory", hasDataDir},
+	}},
+	{"diff", []requirement{
+		{"a data directory", hasDataDir},
+	}},
+	{"serve", []requirement{
+		{"the settings of every provider in provider.sources, or a proxy cache", func(c *config) bool {
+			return c.Provider.checkSources() == nil || hasProxyCache(c)
+		}},
+		{"serve.proxy_password (PROXY_FRONTEND_PASSWORD) when serve.proxy_listen is set", func(c *config) bool {
+			return c.Serve.ProxyListen == "" || c.Serve.ProxyPassword != ""
+		}},
+		{"a readable and valid serve.rules file when it is set", func(c *config) bool {
+			if c.Serve.Rules == "" {
+				return true
+			}
+			_, err := proxypool.LoadRules(c.Serve.Rules)
+			return err == nil
+		}},
+	}},
+	{"schedule", []requirement{
+		{"schedule.jobs in the config file", func(c *config) bool { return len(c.Schedule.Jobs) > 0 }},
+		{"the settings of every provider in provider.sources, or a proxy cache", func(c *config) bool {
+			return c.Provider.checkSources() == nil || hasProxyCache(c)
+		}},
+	}},
+}
+
+// settingOrigin describes where the effective value of a setting came from
+func settingOrigin(s setting, base, profile *yaml.Node, source configSource) string {
+	// The environment overrides everything but flags, except that a named
+	// pool keeps its own cache
+	named := source.Pool != "" && source.Pool != defaultPoolName
+	if s.env != "" && !(named && s.key == "provider.cache_file") {
+		if _, set := os.LookupEnv(s.env); set && os.Getenv(s.env) != "" {
+			if file, fromFile := envOrigins[s.env]; fromFile {
+				return fmt.Sprintf("env %s (from %s)", s.env, file)
+			}
+			return "env " + s.env
+		}
+	}
+	if named && strings.HasPrefix(s.key, "provider.") {
+		key := "pools." + source.Pool + "." + s.key
+		if profile != nil && hasKey(profile, key) {
+			return fmt.Sprintf("pool %s in profile %s (%s)", source.Pool, source.Profile, source.Path)
+		}
+		if base != nil && hasKey(base, key) {
+			return fmt.Sprintf("pool %s (%s)", source.Pool, source.Path)
+		}
+		if s.key == "provider.cache_file" {
+			return "pool " + source.Pool
+		}
+	}
+	if profile != nil && hasKey(profile, s.key) {
+		return fmt.Sprintf("profile %s (%s)", source.Profile, source.Path)
+	}
+	if base != nil && hasKey(base, s.key) {
+		return "config " + source.Path
+	}
+	return "default"
+}
+
+// hasKey reports whether a YAML mapping sets a dotted key
+func hasKey(node *yaml.Node, key string) bool {
+	first, rest, nested := strings.Cut(key, ".")
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != first {
+			continue
+		}
+		if !nested {
+			return true
+		}
+		return hasKey(node.Content[i+1], rest)
+	}
+	return false
+}
+
+// configNodes returns the base mapping of the config file and the mapping of
+// the selected profile, either of which may be nil
+func configNodes(source configSource) (base, profile *yaml.Node) {
+	if source.Path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(source.Path)
+	if err != nil {
+		return nil, nil
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil || len(root.Content) == 0 {
+		return nil, nil
+	}
+	base = root.Content[0]
+
+	if source.Profile == "" || base.Kind != yaml.MappingNode {
+		return base, nil
+	}
+	for i := 0; i+1 < len(base.Content); i += 2 {
+		if base.Content[i].Value != "profiles" {
+			continue
+		}
+		profiles := base.Content[i+1]
+		for j := 0; j+1 < len(profiles.Content); j += 2 {
+			if profiles.Content[j].Value == source.Profile {
+				return base, profiles.Content[j+1]
+			}
+		}
+	}
+	return base, nil
+}
+
+// handleDoctorCommand reports the env files, config file and settings in
+// use, and whether each command has what it needs. With a command name it
+// only checks that command and fails if it is not ready.
+func handleDoctorCommand(source configSource, only string) error {
+	fmt.Println("Environment files:")
+	if len(source.EnvFiles) == 0 {
+		fmt.Println("  none")
+	}
+	for _, file := range source.EnvFiles {
+		switch {
+		case !file.Found:
+			fmt.Printf("  %-20s not found\n", file.Path)
+		default:
+			fmt.Printf("  %-20s loaded %d variables\n", file.Path, len(file.Loaded))
+		}
+	}
+
+	fmt.Println()
+	if source.Path == "" {
+		fmt.Println("Config file: none")
+	} else {
+		fmt.Printf("Config file: %s\n", source.Path)
+	}
+	if source.Profile != "" {
+		fmt.Printf("Profile: %s\n", source.Profile)
+	}
+	if source.Pool != "" {
+		fmt.Printf("Pool: %s\n", source.Pool)
+	}
+	// main skips a broken config for doctor, so report why it was skipped
+	problems := 0
+	if cfg, _, err := loadConfig(source.Path, source.Profile, source.Pool); err != nil {
+		fmt.Printf("  error: %v\n", err)
+		problems++
+	} else {
+		for _, problem := range cfg.validate() {
+			fmt.Printf("  error: %s\n", problem)
+			problems++
+		}
+	}
+	if problems > 0 {
+		fmt.Println("  using built-in defaults until the config is fixed")
+	}
+
+	fmt.Println()
+	fmt.Println("Settings:")
+	base, profile := configNodes(source)
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	for _, s := range doctorSettings {
+		value := s.value(appConfig)
+		switch {
+		case value == "":
+			value = "(not set)"
+		case s.secret:
+			value = "********"
+		}
+		fmt.Fprintf(table, "  %s\t%s\t%s\n", s.key, value, settingOrigin(s, base, profile, source))
+	}
+	table.Flush()
+
+	fmt.Println()
+	fmt.Println("Commands:")
+	ready := true
+	for _, entry := range commandRequirements {
+		if only != "" && entry.command != only {
+			continue
+		}
+		var missing []string
+		for _, req := range entry.requirements {
+			if !req.met(appConfig) {
+				missing = append(missing, req.description)
+			}
+		}
+		if len(missing) == 0 {
+			fmt.Printf("  %-10s ready\n", entry.command)
+			continue
+		}
+		ready = false
+		fmt.Printf("  %-10s missing %s\n", entry.command, strings.Join(missing, "; "))
+	}
+
+	if only != "" && (!ready || problems > 0) {
+		return configErrorf("%s is not ready", only)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+// Env files loaded when --env-file is not given; missing ones are skipped
+var defaultEnvFiles = []string{".env", ".env.local"}
+
+// envFileStatus records what loading one env file did
+type envFileStatus struct {
+	Path   string
+	Found  bool
+	Loaded []string // variables taken from this file
+}
+
+// envOrigins maps each variable set from an env file to that file
+var envOrigins = make(map[string]string)
+
+// loadEnvFiles loads variables from the given env files, or from the
+// default ones when none are given. Variables already in the environment
+// are never overridden, and later files take precedence over earlier ones.
+// Explicitly requested files must exist.
+func loadEnvFiles(paths []string) ([]envFileStatus, error) {
+	explicit := len(paths) > 0
+	if !explicit {
+		paths = defaultEnvFiles
+	}
+
+	statuses := make([]envFileStatus, len(paths))
+	// Walk the files backwards so the first file to set a variable is the last listed
+	for i := len(paths) - 1; i >= 0; i-- {
+		status := envFileStatus{Path: paths[i]}
+		values, err := godotenv.Read(paths[i])
+		if err != nil {
+			if os.IsNotExist(err) && !explicit {
+				statuses[i] = status
+				continue
+			}
+			return nil, fmt.Errorf("loading env file %s: %v", paths[i], err)
+		}
+
+		status.Found = true
+		for name, value := range values {
+			if _, set := os.LookupEnv(name); set {
+				continue
+			}
+			os.Setenv(name, value)
+			envOrigins[name] = paths[i]
+			status.Loaded = append(status.Loaded, name)
+		}
+		statuses[i] = status
+	}
+	return statuses, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Exit codes, documented in the README. Scripts may rely on them.
+const (
+	exitOK        = 0
+	exitFailure   = 1 // any other error
+	exitUsage     = 2 // bad command line
+	exitConfig    = 3 // bad configuration or missing input: settings, env files, proxy cache, history
+	exitNetwork   = 4 // a download or the provider API failed, or a server could not listen
+	exitAllFailed = 5 // every proxy test failed
+	exitPartial   = 6 // some tests passed, but fewer than --fail-under
+)
+
+// usageError is a command-line mistake found after parsing, such as an
+// unknown exchange name
+type usageError struct{ err error }
+
+func (e *usageError) Error() string { return e.err.Error() }
+func (e *usageError) Unwrap() error { return e.err }
+
+func usageErrorf(format string, args ...any) error {
+	return &usageError{fmt.Errorf(format, args...)}
+}
+
+// configError is a missing or invalid setting, or missing local input a
+// command depends on
+type configError struct{ err error }
+
+func (e *configError) Error() string { return e.err.Error() }
+func (e *configError) Unwrap() error { return e.err }
+
+func configErrorf(format string, args ...any) error {
+	return &configError{fmt.Errorf(format, args...)}
+}
+
+// networkError is a failure talking to a remote service
+type networkError struct{ err error }
+
+func (e *networkError) Error() string { return e.err.Error() }
+func (e *networkError) Unwrap() error { return e.err }
+
+func networkErrorf(format string, args ...any) error {
+	return &networkError{fmt.Errorf(format, args...)}
+}
+
+// testsFailedError reports a test run that failed entirely, or whose
+// success rate is below the --fail-under threshold
+type testsFailedError struct {
+	passed, total int
+	failUnder     float64 // zero when no threshold was given
+}
+
+func (e *testsFailedError) Error() string {
+	if e.passed == 0 {
+		return fmt.Sprintf("all %d tests failed", e.total)
+	}
+	return fmt.Sprintf("success rate %.1f%% (%d of %d tests) is below --fail-under %.1f%%",
+		percent(e.passed, e.total), e.passed, e.total, e.failUnder)
+}
+
+// exitCode maps an error returned by a command to the process exit code
+func exitCode(err error) int {
+	var (
+		usage   *usageError
+		config  *configError
+		network *networkError
+		tests   *testsFailedError
+	)
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.As(err, &config):
+		return exitConfig
+	case errors.As(err, &network):
+		return exitNetwork
+	case errors.As(err, &tests):
+		if tests.passed == 0 {
+			return exitAllFailed
+		}
+		return exitPartial
+	}
+	return exitFailure
+}
@@ -1,13 +1,19 @@
 package exchanges
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"time"
 )
 
+// DefaultBinanceURL is the endpoint the Binance tester requests by default
+const DefaultBinanceURL = "https://api.binance.com/api/v3/ticker/price?symbol=BTCUSDT"
+
 // BinanceResponse represents the structure of Binance API response
 type BinanceResponse struct {
 	Symbol string `json:"symbol"`
@@ -16,15 +22,37 @@ type BinanceResponse struct {
 
 // BinanceTester implements the ExchangeTester interface for Binance
 type BinanceTester struct {
-	client *http.Client
+	transports  *TransportPool
+	url         string
+	timeout     time.Duration
+	proxyFamily int
+	logger      *slog.Logger
 }
 
-// NewBinanceTester creates a new Binance tester instance
-func NewBinanceTester() *BinanceTester {
+// NewBinanceTester creates a new Binance tester instance that draws its
+// connections from the shared transport pool
+func NewBinanceTester(transports *TransportPool) *BinanceTester {
 	return &BinanceTester{
-		client: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+		transports: transports,
+		url:        DefaultBinanceURL,
+		timeout:    DefaultTimeout,
+		logger:     discardLogger,
+	}
+}
+
+// Configure overrides the test endpoint, timeout, proxy family and logger
+func (b *BinanceTester) Configure(options TesterOptions) {
+	if options.URL != "" {
+		b.url = options.URL
+	}
+	if options.Timeout > 0 {
+		b.timeout = options.Timeout
+	}
+	if options.ProxyFamily != 0 {
+		b.proxyFamily = options.ProxyFamily
+	}
+	if options.Logger != nil {
+		b.logger = options.Logger
 	}
 }
 
@@ -33,49 +61,54 @@ func (b *BinanceTester) TestProxy(proxyAddress string, port int) (*TestResult, e
 	// Create proxy URL
 	proxyURL, err := CreateProxyURL(proxyAddress, port)
 	if err != nil {
-		return &TestResult{
-			ProxyAddress: proxyAddress,
-			Port:         port,
-			Success:      false,
-			Error:        fmt.Sprintf("Invalid proxy URL: %v", err),
-			ResponseTime: 0,
-		}, nil
+		result := invalidProxyResult(proxyAddress, port, err)
+		logResult(b.logger, b.GetName(), result)
+		return result, nil
 	}
+	return b.TestProxyURL(context.Background(), proxyURL)
+}
 
-	// Create transport with proxy
-	transport := &http.Transport{
-		Proxy: http.ProxyURL(proxyURL),
-	}
+// TestProxyURL tests if the proxy at proxyURL works with Binance API
+func (b *BinanceTester) TestProxyURL(ctx context.Context, proxyURL *url.URL) (result *TestResult, err error) {
+	proxyAddress, port := proxyEndpoint(proxyURL)
 
-	// Create client with custom transport
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   10 * time.Second,
-	}
+	// The family of the proxy's address, until a connection tells otherwise
+	family := AddressFamily(proxyAddress)
+	defer func() {
+		if result != nil {
+			result.Family = family
+		}
+		logResult(b.logger, b.GetName(), result)
+	}()
+
+	// Reuse the pooled transport for this proxy
+	client := b.transports.Client(proxyURL, b.proxyFamily, b.timeout)
 
 	// Test endpoint - Binance ticker price for BTC/USDT
-	testURL := "https://api.binance.com/api/v3/ticker/price?symbol=BTCUSDT"
+	testURL := b.url
 
 	startTime := time.Now()
 
-	req, err := http.NewRequest("GET", testURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", testURL, nil)
 	if err != nil {
 		return &TestResult{
 			ProxyAddress: proxyAddress,
 			Port:         port,
 			Success:      false,
 			Error:        fmt.Sprintf("Failed to create request: %v", err),
+			FailureKind:  FailureInternal,
 			ResponseTime: time.Since(startTime),
 		}, nil
 	}
 
-	resp, err := client.Do(req)
+	resp, err := client.Do(traceFamily(req, &family))
 	if err != nil {
 		return &TestResult{
 			ProxyAddress: proxyAddress,
 			Port:         port,
 			Success:      false,
 			Error:        fmt.Sprintf("Request failed: %v", err),
+			FailureKind:  ClassifyRequestError(err),
 			ResponseTime: time.Since(startTime),
 		}, nil
 	}
@@ -91,6 +124,7 @@ func (b *BinanceTester) TestProxy(proxyAddress string, port int) (*TestResult, e
 			Port:         port,
 			Success:      false,
 			Error:        fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(body)),
+			FailureKind:  ClassifyStatus(resp.StatusCode),
 			ResponseTime: responseTime,
 		}, nil
 	}
@@ -103,6 +137,7 @@ func (b *BinanceTester) TestProxy(proxyAddress string, port int) (*TestResult, e
 			Port:         port,
 			Success:      false,
 			Error:        fmt.Sprintf("Failed to read response: %v", err),
+			FailureKind:  FailureBadResponse,
 			ResponseTime: responseTime,
 		}, nil
 	}
@@ -114,6 +149,7 @@ func (b *BinanceTester) TestProxy(proxyAddress string, port int) (*TestResult, e
 			Port:         port,
 			Success:      false,
 			Error:        fmt.Sprintf("Invalid JSON response: %v", err),
+			FailureKind:  FailureBadResponse,
 			ResponseTime: responseTime,
 		}, nil
 	}
@@ -125,16 +161,21 @@ func (b *BinanceTester) TestProxy(proxyAddress string, port int) (*TestResult, e
 			Port:         port,
 			Success:      false,
 			Error:        "Unexpected response format",
+			FailureKind:  FailureBadResponse,
 			ResponseTime: responseTime,
 		}, nil
 	}
 
+	// Repeat the request over the open connection to measure warm latency
+	warmResponseTime := measureWarm(ctx, client, testURL)
+
 	return &TestResult{
-		ProxyAddress: proxyAddress,
-		Port:         port,
-		Success:      true,
-		ResponseTime: responseTime,
-		Data:         fmt.Sprintf("BTC Price: %s", binanceResp.Price),
+		ProxyAddress:     proxyAddress,
+		Port:             port,
+		Success:          true,
+		ResponseTime:     responseTime,
+		WarmResponseTime: warmResponseTime,
+		Data:             fmt.Sprintf("BTC Price: %s", binanceResp.Price),
 	}, nil
 }
 
@@ -142,3 +183,8 @@ func (b *BinanceTester) TestProxy(proxyAddress string, port int) (*TestResult, e
 func (b *BinanceTester) GetName() string {
 	return "Binance"
 }
+
+// Hosts returns the destination hosts this tester's result applies to
+func (b *BinanceTester) Hosts() []string {
+	return []string{"*.binance.com"}
+}
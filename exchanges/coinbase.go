@@ -1,13 +1,19 @@
 package exchanges
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"time"
 )
 
+// DefaultCoinbaseURL is the endpoint the Coinbase tester requests by default
+const DefaultCoinbaseURL = "https://api.coinbase.com/v2/prices/BTC-USD/spot"
+
 // CoinbaseResponse represents the structure of Coinbase API response
 type CoinbaseResponse struct {
 	Data struct {
@@ -19,15 +25,37 @@ type CoinbaseResponse struct {
 
 // CoinbaseTester implements the ExchangeTester interface for Coinbase
 type CoinbaseTester struct {
-	client *http.Client
+	transports  *TransportPool
+	url         string
+	timeout     time.Duration
+	proxyFamily int
+	logger      *slog.Logger
 }
 
-// NewCoinbaseTester creates a new Coinbase tester instance
-func NewCoinbaseTester() *CoinbaseTester {
+// NewCoinbaseTester creates a new Coinbase tester instance that draws its
+// connections from the shared transport pool
+func NewCoinbaseTester(transports *TransportPool) *CoinbaseTester {
 	return &CoinbaseTester{
-		client: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+		transports: transports,
+		url:        DefaultCoinbaseURL,
+		timeout:    DefaultTimeout,
+		logger:     discardLogger,
+	}
+}
+
+// Configure overrides the test endpoint, timeout, proxy family and logger
+func (c *CoinbaseTester) Configure(options TesterOptions) {
+	if options.URL != "" {
+		c.url = options.URL
+	}
+	if options.Timeout > 0 {
+		c.timeout = options.Timeout
+	}
+	if options.ProxyFamily != 0 {
+		c.proxyFamily = options.ProxyFamily
+	}
+	if options.Logger != nil {
+		c.logger = options.Logger
 	}
 }
 
@@ -36,49 +64,54 @@ func (c *CoinbaseTester) TestProxy(proxyAddress string, port int) (*TestResult,
 	// Create proxy URL
 	proxyURL, err := CreateProxyURL(proxyAddress, port)
 	if err != nil {
-		return &TestResult{
-			ProxyAddress: proxyAddress,
-			Port:         port,
-			Success:      false,
-			Error:        fmt.Sprintf("Invalid proxy URL: %v", err),
-			ResponseTime: 0,
-		}, nil
+		result := invalidProxyResult(proxyAddress, port, err)
+		logResult(c.logger, c.GetName(), result)
+		return result, nil
 	}
+	return c.TestProxyURL(context.Background(), proxyURL)
+}
 
-	// Create transport with proxy
-	transport := &http.Transport{
-		Proxy: http.ProxyURL(proxyURL),
-	}
+// TestProxyURL tests if the proxy at proxyURL works with Coinbase API
+func (c *CoinbaseTester) TestProxyURL(ctx context.Context, proxyURL *url.URL) (result *TestResult, err error) {
+	proxyAddress, port := proxyEndpoint(proxyURL)
 
-	// Create client with custom transport
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   10 * time.Second,
-	}
+	// The family of the proxy's address, until a connection tells otherwise
+	family := AddressFamily(proxyAddress)
+	defer func() {
+		if result != nil {
+			result.Family = family
+		}
+		logResult(c.logger, c.GetName(), result)
+	}()
+
+	// Reuse the pooled transport for this proxy
+	client := c.transports.Client(proxyURL, c.proxyFamily, c.timeout)
 
 	// Test endpoint - Coinbase spot price for BTC-USD
-	testURL := "https://api.coinbase.com/v2/prices/BTC-USD/spot"
+	testURL := c.url
 
 	startTime := time.Now()
 
-	req, err := http.NewRequest("GET", testURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", testURL, nil)
 	if err != nil {
 		return &TestResult{
 			ProxyAddress: proxyAddress,
 			Port:         port,
 			Success:      false,
 			Error:        fmt.Sprintf("Failed to create request: %v", err),
+			FailureKind:  FailureInternal,
 			ResponseTime: time.Since(startTime),
 		}, nil
 	}
 
-	resp, err := client.Do(req)
+	resp, err := client.Do(traceFamily(req, &family))
 	if err != nil {
 		return &TestResult{
 			ProxyAddress: proxyAddress,
 			Port:         port,
 			Success:      false,
 			Error:        fmt.Sprintf("Request failed: %v", err),
+			FailureKind:  ClassifyRequestError(err),
 			ResponseTime: time.Since(startTime),
 		}, nil
 	}
@@ -94,6 +127,7 @@ func (c *CoinbaseTester) TestProxy(proxyAddress string, port int) (*TestResult,
 			Port:         port,
 			Success:      false,
 			Error:        fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(body)),
+			FailureKind:  ClassifyStatus(resp.StatusCode),
 			ResponseTime: responseTime,
 		}, nil
 	}
@@ -106,6 +140,7 @@ func (c *CoinbaseTester) TestProxy(proxyAddress string, port int) (*TestResult,
 			Port:         port,
 			Success:      false,
 			Error:        fmt.Sprintf("Failed to read response: %v", err),
+			FailureKind:  FailureBadResponse,
 			ResponseTime: responseTime,
 		}, nil
 	}
@@ -117,6 +152,7 @@ func (c *CoinbaseTester) TestProxy(proxyAddress string, port int) (*TestResult,
 			Port:         port,
 			Success:      false,
 			Error:        fmt.Sprintf("Invalid JSON response: %v", err),
+			FailureKind:  FailureBadResponse,
 			ResponseTime: responseTime,
 		}, nil
 	}
@@ -128,16 +164,21 @@ func (c *CoinbaseTester) TestProxy(proxyAddress string, port int) (*TestResult,
 			Port:         port,
 			Success:      false,
 			Error:        "Unexpected response format",
+			FailureKind:  FailureBadResponse,
 			ResponseTime: responseTime,
 		}, nil
 	}
 
+	// Repeat the request over the open connection to measure warm latency
+	warmResponseTime := measureWarm(ctx, client, testURL)
+
 	return &TestResult{
-		ProxyAddress: proxyAddress,
-		Port:         port,
-		Success:      true,
-		ResponseTime: responseTime,
-		Data:         fmt.Sprintf("BTC Price: $%s", coinbaseResp.Data.Amount),
+		ProxyAddress:     proxyAddress,
+		Port:             port,
+		Success:          true,
+		ResponseTime:     responseTime,
+		WarmResponseTime: warmResponseTime,
+		Data:             fmt.Sprintf("BTC Price: $%s", coinbaseResp.Data.Amount),
 	}, nil
 }
 
@@ -145,3 +186,8 @@ func (c *CoinbaseTester) TestProxy(proxyAddress string, port int) (*TestResult,
 func (c *CoinbaseTester) GetName() string {
 	return "Coinbase"
 }
+
+// Hosts returns the destination hosts this tester's result applies to
+func (c *CoinbaseTester) Hosts() []string {
+	return []string{"*.coinbase.com"}
+}
@@ -0,0 +1,72 @@
+package exchanges
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// Failure kinds recorded in TestResult.FailureKind
+const (
+	FailureInvalidProxy = "invalid_proxy" // proxy URL could not be built
+	FailureTimeout      = "timeout"       // request timed out
+	FailureProxyConnect = "proxy_connect" // could not connect to or tunnel through the proxy
+	FailureProxyAuth    = "proxy_auth"    // proxy rejected the credentials
+	FailureTLS          = "tls"           // TLS handshake with the exchange failed
+	FailureNetwork      = "network"       // any other transport error
+	FailureBlocked      = "blocked"       // exchange refused the proxy's IP or region
+	FailureRateLimited  = "rate_limited"  // exchange rate limit hit
+	FailureHTTPStatus   = "http_status"   // any other non-200 status
+	FailureBadResponse  = "bad_response"  // body could not be read or was unexpected
+	FailureInternal     = "internal"      // error inside the tester itself
+)
+
+// FailureKinds lists every failure kind, in a stable order for reports
+var FailureKinds = []string{
+	FailureInvalidProxy,
+	FailureTimeout,
+	FailureProxyConnect,
+	FailureProxyAuth,
+	FailureTLS,
+	FailureNetwork,
+	FailureBlocked,
+	FailureRateLimited,
+	FailureHTTPStatus,
+	FailureBadResponse,
+	FailureInternal,
+}
+
+// ClassifyRequestError maps an error returned by http.Client.Do to a failure kind
+func ClassifyRequestError(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return FailureTimeout
+	}
+
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "Proxy Authentication Required"):
+		return FailureProxyAuth
+	case strings.Contains(message, "proxyconnect"), errors.Is(err, syscall.ECONNREFUSED):
+		return FailureProxyConnect
+	case strings.Contains(message, "tls:"), strings.Contains(message, "x509:"):
+		return FailureTLS
+	}
+
+	return FailureNetwork
+}
+
+// ClassifyStatus maps a non-200 HTTP status code to a failure kind
+func ClassifyStatus(statusCode int) string {
+	switch statusCode {
+	case http.StatusProxyAuthRequired:
+		return FailureProxyAuth
+	case http.StatusForbidden, http.StatusUnavailableForLegalReasons:
+		return FailureBlocked
+	case http.StatusTooManyRequests, http.StatusTeapot: // Binance answers 418 once an IP is banned
+		return FailureRateLimited
+	}
+	return FailureHTTPStatus
+}
@@ -2,24 +2,27 @@ package exchanges
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 )
 
 // Registry holds all available exchange testers
 type Registry struct {
-	testers map[string]ExchangeTester
-	mutex   sync.RWMutex
+	testers    map[string]ExchangeTester
+	transports *TransportPool
+	mutex      sync.RWMutex
 }
 
 // NewRegistry creates a new exchange registry
 func NewRegistry() *Registry {
 	registry := &Registry{
-		testers: make(map[string]ExchangeTester),
+		testers:    make(map[string]ExchangeTester),
+		transports: NewTransportPool(),
 	}
 
-	// Register default testers
-	registry.Register("binance", NewBinanceTester())
-	registry.Register("coinbase", NewCoinbaseTester())
+	// Register default testers, sharing one transport per proxy
+	registry.Register("binance", NewBinanceTester(registry.transports))
+	registry.Register("coinbase", NewCoinbaseTester(registry.transports))
 
 	return registry
 }
@@ -44,7 +47,7 @@ func (r *Registry) Get(name string) (ExchangeTester, error) {
 	return tester, nil
 }
 
-// List returns all available exchange names
+// List returns all available exchange names in alphabetical order
 func (r *Registry) List() []string {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
@@ -53,10 +56,21 @@ func (r *Registry) List() []string {
 	for name := range r.testers {
 		names = append(names, name)
 	}
+	sort.Strings(names)
 
 	return names
 }
 
+// Transports returns the transport pool shared by the registered testers
+func (r *Registry) Transports() *TransportPool {
+	return r.transports
+}
+
+// CloseIdleConnections closes idle proxy connections held by the registered testers
+func (r *Registry) CloseIdleConnections() {
+	r.transports.CloseIdleConnections()
+}
+
 // GetTesterForExchange returns the appropriate tester for the given exchange
 func GetTesterForExchange(exchangeName string) (ExchangeTester, error) {
 	registry := NewRegistry()
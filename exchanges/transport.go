@@ -0,0 +1,154 @@
+package exchanges
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// TransportPool shares one http.Transport per proxy across all testers, so
+// testing several exchanges through the same proxy reuses its connections
+type TransportPool struct {
+	transports map[string]*http.Transport
+	mutex      sync.Mutex
+}
+
+// NewTransportPool creates an empty transport pool
+func NewTransportPool() *TransportPool {
+	return &TransportPool{
+		transports: make(map[string]*http.Transport),
+	}
+}
+
+// Get returns the transport for the given proxy, creating it on first use
+func (p *TransportPool) Get(proxyURL *url.URL) *http.Transport {
+	return p.GetFor(proxyURL, 0)
+}
+
+// GetFor returns the transport for the given proxy that dials it over IPv4
+// only when family is 4, over IPv6 only when it is 6, and over either when
+// it is 0. The family applies to the connection to the proxy only; the proxy
+// reaches the target over whichever family it chooses.
+func (p *TransportPool) GetFor(proxyURL *url.URL, family int) *http.Transport {
+	network := dialNetwork(family)
+	key := network + " " + proxyURL.String()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if transport, exists := p.transports[key]; exists {
+		return transport
+	}
+
+	transport := &http.Transport{
+		Proxy:               http.ProxyURL(proxyURL),
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	if network != "tcp" {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		transport.DialContext = func(ctx context.Context, _, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		}
+	}
+	p.transports[key] = transport
+	return transport
+}
+
+// Client returns an HTTP client that routes through the pooled transport for
+// the given proxy, dialed over the family as for GetFor
+func (p *TransportPool) Client(proxyURL *url.URL, family int, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: p.GetFor(proxyURL, family),
+		Timeout:   timeout,
+	}
+}
+
+// dialNetwork returns the network to dial proxies on for an address family
+func dialNetwork(family int) string {
+	switch family {
+	case 4:
+		return "tcp4"
+	case 6:
+		return "tcp6"
+	}
+	return "tcp"
+}
+
+// traceFamily returns req instrumented to record in family the address
+// family of the connection it is sent over. family keeps its value when no
+// connection is made.
+func traceFamily(req *http.Request, family *string) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if addr, ok := info.Conn.RemoteAddr().(*net.TCPAddr); ok {
+				if addr.IP.To4() != nil {
+					*family = FamilyIPv4
+				} else {
+					*family = FamilyIPv6
+				}
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// CloseIdleConnections closes idle connections on every pooled transport
+func (p *TransportPool) CloseIdleConnections() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, transport := range p.transports {
+		transport.CloseIdleConnections()
+	}
+}
+
+// Close closes idle connections and drops every pooled transport
+func (p *TransportPool) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for key, transport := range p.transports {
+		transport.CloseIdleConnections()
+		delete(p.transports, key)
+	}
+}
+
+// measureWarm repeats a GET request over an already open connection and
+// returns its latency, or 0 if the request failed or a new connection was needed
+func measureWarm(ctx context.Context, client *http.Client, testURL string) time.Duration {
+	WaitBeforeWarmRequest(ctx)
+
+	reused := false
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			reused = info.Reused
+		},
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), "GET", testURL, nil)
+	if err != nil {
+		return 0
+	}
+
+	startTime := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0
+	}
+	responseTime := time.Since(startTime)
+
+	// Drain the body so the connection goes back to the pool
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !reused {
+		return 0
+	}
+	return responseTime
+}
@@ -1,22 +1,62 @@
 package exchanges
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
-// TestResult represents the result of testing a proxy with an exchange
+// DefaultTimeout is how long a tester waits for its test request
+const DefaultTimeout = 10 * time.Second
+
+// TestResult represents the result of testing a proxy with an exchange.
+// ResponseTime is the cold latency of the first request, which includes
+// opening the proxy tunnel; WarmResponseTime is the latency of a follow-up
+// request over the same connection and is zero if it could not be measured.
 type TestResult struct {
-	Exchange     string        `json:"exchange"`
-	ProxyAddress string        `json:"proxy_address"`
-	Port         int           `json:"port"`
-	CountryCode  string        `json:"country_code,omitempty"`
-	Success      bool          `json:"success"`
-	Error        string        `json:"error,omitempty"`
-	ResponseTime time.Duration `json:"response_time"`
-	Data         string        `json:"data,omitempty"`
+	Exchange         string        `json:"exchange"`
+	ProxyAddress     string        `json:"proxy_address"`
+	Port             int           `json:"port"`
+	CountryCode      string        `json:"country_code,omitempty"`
+	Success          bool          `json:"success"`
+	Error            string        `json:"error,omitempty"`
+	FailureKind      string        `json:"failure_kind,omitempty"`
+	ResponseTime     time.Duration `json:"response_time"`
+	WarmResponseTime time.Duration `json:"warm_response_time,omitempty"`
+	Family           string        `json:"family,omitempty"` // FamilyIPv4 or FamilyIPv6; empty if unknown
+	Data             string        `json:"data,omitempty"`
+}
+
+// Address families of the connection to a proxy, as reported in TestResult.Family
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// AddressFamily returns the family of an IP address, with or without
+// brackets, or an empty string for a host name
+func AddressFamily(address string) string {
+	ip, err := netip.ParseAddr(strings.Trim(address, "[]"))
+	switch {
+	case err != nil:
+		return ""
+	case ip.Unmap().Is4():
+		return FamilyIPv4
+	}
+	return FamilyIPv6
+}
+
+// ProxyHostPort joins a proxy address and port as host:port, bracketing
+// IPv6 addresses
+func ProxyHostPort(proxyAddress string, port int) string {
+	return net.JoinHostPort(strings.Trim(proxyAddress, "[]"), strconv.Itoa(port))
 }
 
 // ExchangeTester interface defines methods that all exchange testers must implement
@@ -25,16 +65,118 @@ type ExchangeTester interface {
 	GetName() string
 }
 
-// CreateProxyURL creates a proper URL for proxy configuration with authentication
+// ProxyURLTester is implemented by testers that can test a proxy given by
+// its full URL, so its own scheme and credentials are used. The test's
+// requests are made with ctx.
+type ProxyURLTester interface {
+	TestProxyURL(ctx context.Context, proxyURL *url.URL) (*TestResult, error)
+}
+
+type warmRequestWaitKey struct{}
+
+// WithWarmRequestWait returns a context under which a tester calls wait right
+// before it sends the warm request of a test, so a rate limiter can take a
+// token for it only when it is actually sent
+func WithWarmRequestWait(ctx context.Context, wait func()) context.Context {
+	return context.WithValue(ctx, warmRequestWaitKey{}, wait)
+}
+
+// WaitBeforeWarmRequest calls the wait set with WithWarmRequestWait, if any
+func WaitBeforeWarmRequest(ctx context.Context) {
+	if wait, ok := ctx.Value(warmRequestWaitKey{}).(func()); ok {
+		wait()
+	}
+}
+
+// HostProvider is implemented by testers that know which destination hosts
+// their test is representative of, as "*.example.com" or exact host patterns
+type HostProvider interface {
+	Hosts() []string
+}
+
+// TesterOptions overrides a tester's defaults; zero fields keep the default
+type TesterOptions struct {
+	URL     string
+	Timeout time.Duration
+	// ProxyFamily 4 or 6 dials proxies over IPv4 or IPv6 only; 0 allows
+	// either. It picks the connection to the proxy, not the address family
+	// the proxy uses to reach the exchange.
+	ProxyFamily int
+	Logger      *slog.Logger // receives a debug record for every test; silent by default
+}
+
+// discardLogger is the logger of testers that were not given one
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// logResult records the outcome of one test at debug level
+func logResult(logger *slog.Logger, exchange string, result *TestResult) {
+	if result == nil {
+		return
+	}
+	proxy := ProxyHostPort(result.ProxyAddress, result.Port)
+	if result.Success {
+		logger.Debug("proxy test passed", "exchange", exchange, "proxy", proxy, "latency", result.ResponseTime)
+		return
+	}
+	logger.Debug("proxy test failed", "exchange", exchange, "proxy", proxy,
+		"latency", result.ResponseTime, "failure_kind", result.FailureKind, "error", result.Error)
+}
+
+// Configurable is implemented by testers whose endpoint and timeout can be changed
+type Configurable interface {
+	Configure(options TesterOptions)
+}
+
+// proxyCredentials returns the credentials for proxy URLs given without
+// their own, from the PROXY_USER and PROXY_PASS environment variables
+func proxyCredentials() (username, password string) {
+	return os.Getenv("PROXY_USER"), os.Getenv("PROXY_PASS")
+}
+
+// CreateProxyURL creates a proper URL for proxy configuration with
+// authentication. IPv6 addresses are bracketed and credentials escaped.
 func CreateProxyURL(proxyAddress string, port int) (*url.URL, error) {
-	username := os.Getenv("PROXY_USER")
-	password := os.Getenv("PROXY_PASS")
+	return NewProxyURL("", proxyAddress, port, "", "")
+}
 
-	if username != "" && password != "" {
-		// Include authentication in the proxy URL
-		return url.Parse(fmt.Sprintf("http://%s:%s@%s:%d", username, password, proxyAddress, port))
+// NewProxyURL creates the URL of a proxy with the given scheme, http if
+// empty, and credentials. Without a username, PROXY_USER and PROXY_PASS are
+// used when both are set.
+func NewProxyURL(scheme, proxyAddress string, port int, username, password string) (*url.URL, error) {
+	if scheme == "" {
+		scheme = "http"
 	}
+	proxyURL := &url.URL{Scheme: scheme, Host: ProxyHostPort(proxyAddress, port)}
+
+	switch {
+	case username != "":
+		proxyURL.User = url.UserPassword(username, password)
+	default:
+		if username, password = proxyCredentials(); username != "" && password != "" {
+			// Include authentication in the proxy URL
+			proxyURL.User = url.UserPassword(username, password)
+		}
+	}
+
+	// Parse the result so invalid addresses are rejected before use
+	return url.Parse(proxyURL.String())
+}
+
+// invalidProxyResult is the result of testing a proxy whose URL cannot be built
+func invalidProxyResult(proxyAddress string, port int, err error) *TestResult {
+	return &TestResult{
+		ProxyAddress: proxyAddress,
+		Port:         port,
+		Success:      false,
+		Error:        fmt.Sprintf("Invalid proxy URL: %v", err),
+		FailureKind:  FailureInvalidProxy,
+		ResponseTime: 0,
+		Family:       AddressFamily(proxyAddress),
+	}
+}
 
-	// No authentication
-	return url.Parse(fmt.Sprintf("http://%s:%d", proxyAddress, port))
+// proxyEndpoint returns the address and port of a proxy URL
+func proxyEndpoint(proxyURL *url.URL) (string, int) {
+	port, _ := strconv.Atoi(proxyURL.Port())
+	return proxyURL.Hostname(), port
 }
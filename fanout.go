@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go-proxy/exchanges"
+	"go-proxy/proxypool"
+)
+
+// rateLimit is a token-bucket configuration in requests per second
+type rateLimit struct {
+	rate  float64
+	burst int
+}
+
+// fanOutLimits controls how aggressively tests are dispatched
+type fanOutLimits struct {
+	concurrency         int                  // tests in flight overall
+	exchangeConcurrency int                  // tests in flight per exchange, 0 means no extra cap
+	proxyConcurrency    int                  // tests in flight per proxy, 0 means no extra cap
+	rates               map[string]rateLimit // per exchange name, missing means unlimited
+}
+
+// newFanOutLimits returns the fan-out limits of the test configuration
+func newFanOutLimits(test testConfig, testers []exchanges.ExchangeTester) fanOutLimits {
+	limits := fanOutLimits{
+		concurrency:         test.Concurrency,
+		exchangeConcurrency: test.ExchangeConcurrency,
+		proxyConcurrency:    test.ProxyConcurrency,
+		rates:               make(map[string]rateLimit),
+	}
+
+	for _, tester := range testers {
+		exchange := test.Exchanges[exchangeLabel(tester.GetName())]
+		rate, burst := test.Rate, test.Burst
+		if exchange.Rate > 0 {
+			rate = exchange.Rate
+		}
+		if exchange.Burst > 0 {
+			burst = exchange.Burst
+		}
+		if rate <= 0 {
+			continue
+		}
+		limits.rates[tester.GetName()] = rateLimit{rate: rate, burst: burst}
+	}
+
+	return limits
+}
+
+// dispatchGate lets an interactive caller pause and abort a test run. Tests
+// already in flight finish; tests not yet started wait while the gate is
+// paused and are skipped once it is aborted.
+type dispatchGate struct {
+	mutex   sync.Mutex
+	changed *sync.Cond
+	paused  bool
+	aborted bool
+}
+
+func newDispatchGate() *dispatchGate {
+	g := &dispatchGate{}
+	g.changed = sync.NewCond(&g.mutex)
+	return g
+}
+
+// setPaused pauses or resumes dispatch
+func (g *dispatchGate) setPaused(paused bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.paused = paused
+	g.changed.Broadcast()
+}
+
+// abort stops dispatching; it cannot be undone
+func (g *dispatchGate) abort() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.aborted = true
+	g.changed.Broadcast()
+}
+
+// isAborted reports whether the run was aborted
+func (g *dispatchGate) isAborted() bool {
+	if g == nil {
+		return false
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.aborted
+}
+
+// wait blocks while dispatch is paused and reports whether the next test
+// may start. A nil gate never blocks.
+func (g *dispatchGate) wait() bool {
+	if g == nil {
+		return true
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for g.paused && !g.aborted {
+		g.changed.Wait()
+	}
+	return !g.aborted
+}
+
+// runTests tests every proxy against every tester and returns the results in
+// completion order. onResult is called as each test finishes, with the
+// proxy tested and the number of tests completed so far. Retries and rate-limit waits are logged at debug
+// level. gate may be nil; tests skipped after an abort have no result.
+func runTests(proxies []proxypool.Proxy, testers []exchanges.ExchangeTester, limits fanOutLimits, gate *dispatchGate, logger *slog.Logger, onResult func(proxy proxypool.Proxy, result *exchanges.TestResult, completed, total int)) []*exchanges.TestResult {
+	var wg sync.WaitGroup
+	totalTests := len(proxies) * len(testers)
+	results := make(chan *exchanges.TestResult, totalTests)
+
+	// Semaphores are always acquired in the order exchange, proxy, global,
+	// so goroutines waiting on different caps can never deadlock. A test
+	// waits for its rate-limit token holding at most its exchange slot, so
+	// a throttled exchange does not keep the others from the global slots.
+	semaphore := make(chan struct{}, limits.concurrency)
+	exchangeSemaphores := make(map[string]chan struct{})
+	buckets := make(map[string]*tokenBucket)
+	for _, tester := range testers {
+		if limits.exchangeConcurrency > 0 {
+			exchangeSemaphores[tester.GetName()] = make(chan struct{}, limits.exchangeConcurrency)
+		}
+		if limit, ok := limits.rates[tester.GetName()]; ok {
+			buckets[tester.GetName()] = newTokenBucket(limit.rate, limit.burst)
+		}
+	}
+	proxySemaphores := make(map[string]chan struct{})
+	if limits.proxyConcurrency > 0 {
+		for _, proxy := range proxies {
+			proxySemaphores[proxy.Key()] = make(chan struct{}, limits.proxyConcurrency)
+		}
+	}
+
+	// Progress tracking
+	var progressMutex sync.Mutex
+	completedTests := 0
+
+	for _, tester := range testers {
+		exchangeName := tester.GetName()
+		for _, proxy := range proxies {
+			wg.Add(1)
+			go func(tester exchanges.ExchangeTester, proxy proxypool.Proxy, exchangeName string) {
+				defer wg.Done()
+				if sem, ok := exchangeSemaphores[exchangeName]; ok {
+					sem <- struct{}{}
+					defer func() { <-sem }()
+				}
+
+				// A test takes a rate-limit token for each request it sends:
+				// one before the cold request, and one before the warm
+				// request that only a passing test sends
+				ctx := context.Background()
+				bucket, limited := buckets[exchangeName]
+				if limited {
+					ctx = exchanges.WithWarmRequestWait(ctx, bucket.Wait)
+				}
+
+				// runAttempt tests once in the proxy and global slots; a run
+				// aborted before the test starts sets aborted instead
+				aborted := false
+				runAttempt := func() (*exchanges.TestResult, error) {
+					if sem, ok := proxySemaphores[proxy.Key()]; ok {
+						sem <- struct{}{}
+						defer func() { <-sem }()
+					}
+					semaphore <- struct{}{}
+					defer func() { <-semaphore }()
+					if !gate.wait() {
+						aborted = true
+						return nil, nil
+					}
+					return proxypool.TestProxy(ctx, tester, proxy)
+				}
+
+				// Optionally: Add retry logic here (simple 1 retry for transient errors)
+				var result *exchanges.TestResult
+				var err error
+				for attempt := 1; attempt <= 2; attempt++ {
+					if attempt > 1 {
+						logger.Debug("retrying test", "exchange", exchangeName, "proxy", proxy.Key())
+						time.Sleep(500 * time.Millisecond)
+					}
+					if limited {
+						waitStart := time.Now()
+						bucket.Wait()
+						if waited := time.Since(waitStart); waited >= time.Millisecond {
+							logger.Debug("rate limited", "exchange", exchangeName, "proxy", proxy.Key(), "waited", waited.Round(time.Millisecond))
+						}
+					}
+					attemptResult, attemptErr := runAttempt()
+					if aborted {
+						break
+					}
+					result, err = attemptResult, attemptErr
+					if err == nil && result.Success {
+						break
+					}
+				}
+				if result == nil && err == nil {
+					return // aborted before the first attempt
+				}
+				if result == nil {
+					result = &exchanges.TestResult{
+						ProxyAddress: proxy.ProxyAddress,
+						Port:         proxy.Port,
+						CountryCode:  proxy.CountryCode,
+						Success:      false,
+						Error:        fmt.Sprintf("Test error: %v", err),
+						FailureKind:  exchanges.FailureInternal,
+						ResponseTime: 0,
+					}
+				}
+				result.Exchange = exchangeName
+				result.CountryCode = proxy.CountryCode
+				results <- result
+
+				// Update progress and report the result immediately
+				progressMutex.Lock()
+				completedTests++
+				currentProgress := completedTests
+				progressMutex.Unlock()
+
+				if onResult != nil {
+					onResult(proxy, result, currentProgress, totalTests)
+				}
+			}(tester, proxy, exchangeName)
+		}
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	collected := make([]*exchanges.TestResult, 0, totalTests)
+	for result := range results {
+		collected = append(collected, result)
+	}
+	return collected
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go-proxy/exchanges"
+	"go-proxy/proxypool"
+)
+
+// fakeTester passes every test without sending a request. With warm set,
+// it waits as a tester does before its warm request.
+type fakeTester struct {
+	name   string
+	warm   bool
+	mutex  sync.Mutex
+	tested []string
+}
+
+func (f *fakeTester) GetName() string { return f.name }
+
+func (f *fakeTester) TestProxy(proxyAddress string, port int) (*exchanges.TestResult, error) {
+	proxyURL, err := exchanges.CreateProxyURL(proxyAddress, port)
+	if err != nil {
+		return nil, err
+	}
+	return f.TestProxyURL(context.Background(), proxyURL)
+}
+
+func (f *fakeTester) TestProxyURL(ctx context.Context, proxyURL *url.URL) (*exchanges.TestResult, error) {
+	f.mutex.Lock()
+	f.tested = append(f.tested, proxyURL.Host)
+	f.mutex.Unlock()
+
+	if f.warm {
+		exchanges.WaitBeforeWarmRequest(ctx)
+	}
+	port, _ := strconv.Atoi(proxyURL.Port())
+	return &exchanges.TestResult{ProxyAddress: proxyURL.Hostname(), Port: port, Success: true}, nil
+}
+
+func TestRunTestsTakesATokenPerRequest(t *testing.T) {
+	proxies := []proxypool.Proxy{
+		{ProxyAddress: "192.0.2.1", Port: 80},
+		{ProxyAddress: "192.0.2.2", Port: 80},
+		{ProxyAddress: "192.0.2.3", Port: 80},
+	}
+	tests := []struct {
+		name     string
+		warm     bool
+		requests int
+	}{
+		{"cold requests only", false, 3},
+		{"warm requests", true, 6},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tester := &fakeTester{name: "Fake", warm: test.warm}
+			limits := fanOutLimits{
+				concurrency: 10,
+				rates:       map[string]rateLimit{"Fake": {rate: 20, burst: 1}},
+			}
+
+			start := time.Now()
+			results := runTests(proxies, []exchanges.ExchangeTester{tester}, limits, nil, slog.New(slog.DiscardHandler), nil)
+			elapsed := time.Since(start)
+
+			if len(results) != len(proxies) || len(tester.tested) != len(proxies) {
+				t.Fatalf("got %d results from %d tests, want %d", len(results), len(tester.tested), len(proxies))
+			}
+			// The first request takes the burst, every other one 50ms
+			want := time.Duration(test.requests-1) * 50 * time.Millisecond
+			if elapsed < want-5*time.Millisecond || elapsed > want+40*time.Millisecond {
+				t.Errorf("%d requests at 20 per second took %s, want about %s", test.requests, elapsed, want)
+			}
+		})
+	}
+}
+
+func TestRunTestsRateLimitHoldsNoGlobalSlot(t *testing.T) {
+	throttled, fast := &fakeTester{name: "Throttled", warm: true}, &fakeTester{name: "Fast"}
+	var proxies []proxypool.Proxy
+	for port := 1; port <= 4; port++ {
+		proxies = append(proxies, proxypool.Proxy{ProxyAddress: "192.0.2.1", Port: port})
+	}
+	limits := fanOutLimits{
+		concurrency: 2,
+		rates:       map[string]rateLimit{"Throttled": {rate: 10, burst: 1}},
+	}
+
+	var mutex sync.Mutex
+	var fastDone time.Duration
+	start := time.Now()
+	runTests(proxies, []exchanges.ExchangeTester{throttled, fast}, limits, nil, slog.New(slog.DiscardHandler),
+		func(proxy proxypool.Proxy, result *exchanges.TestResult, completed, total int) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if result.Exchange == "Fast" {
+				fastDone = time.Since(start)
+			}
+		})
+	elapsed := time.Since(start)
+
+	if len(fast.tested) != len(proxies) || len(throttled.tested) != len(proxies) {
+		t.Fatalf("tested %d fast and %d throttled, want %d each", len(fast.tested), len(throttled.tested), len(proxies))
+	}
+	// The throttled exchange needs 7 tokens beyond its burst, 700ms
+	if elapsed < 600*time.Millisecond {
+		t.Errorf("run took %s, want the throttled exchange paced", elapsed)
+	}
+	if fastDone > 200*time.Millisecond {
+		t.Errorf("the unlimited exchange finished after %s of a %s run, want it not held up by the throttled one", fastDone, elapsed)
+	}
+}
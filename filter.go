@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/netip"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go-proxy/exchanges"
+	"go-proxy/proxypool"
+)
+
+// portRange is an inclusive range of ports
+type portRange struct {
+	from, to int
+}
+
+// proxyFilter selects the proxies a test run covers. The zero value keeps
+// every proxy.
+type proxyFilter struct {
+	countries        []string // keep only these country codes
+	excludeCountries []string
+	ports            []portRange
+	prefixes         []netip.Prefix
+
+	onlyFailed  bool // keep proxies with a failed test in the last run
+	onlyHealthy bool // keep proxies whose tests all passed in the last run
+
+	sample   int // random sample size; 0 keeps all
+	seed     uint64
+	stratify bool // sample each country in proportion to its share
+}
+
+// newProxyFilter validates the selection flags of the test command
+func newProxyFilter(options testOptions) (*proxyFilter, error) {
+	filter := &proxyFilter{
+		countries:        splitCountries(options.countries),
+		excludeCountries: splitCountries(options.excludeCountries),
+		onlyFailed:       options.onlyFailed,
+		onlyHealthy:      options.onlyHealthy,
+		sample:           options.sample,
+		seed:             options.seed,
+		stratify:         options.stratify,
+	}
+
+	for _, field := range splitList(options.ports) {
+		from, to, isRange := strings.Cut(field, "-")
+		if !isRange {
+			to = from
+		}
+		first, err1 := strconv.Atoi(from)
+		last, err2 := strconv.Atoi(to)
+		if err1 != nil || err2 != nil || first < 1 || last > 65535 || first > last {
+			return nil, fmt.Errorf("invalid --port %q: must be a port or a range like 8000-8100", field)
+		}
+		filter.ports = append(filter.ports, portRange{first, last})
+	}
+
+	for _, field := range splitList(options.cidrs) {
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --cidr %q: %v", field, err)
+		}
+		filter.prefixes = append(filter.prefixes, prefix.Masked())
+	}
+
+	switch {
+	case options.onlyFailed && options.onlyHealthy:
+		return nil, fmt.Errorf("--only-failed-last-run and --only-healthy-last-run cannot be combined")
+	case options.sample < 0:
+		return nil, fmt.Errorf("invalid --sample %d: must be a positive integer", options.sample)
+	case options.stratify && options.sample == 0:
+		return nil, fmt.Errorf("--stratify requires --sample")
+	}
+	return filter, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty fields
+func splitList(value string) []string {
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// splitCountries splits a comma-separated list of country codes
+func splitCountries(value string) []string {
+	countries := splitList(value)
+	for i, country := range countries {
+		countries[i] = strings.ToUpper(country)
+	}
+	return countries
+}
+
+// usesLastRun reports whether the filter needs the previous test run
+func (f *proxyFilter) usesLastRun() bool {
+	return f.onlyFailed || f.onlyHealthy
+}
+
+// matches reports whether a proxy passes the country, port and CIDR filters
+func (f *proxyFilter) matches(proxy proxypool.Proxy) bool {
+	country := strings.ToUpper(proxy.CountryCode)
+	if len(f.countries) > 0 && !slices.Contains(f.countries, country) {
+		return false
+	}
+	if slices.Contains(f.excludeCountries, country) {
+		return false
+	}
+
+	if len(f.ports) > 0 {
+		inRange := false
+		for _, ports := range f.ports {
+			if proxy.Port >= ports.from && proxy.Port <= ports.to {
+				inRange = true
+				break
+			}
+		}
+		if !inRange {
+			return false
+		}
+	}
+
+	if len(f.prefixes) > 0 {
+		addr, err := netip.ParseAddr(proxy.ProxyAddress)
+		if err != nil {
+			return false
+		}
+		addr = addr.Unmap()
+		inPrefix := false
+		for _, prefix := range f.prefixes {
+			if prefix.Contains(addr) {
+				inPrefix = true
+				break
+			}
+		}
+		if !inPrefix {
+			return false
+		}
+	}
+	return true
+}
+
+// lastRunHealth returns, for every proxy in the most recent run, whether all
+// of its tests passed
+func lastRunHealth() (map[string]bool, string, error) {
+	runs, err := loadHistory()
+	if err != nil || len(runs) == 0 {
+		return nil, "", fmt.Errorf("no previous test run in history")
+	}
+	last := runs[len(runs)-1]
+
+	healthy := make(map[string]bool)
+	for _, result := range last.Results {
+		key := exchanges.ProxyHostPort(result.ProxyAddress, result.Port)
+		passed, seen := healthy[key]
+		healthy[key] = result.Success && (passed || !seen)
+	}
+	return healthy, last.ID, nil
+}
+
+// apply returns the selected proxies, in cache order, and a description of
+// each selection step for the run header
+func (f *proxyFilter) apply(proxies []proxypool.Proxy) ([]proxypool.Proxy, []string, error) {
+	var steps []string
+
+	selected := make([]proxypool.Proxy, 0, len(proxies))
+	for _, proxy := range proxies {
+		if f.matches(proxy) {
+			selected = append(selected, proxy)
+		}
+	}
+	if len(selected) < len(proxies) {
+		steps = append(steps, fmt.Sprintf("%d of %d proxies match the filters", len(selected), len(proxies)))
+	}
+
+	if f.usesLastRun() {
+		health, runID, err := lastRunHealth()
+		if err != nil {
+			return nil, nil, err
+		}
+		wantHealthy := f.onlyHealthy
+		kept := selected[:0]
+		for _, proxy := range selected {
+			if healthy, tested := health[proxy.Key()]; tested && healthy == wantHealthy {
+				kept = append(kept, proxy)
+			}
+		}
+		selected = kept
+		state := "failed"
+		if wantHealthy {
+			state = "healthy"
+		}
+		steps = append(steps, fmt.Sprintf("%d proxies %s in run %s", len(selected), state, runID))
+	}
+
+	if f.sample > 0 && f.sample < len(selected) {
+		random := rand.New(rand.NewPCG(f.seed, f.seed))
+		if f.stratify {
+			selected = stratifiedSample(selected, f.sample, random)
+			steps = append(steps, fmt.Sprintf("sampled %d stratified by country (seed %d)", len(selected), f.seed))
+		} else {
+			selected = randomSample(selected, f.sample, random)
+			steps = append(steps, fmt.Sprintf("sampled %d (seed %d)", len(selected), f.seed))
+		}
+	}
+	return selected, steps, nil
+}
+
+// dialableProxies drops the proxies that test.proxy_family rules out: those
+// whose address is an IP of the other version. Proxies given by host name
+// are kept, their names resolve over the chosen version when dialed.
+func dialableProxies(proxies []proxypool.Proxy, family int) []proxypool.Proxy {
+	if family == 0 {
+		return proxies
+	}
+	kept := make([]proxypool.Proxy, 0, len(proxies))
+	for _, proxy := range proxies {
+		addr, err := netip.ParseAddr(proxy.ProxyAddress)
+		if err == nil && addr.Unmap().Is4() != (family == 4) {
+			continue
+		}
+		kept = append(kept, proxy)
+	}
+	if skipped := len(proxies) - len(kept); skipped > 0 {
+		logger.Info("skipped proxies that cannot be dialed over the proxy family", "skipped", skipped, "proxy_family", family)
+	}
+	return kept
+}
+
+// randomSample picks n proxies uniformly at random, keeping their order
+func randomSample(proxies []proxypool.Proxy, n int, random *rand.Rand) []proxypool.Proxy {
+	indexes := random.Perm(len(proxies))[:n]
+	sort.Ints(indexes)
+
+	sample := make([]proxypool.Proxy, n)
+	for i, index := range indexes {
+		sample[i] = proxies[index]
+	}
+	return sample
+}
+
+// stratifiedSample picks n proxies with every country represented in
+// proportion to its share of the pool. When n allows it, each country gets
+// at least one proxy. The result keeps the original order.
+func stratifiedSample(proxies []proxypool.Proxy, n int, random *rand.Rand) []proxypool.Proxy {
+	byCountry := make(map[string][]int)
+	for i, proxy := range proxies {
+		country := strings.ToUpper(proxy.CountryCode)
+		byCountry[country] = append(byCountry[country], i)
+	}
+	countries := make([]string, 0, len(byCountry))
+	for country := range byCountry {
+		countries = append(countries, country)
+	}
+	sort.Strings(countries)
+
+	// Split n by size, handing out what rounding leaves over to the largest
+	// remainders
+	quota := make(map[string]int)
+	type share struct {
+		country  string
+		fraction float64
+	}
+	shares := make([]share, 0, len(countries))
+	allocated := 0
+	for _, country := range countries {
+		exact := float64(n) * float64(len(byCountry[country])) / float64(len(proxies))
+		whole := int(math.Floor(exact))
+		quota[country] = whole
+		allocated += whole
+		shares = append(shares, share{country, exact - float64(whole)})
+	}
+	sort.SliceStable(shares, func(i, j int) bool { return shares[i].fraction > shares[j].fraction })
+	for i := 0; i < n-allocated; i++ {
+		quota[shares[i].country]++
+	}
+
+	// Then give countries left without a proxy one from the largest quota
+	if n >= len(countries) {
+		for _, country := range countries {
+			if quota[country] > 0 {
+				continue
+			}
+			largest := countries[0]
+			for _, other := range countries {
+				if quota[other] > quota[largest] {
+					largest = other
+				}
+			}
+			quota[largest]--
+			quota[country] = 1
+		}
+	}
+
+	var indexes []int
+	for _, country := range countries {
+		members := byCountry[country]
+		for _, pick := range random.Perm(len(members))[:quota[country]] {
+			indexes = append(indexes, members[pick])
+		}
+	}
+	sort.Ints(indexes)
+
+	sample := make([]proxypool.Proxy, len(indexes))
+	for i, index := range indexes {
+		sample[i] = proxies[index]
+	}
+	return sample
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+
+	"go-proxy/proxypool"
+)
+
+func TestNewProxyFilterErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		options testOptions
+		want    string
+	}{
+		{"port not a number", testOptions{ports: "http"}, "invalid --port"},
+		{"port out of range", testOptions{ports: "0"}, "invalid --port"},
+		{"backwards port range", testOptions{ports: "9000-8000"}, "invalid --port"},
+		{"bad cidr", testOptions{cidrs: "192.0.2.0/33"}, "invalid --cidr"},
+		{"both last-run filters", testOptions{onlyFailed: true, onlyHealthy: true}, "cannot be combined"},
+		{"negative sample", testOptions{sample: -1}, "invalid --sample"},
+		{"stratify without sample", testOptions{stratify: true}, "--stratify requires --sample"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newProxyFilter(test.options)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("newProxyFilter() error = %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestProxyFilterMatches(t *testing.T) {
+	proxies := map[string]proxypool.Proxy{
+		"de":   {ProxyAddress: "192.0.2.10", Port: 8080, CountryCode: "de"},
+		"fr":   {ProxyAddress: "198.51.100.7", Port: 3128, CountryCode: "FR"},
+		"us6":  {ProxyAddress: "2001:db8::1", Port: 80, CountryCode: "US"},
+		"host": {ProxyAddress: "proxy.example", Port: 8081, CountryCode: "US"},
+	}
+
+	tests := []struct {
+		name    string
+		options testOptions
+		want    []string
+	}{
+		{"no filters", testOptions{}, []string{"de", "fr", "host", "us6"}},
+		{"countries", testOptions{countries: "DE, us"}, []string{"de", "host", "us6"}},
+		{"excluded countries", testOptions{excludeCountries: "us"}, []string{"de", "fr"}},
+		{"ports and ranges", testOptions{ports: "80,8000-8080"}, []string{"de", "us6"}},
+		{"IPv4 prefix", testOptions{cidrs: "192.0.2.0/24"}, []string{"de"}},
+		{"IPv6 prefix", testOptions{cidrs: "2001:db8::/32,198.51.100.0/24"}, []string{"fr", "us6"}},
+		{"combined", testOptions{countries: "US", ports: "8081"}, []string{"host"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter, err := newProxyFilter(test.options)
+			if err != nil {
+				t.Fatalf("newProxyFilter() error: %v", err)
+			}
+			var got []string
+			for name, proxy := range proxies {
+				if filter.matches(proxy) {
+					got = append(got, name)
+				}
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("matched %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDialableProxies(t *testing.T) {
+	useTestConfig(t)
+	proxies := []proxypool.Proxy{
+		{ProxyAddress: "192.0.2.1", Port: 80},
+		{ProxyAddress: "2001:db8::1", Port: 80},
+		{ProxyAddress: "::ffff:198.51.100.7", Port: 80},
+		{ProxyAddress: "proxy.example", Port: 80},
+	}
+
+	tests := []struct {
+		family int
+		want   []string
+	}{
+		{0, []string{"192.0.2.1", "2001:db8::1", "::ffff:198.51.100.7", "proxy.example"}},
+		{4, []string{"192.0.2.1", "::ffff:198.51.100.7", "proxy.example"}},
+		{6, []string{"2001:db8::1", "proxy.example"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, proxy := range dialableProxies(proxies, test.family) {
+			got = append(got, proxy.ProxyAddress)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("dialableProxies(family %d) = %v, want %v", test.family, got, test.want)
+		}
+	}
+}
+
+// countryPool returns a pool with the given number of proxies per country,
+// interleaved so that the original order is visible in a sample
+func countryPool(sizes map[string]int) []proxypool.Proxy {
+	var proxies []proxypool.Proxy
+	for port := 1; len(proxies) < total(sizes); port++ {
+		for _, country := range []string{"DE", "FR", "NL", "US"} {
+			if sizes[country] >= port {
+				proxies = append(proxies, proxypool.Proxy{ProxyAddress: "192.0.2.1", Port: port*10 + len(proxies), CountryCode: country})
+			}
+		}
+	}
+	return proxies
+}
+
+func total(sizes map[string]int) int {
+	n := 0
+	for _, size := range sizes {
+		n += size
+	}
+	return n
+}
+
+func TestStratifiedSample(t *testing.T) {
+	tests := []struct {
+		name  string
+		sizes map[string]int
+		n     int
+		want  map[string]int
+	}{
+		{"proportional", map[string]int{"DE": 60, "FR": 30, "NL": 10}, 10, map[string]int{"DE": 6, "FR": 3, "NL": 1}},
+		{"small countries get one", map[string]int{"DE": 97, "FR": 2, "NL": 1}, 4, map[string]int{"DE": 2, "FR": 1, "NL": 1}},
+		{"largest remainders", map[string]int{"DE": 5, "FR": 5, "NL": 5, "US": 5}, 6, map[string]int{"DE": 2, "FR": 2, "NL": 1, "US": 1}},
+		{"fewer than countries", map[string]int{"DE": 8, "FR": 1, "NL": 1}, 2, map[string]int{"DE": 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxies := countryPool(test.sizes)
+			sample := stratifiedSample(proxies, test.n, rand.New(rand.NewPCG(1, 1)))
+			if len(sample) != test.n {
+				t.Fatalf("sampled %d proxies, want %d", len(sample), test.n)
+			}
+
+			got := make(map[string]int)
+			for _, proxy := range sample {
+				got[proxy.CountryCode]++
+			}
+			for country, want := range test.want {
+				if got[country] != want {
+					t.Errorf("%s: sampled %d, want %d (sample by country %v)", country, got[country], want, got)
+				}
+			}
+
+			// The sample keeps the order of the pool
+			positions := make([]int, len(sample))
+			for i, proxy := range sample {
+				positions[i] = slices.IndexFunc(proxies, func(p proxypool.Proxy) bool { return p.Port == proxy.Port })
+			}
+			if !slices.IsSorted(positions) {
+				t.Errorf("sample positions %v are out of pool order", positions)
+			}
+		})
+	}
+}
+
+func TestProxyFilterSampleIsReproducible(t *testing.T) {
+	proxies := countryPool(map[string]int{"DE": 20, "FR": 20, "US": 20})
+	filter, err := newProxyFilter(testOptions{sample: 9, seed: 42, stratify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, steps, err := filter.apply(proxies)
+	if err != nil {
+		t.Fatalf("apply() error: %v", err)
+	}
+	second, _, _ := filter.apply(proxies)
+	if len(first) != 9 || !slices.EqualFunc(first, second, func(a, b proxypool.Proxy) bool { return a.Key() == b.Key() }) {
+		t.Errorf("two samples with seed 42 differ: %v and %v", first, second)
+	}
+	if len(steps) != 1 || !strings.Contains(steps[0], "seed 42") {
+		t.Errorf("steps = %q, want the sampling step with its seed", steps)
+	}
+}
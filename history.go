@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"go-proxy/exchanges"
+	"go-proxy/proxypool"
+)
+
+// History file name inside the data directory
+const historyFile = "history.jsonl"
+
+// defaultHistoryRuns is the default of history.max_runs
+const defaultHistoryRuns = 1000
+
+// historyRecord is one line of the append-only history file. A run's
+// first line may instead list the pool it tested from, in Pool, and hold
+// no result.
+type historyRecord struct {
+	RunID     string    `json:"run_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Pool      []string  `json:"pool,omitempty"`
+	exchanges.TestResult
+}
+
+// historyPoolRecord is the line of the history file listing a run's pool
+type historyPoolRecord struct {
+	RunID     string    `json:"run_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Pool      []string  `json:"pool"`
+}
+
+// historyRun groups the records of a single test run. It is also the
+// format of exported run files. Pool lists the proxies in the pool when
+// the run started, tested or not, and is nil for runs that did not record
+// it.
+type historyRun struct {
+	ID        string                  `json:"run_id"`
+	Timestamp time.Time               `json:"timestamp"`
+	Pool      []string                `json:"pool,omitempty"`
+	Results   []*exchanges.TestResult `json:"results"`
+}
+
+// poolMembers returns the distinct addresses of proxies, sorted, in the
+// address:port form that runs record their pool in
+func poolMembers(proxies []proxypool.Proxy) []string {
+	members := make([]string, 0, len(proxies))
+	for _, proxy := range proxies {
+		members = append(members, exchanges.ProxyHostPort(proxy.ProxyAddress, proxy.Port))
+	}
+	sort.Strings(members)
+	return slices.Compact(members)
+}
+
+// dataDir returns the directory holding the persistent data of the selected
+// pool, such as its test history
+func dataDir() (string, error) {
+	return poolDataDir(appConfig.pool)
+}
+
+// sharedDataDir returns the data directory shared by all pools. The
+// data_dir setting (or PROXY_DATA_DIR) overrides the default of
+// $XDG_DATA_HOME/go-proxy.
+func sharedDataDir() (string, error) {
+	if appConfig.DataDir != "" {
+		return appConfig.DataDir, nil
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "go-proxy"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine data directory: %v", err)
+	}
+	return filepath.Join(home, ".local", "share", "go-proxy"), nil
+}
+
+// historyPath returns the full path of the history file
+func historyPath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, historyFile), nil
+}
+
+// newRunID returns a sortable, practically unique identifier for a test run
+func newRunID(startedAt time.Time) string {
+	return fmt.Sprintf("%s-%04x", startedAt.UTC().Format("20060102T150405Z"), rand.IntN(0x10000))
+}
+
+// appendHistory appends a run, its pool and results, to the history file,
+// then drops the runs beyond history.max_runs
+func appendHistory(run *historyRun) error {
+	path, err := historyPath()
+	if err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	if run.Pool != nil {
+		if err := encoder.Encode(historyPoolRecord{RunID: run.ID, Timestamp: run.Timestamp, Pool: run.Pool}); err != nil {
+			return err
+		}
+	}
+	for _, result := range run.Results {
+		record := historyRecord{
+			RunID:      run.ID,
+			Timestamp:  run.Timestamp,
+			TestResult: *result,
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	if err := proxypool.AppendFile(path, buffer.Bytes()); err != nil {
+		return err
+	}
+
+	if appConfig.History.MaxRuns > 0 {
+		if err := compactHistory(path, appConfig.History.MaxRuns); err != nil {
+			return fmt.Errorf("compacting history: %v", err)
+		}
+	}
+	return nil
+}
+
+// compactHistory rewrites the history file at path with only its newest
+// maxRuns runs. The file is only rewritten when it holds more than that;
+// lines that do not parse are dropped along with the old runs.
+func compactHistory(path string, maxRuns int) error {
+	data, err := proxypool.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if _, runIDs := historyLines(data); len(runIDs) <= maxRuns {
+		return nil
+	}
+
+	// Count again under the lock, since another process may have compacted
+	return proxypool.UpdateFile(path, func(data []byte) ([]byte, error) {
+		lines, runIDs := historyLines(data)
+		if len(runIDs) <= maxRuns {
+			return data, nil
+		}
+		kept := make(map[string]bool, maxRuns)
+		for _, runID := range runIDs[len(runIDs)-maxRuns:] {
+			kept[runID] = true
+		}
+
+		var compacted bytes.Buffer
+		for _, line := range lines {
+			if kept[line.runID] {
+				compacted.Write(line.text)
+			}
+		}
+		return compacted.Bytes(), nil
+	})
+}
+
+// historyLine is one record of the history file, with its run ID parsed
+type historyLine struct {
+	text  []byte
+	runID string
+}
+
+// historyLines splits history file data into its records and returns the
+// IDs of their runs, oldest first as loadHistory orders them
+func historyLines(data []byte) ([]historyLine, []string) {
+	var lines []historyLine
+	var runIDs []string
+	started := make(map[string]time.Time)
+	for text := range bytes.Lines(data) {
+		var record struct {
+			RunID     string    `json:"run_id"`
+			Timestamp time.Time `json:"timestamp"`
+		}
+		if json.Unmarshal(text, &record) != nil {
+			continue
+		}
+		lines = append(lines, historyLine{text, record.RunID})
+		if _, seen := started[record.RunID]; !seen {
+			started[record.RunID] = record.Timestamp
+			runIDs = append(runIDs, record.RunID)
+		}
+	}
+	sort.SliceStable(runIDs, func(i, j int) bool {
+		return started[runIDs[i]].Before(started[runIDs[j]])
+	})
+	return lines, runIDs
+}
+
+// loadHistory reads every run from the history file, oldest first
+func loadHistory() ([]*historyRun, error) {
+	path, err := historyPath()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	runsByID := make(map[string]*historyRun)
+	var runs []*historyRun
+
+	scanner := bufio.NewScanner(file)
+	// A pool line grows with the pool; result lines stay short
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var record historyRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			// A crash mid-append can leave a partial last line; skip it
+			logger.Warn("skipping malformed history line", "file", path, "line", lineNumber, "error", err)
+			continue
+		}
+
+		run, exists := runsByID[record.RunID]
+		if !exists {
+			run = &historyRun{ID: record.RunID, Timestamp: record.Timestamp}
+			runsByID[record.RunID] = run
+			runs = append(runs, run)
+		}
+		if record.Pool != nil {
+			run.Pool = record.Pool
+			continue
+		}
+		result := record.TestResult
+		run.Results = append(run.Results, &result)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].Timestamp.Before(runs[j].Timestamp)
+	})
+	return runs, nil
+}
+
+// matchesProxy reports whether a result belongs to the proxy given as
+// "address" (any port) or "address:port", with IPv6 addresses optionally
+// bracketed and required to be bracketed with a port
+func matchesProxy(result *exchanges.TestResult, proxy string) bool {
+	if result.ProxyAddress == strings.Trim(proxy, "[]") {
+		return true
+	}
+	return exchanges.ProxyHostPort(result.ProxyAddress, result.Port) == proxy
+}
+
+// resultKey identifies a (proxy, exchange) pair across runs
+func resultKey(result *exchanges.TestResult) string {
+	return exchanges.ProxyHostPort(result.ProxyAddress, result.Port) + "/" + result.Exchange
+}
+
+func handleHistoryCommand(options historyOptions) error {
+	runs, err := loadHistory()
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Println("No test history yet. Run './go-proxy test <exchange>' first")
+			return nil
+		}
+		return fmt.Errorf("loading history: %v", err)
+	}
+	if len(runs) == 0 {
+		fmt.Println("No test history yet. Run './go-proxy test <exchange>' first")
+		return nil
+	}
+
+	switch {
+	case options.degraded:
+		printDegradedProxies(runs, options.latencyIncrease)
+	case options.proxy != "":
+		if len(runs) > options.runs {
+			runs = runs[len(runs)-options.runs:]
+		}
+		printProxyTrend(runs, options.proxy)
+	default:
+		if len(runs) > options.runs {
+			runs = runs[len(runs)-options.runs:]
+		}
+		printRunList(runs)
+	}
+	return nil
+}
+
+// printRunList prints a one-line summary per run
+func printRunList(runs []*historyRun) {
+	fmt.Printf("%-26s %-20s %-6s %-6s %s\n", "Run ID", "Started", "Tests", "Passed", "Success Rate")
+	fmt.Println(strings.Repeat("-", 75)) // Separator line
+	for _, run := range runs {
+		passed := 0
+		for _, result := range run.Results {
+			if result.Success {
+				passed++
+			}
+		}
+		fmt.Printf("%-26s %-20s %-6d %-6d %.1f%%\n",
+			run.ID,
+			run.Timestamp.Local().Format("2006-01-02 15:04:05"),
+			len(run.Results),
+			passed,
+			percent(passed, len(run.Results)))
+	}
+}
+
+// printProxyTrend prints the success rate and latency trend of one proxy per exchange
+func printProxyTrend(runs []*historyRun, proxy string) {
+	type trend struct {
+		passed, total int
+		points        []string
+	}
+	trends := make(map[string]*trend)
+	var exchangeNames []string
+
+	for _, run := range runs {
+		for _, result := range run.Results {
+			if !matchesProxy(result, proxy) {
+				continue
+			}
+			key := fmt.Sprintf("%-12s %s", result.Exchange, exchanges.ProxyHostPort(result.ProxyAddress, result.Port))
+			t, exists := trends[key]
+			if !exists {
+				t = &trend{}
+				trends[key] = t
+				exchangeNames = append(exchangeNames, key)
+			}
+			t.total++
+			if result.Success {
+				t.passed++
+				t.points = append(t.points, result.ResponseTime.Round(time.Millisecond).String())
+			} else {
+				t.points = append(t.points, "✗")
+			}
+		}
+	}
+
+	if len(exchangeNames) == 0 {
+		fmt.Printf("No history for proxy %s in the last %d runs\n", proxy, len(runs))
+		return
+	}
+
+	sort.Strings(exchangeNames)
+	fmt.Printf("History for %s over the last %d runs (oldest first):\n\n", proxy, len(runs))
+	for _, key := range exchangeNames {
+		t := trends[key]
+		fmt.Printf("%s  %d/%d passed (%.1f%%)\n", key, t.passed, t.total, percent(t.passed, t.total))
+		fmt.Printf("  Latency: %s\n", strings.Join(t.points, " → "))
+	}
+}
+
+// printDegradedProxies compares the two most recent runs and lists proxies that
+// stopped passing an exchange or became noticeably slower on it. Only the
+// tests both runs made are compared, since either may have covered part of
+// the pool.
+func printDegradedProxies(runs []*historyRun, latencyIncrease float64) {
+	if len(runs) < 2 {
+		fmt.Println("Need at least two runs in history to detect degradation")
+		return
+	}
+	previous, latest := runs[len(runs)-2], runs[len(runs)-1]
+	diff := diffRuns(previous, latest, latencyIncrease)
+	if diff.Compared == 0 {
+		fmt.Printf("Runs %s and %s have no test in common, so nothing can be compared\n", latest.ID, previous.ID)
+		return
+	}
+
+	fmt.Printf("Comparing run %s with previous run %s on the %d tests both made\n\n", latest.ID, previous.ID, diff.Compared)
+	width := diff.addressWidth()
+	fmt.Printf("%-12s %-*s %-6s %-8s %s\n", "Exchange", width, "Proxy Address", "Port", "Country", "Change")
+	fmt.Println(strings.Repeat("-", 55+width)) // Separator line
+
+	count := 0
+	for _, flip := range diff.Flipped {
+		if !flip.Before {
+			continue
+		}
+		count++
+		fmt.Printf("%-12s %-*s %-6d %-8s %s\n",
+			flip.Exchange,
+			width, flip.ProxyAddress,
+			flip.Port,
+			flip.CountryCode,
+			"now failing: "+flip.Error)
+	}
+	for _, change := range diff.LatencyRegressions {
+		count++
+		fmt.Printf("%-12s %-*s %-6d %-8s latency %s → %s (+%.0f%%)\n",
+			change.Exchange,
+			width, change.ProxyAddress,
+			change.Port,
+			change.CountryCode,
+			change.Before.Round(time.Millisecond),
+			change.After.Round(time.Millisecond),
+			change.Increase)
+	}
+
+	fmt.Printf("\nDegraded: %d\n", count)
+}
+
+// percent returns part as a percentage of total, or 0 when total is zero
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"go-proxy/exchanges"
+)
+
+func TestAppendHistoryKeepsMaxRuns(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxRuns int
+		want    []string
+	}{
+		{"under the limit", 5, []string{"r0", "r1", "r2", "r3"}},
+		{"at the limit", 4, []string{"r0", "r1", "r2", "r3"}},
+		{"over the limit", 2, []string{"r2", "r3"}},
+		{"unlimited", 0, []string{"r0", "r1", "r2", "r3"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestConfig(t).History.MaxRuns = test.maxRuns
+
+			started := time.Date(2026, 1, 14, 10, 0, 0, 0, time.UTC)
+			for i, id := range []string{"r0", "r1", "r2", "r3"} {
+				results := []*exchanges.TestResult{result("Binance", 80, true, 0), result("Bybit", 80, false, 0)}
+				run := &historyRun{ID: id, Timestamp: started.Add(time.Duration(i) * time.Minute), Pool: []string{"192.0.2.1:80"}, Results: results}
+				if err := appendHistory(run); err != nil {
+					t.Fatalf("appendHistory() error: %v", err)
+				}
+			}
+
+			runs, err := loadHistory()
+			if err != nil {
+				t.Fatalf("loadHistory() error: %v", err)
+			}
+			var ids []string
+			for _, run := range runs {
+				ids = append(ids, run.ID)
+				if len(run.Results) != 2 || !slices.Equal(run.Pool, []string{"192.0.2.1:80"}) {
+					t.Errorf("run %s has %d results and pool %v, want 2 and its pool", run.ID, len(run.Results), run.Pool)
+				}
+			}
+			if !slices.Equal(ids, test.want) {
+				t.Errorf("history holds runs %v, want %v", ids, test.want)
+			}
+
+			path, _ := historyPath()
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode := info.Mode().Perm(); mode != 0600 {
+				t.Errorf("history file mode = %v, want 0600", mode)
+			}
+		})
+	}
+}
+
+func TestHistoryLinesOrdersRunsByStart(t *testing.T) {
+	data := []byte(`{"run_id":"late","timestamp":"2026-01-14T11:00:00Z"}
+not json
+{"run_id":"early","timestamp":"2026-01-14T10:00:00Z"}
+{"run_id":"late","timestamp":"2026-01-14T11:00:00Z"}
+`)
+	lines, runIDs := historyLines(data)
+	if len(lines) != 3 {
+		t.Errorf("parsed %d lines, want 3 skipping the broken one", len(lines))
+	}
+	if !slices.Equal(runIDs, []string{"early", "late"}) {
+		t.Errorf("run IDs = %v, want oldest first", runIDs)
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go-proxy/exchanges"
+)
+
+// Upper bounds of the latency histogram buckets; the last bucket is open
+var histogramBuckets = []time.Duration{
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+}
+
+// reportBar is one labelled bar of a chart, with its width as a percentage
+// of the largest bar
+type reportBar struct {
+	Label   string
+	Count   int
+	Percent float64
+}
+
+// reportHistogram is the latency distribution of one exchange
+type reportHistogram struct {
+	Exchange string
+	Passed   int
+	Median   time.Duration
+	Bars     []reportBar
+}
+
+// reportCountry is one row of the country table
+type reportCountry struct {
+	Code        string
+	Flag        string
+	Proxies     int
+	Tests       int
+	Passed      int
+	SuccessRate float64
+	Median      time.Duration
+}
+
+// reportRow is one row of the results table
+type reportRow struct {
+	*exchanges.TestResult
+	Latency time.Duration // zero for failed tests
+}
+
+// htmlReport is the data rendered into the HTML report
+type htmlReport struct {
+	RunID       string
+	StartedAt   time.Time
+	Duration    time.Duration
+	Tests       int
+	Passed      int
+	Failed      int
+	SuccessRate float64
+	Proxies     int
+	Exchanges   []string
+	Median      time.Duration
+
+	Histograms []reportHistogram
+	Failures   []reportBar
+	Countries  []reportCountry
+	Results    []reportRow
+}
+
+// newHTMLReport aggregates a run's results for the HTML report
+func newHTMLReport(runID string, startedAt time.Time, results []*exchanges.TestResult) *htmlReport {
+	report := &htmlReport{
+		RunID:     runID,
+		StartedAt: startedAt,
+		Duration:  time.Since(startedAt).Round(time.Second),
+		Tests:     len(results),
+	}
+
+	proxies := make(map[string]bool)
+	latencies := make(map[string][]time.Duration)
+	failures := make(map[string]int)
+	countries := make(map[string]*reportCountry)
+	countryProxies := make(map[string]map[string]bool)
+	countryLatencies := make(map[string][]time.Duration)
+	var allLatencies []time.Duration
+
+	for _, result := range results {
+		proxy := groupKey(result, "proxy")
+		proxies[proxy] = true
+		if !slices.Contains(report.Exchanges, result.Exchange) {
+			report.Exchanges = append(report.Exchanges, result.Exchange)
+		}
+
+		code := groupKey(result, "country")
+		country, exists := countries[code]
+		if !exists {
+			country = &reportCountry{Code: code, Flag: countryFlag(result.CountryCode)}
+			countries[code] = country
+			countryProxies[code] = make(map[string]bool)
+		}
+		countryProxies[code][proxy] = true
+		country.Tests++
+
+		row := reportRow{TestResult: result}
+		if result.Success {
+			report.Passed++
+			country.Passed++
+			row.Latency = result.ResponseTime
+			latencies[result.Exchange] = append(latencies[result.Exchange], result.ResponseTime)
+			countryLatencies[code] = append(countryLatencies[code], result.ResponseTime)
+			allLatencies = append(allLatencies, result.ResponseTime)
+		} else {
+			kind := result.FailureKind
+			if kind == "" {
+				kind = "unclassified"
+			}
+			failures[kind]++
+		}
+		report.Results = append(report.Results, row)
+	}
+
+	report.Failed = report.Tests - report.Passed
+	report.SuccessRate = percent(report.Passed, report.Tests)
+	report.Proxies = len(proxies)
+	_, _, _, report.Median = calculateResponseTimeStats(allLatencies)
+	slices.Sort(report.Exchanges)
+
+	for _, exchange := range report.Exchanges {
+		report.Histograms = append(report.Histograms, newLatencyHistogram(exchange, latencies[exchange]))
+	}
+
+	// Known kinds first, in their usual order, then anything else
+	kinds := slices.Clone(exchanges.FailureKinds)
+	for kind := range failures {
+		if !slices.Contains(kinds, kind) {
+			kinds = append(kinds, kind)
+		}
+	}
+	maxFailures := 0
+	for _, count := range failures {
+		maxFailures = max(maxFailures, count)
+	}
+	for _, kind := range kinds {
+		if failures[kind] > 0 {
+			report.Failures = append(report.Failures, reportBar{
+				Label:   kind,
+				Count:   failures[kind],
+				Percent: percent(failures[kind], maxFailures),
+			})
+		}
+	}
+
+	for code, country := range countries {
+		country.Proxies = len(countryProxies[code])
+		country.SuccessRate = percent(country.Passed, country.Tests)
+		_, _, _, country.Median = calculateResponseTimeStats(countryLatencies[code])
+		report.Countries = append(report.Countries, *country)
+	}
+	slices.SortFunc(report.Countries, func(a, b reportCountry) int {
+		if a.Proxies != b.Proxies {
+			return b.Proxies - a.Proxies
+		}
+		return strings.Compare(a.Code, b.Code)
+	})
+	return report
+}
+
+// newLatencyHistogram buckets the latencies of one exchange
+func newLatencyHistogram(exchange string, latencies []time.Duration) reportHistogram {
+	histogram := reportHistogram{Exchange: exchange, Passed: len(latencies)}
+	_, _, _, histogram.Median = calculateResponseTimeStats(latencies)
+
+	counts := make([]int, len(histogramBuckets)+1)
+	for _, latency := range latencies {
+		bucket := len(histogramBuckets)
+		for i, bound := range histogramBuckets {
+			if latency < bound {
+				bucket = i
+				break
+			}
+		}
+		counts[bucket]++
+	}
+
+	largest := slices.Max(counts)
+	for i, count := range counts {
+		var label string
+		switch {
+		case i == 0:
+			label = "< " + histogramBuckets[0].String()
+		case i == len(histogramBuckets):
+			label = "≥ " + histogramBuckets[i-1].String()
+		default:
+			label = histogramBuckets[i-1].String() + "–" + histogramBuckets[i].String()
+		}
+		histogram.Bars = append(histogram.Bars, reportBar{Label: label, Count: count, Percent: percent(count, largest)})
+	}
+	return histogram
+}
+
+// countryFlag returns the flag emoji of a two-letter country code
+func countryFlag(code string) string {
+	code = strings.ToUpper(code)
+	if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+		return ""
+	}
+	const regionalIndicatorA = 0x1F1E6
+	return string([]rune{rune(code[0]-'A') + regionalIndicatorA, rune(code[1]-'A') + regionalIndicatorA})
+}
+
+// writeHTMLReport renders the report of a run to a self-contained HTML file
+func writeHTMLReport(path, runID string, startedAt time.Time, results []*exchanges.TestResult) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := htmlReportTemplate.Execute(file, newHTMLReport(runID, startedAt, results)); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"ms": func(d time.Duration) string {
+		return fmt.Sprintf("%.0f", float64(d)/float64(time.Millisecond))
+	},
+	"round": func(d time.Duration) string {
+		return d.Round(time.Millisecond).String()
+	},
+	"pct": func(value float64) string {
+		return fmt.Sprintf("%.1f", value)
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Proxy test report {{.RunID}}</title>
+<style>
+  body { font-family: -apple-system, "Segoe UI", Roboto, sans-serif; margin: 2rem; color: #1f2933; background: #f5f7fa; }
+  h1 { margin-bottom: 0.2rem; }
+  h2 { margin-top: 2.5rem; }
+  .meta { color: #616e7c; }
+  .cards { display: flex; flex-wrap: wrap; gap: 1rem; margin-top: 1.5rem; }
+  .card { background: #fff; border-radius: 8px; padding: 1rem 1.5rem; min-width: 9rem; box-shadow: 0 1px 3px rgba(0,0,0,.1); }
+  .card .value { font-size: 1.8rem; font-weight: 600; }
+  .card .label { color: #616e7c; font-size: 0.85rem; }
+  .good { color: #2f8132; }
+  .bad { color: #ba2525; }
+  .charts { display: flex; flex-wrap: wrap; gap: 1.5rem; }
+  .chart { background: #fff; border-radius: 8px; padding: 1rem 1.5rem; box-shadow: 0 1px 3px rgba(0,0,0,.1); min-width: 22rem; }
+  .bar-row { display: flex; align-items: center; gap: 0.5rem; margin: 0.25rem 0; font-size: 0.85rem; }
+  .bar-label { width: 7rem; text-align: right; color: #52606d; }
+  .bar-track { display: block; flex: 1; background: #e4e7eb; border-radius: 3px; height: 1rem; }
+  .bar { display: block; height: 100%; border-radius: 3px; background: #3e7bfa; }
+  .bar.failure { background: #e66a6a; }
+  .bar-count { width: 3rem; }
+  table { border-collapse: collapse; background: #fff; width: 100%; box-shadow: 0 1px 3px rgba(0,0,0,.1); font-size: 0.9rem; }
+  th, td { padding: 0.4rem 0.75rem; text-align: left; border-bottom: 1px solid #e4e7eb; }
+  th { background: #e4e7eb; }
+  th.sortable { cursor: pointer; user-select: none; }
+  th.sortable::after { content: " ⇅"; color: #9aa5b1; }
+  td.num, th.num { text-align: right; }
+  td.error { color: #ba2525; max-width: 40rem; overflow-wrap: anywhere; }
+</style>
+</head>
+<body>
+<h1>Proxy test report</h1>
+<div class="meta">Run {{.RunID}} · started {{.StartedAt.Format "2006-01-02 15:04:05 MST"}} · took {{.Duration}}</div>
+
+<div class="cards">
+  <div class="card"><div class="value">{{.Tests}}</div><div class="label">Tests</div></div>
+  <div class="card"><div class="value good">{{.Passed}}</div><div class="label">Passed</div></div>
+  <div class="card"><div class="value bad">{{.Failed}}</div><div class="label">Failed</div></div>
+  <div class="card"><div class="value">{{pct .SuccessRate}}%</div><div class="label">Success rate</div></div>
+  <div class="card"><div class="value">{{.Proxies}}</div><div class="label">Proxies</div></div>
+  <div class="card"><div class="value">{{len .Exchanges}}</div><div class="label">Exchanges</div></div>
+  <div class="card"><div class="value">{{if .Passed}}{{round .Median}}{{else}}–{{end}}</div><div class="label">Median latency</div></div>
+</div>
+
+<h2>Latency by exchange</h2>
+<div class="charts">
+{{- range .Histograms}}
+  <div class="chart">
+    <h3>{{.Exchange}}</h3>
+    <div class="meta">{{.Passed}} passed{{if .Passed}} · median {{round .Median}}{{end}}</div>
+    {{- range .Bars}}
+    <div class="bar-row"><span class="bar-label">{{.Label}}</span><span class="bar-track"><span class="bar" style="width: {{pct .Percent}}%"></span></span><span class="bar-count">{{.Count}}</span></div>
+    {{- end}}
+  </div>
+{{- end}}
+</div>
+
+<h2>Failures by kind</h2>
+{{- if .Failures}}
+<div class="chart">
+  {{- range .Failures}}
+  <div class="bar-row"><span class="bar-label">{{.Label}}</span><span class="bar-track"><span class="bar failure" style="width: {{pct .Percent}}%"></span></span><span class="bar-count">{{.Count}}</span></div>
+  {{- end}}
+</div>
+{{- else}}
+<p>No failures.</p>
+{{- end}}
+
+<h2>Countries</h2>
+<table class="sortable">
+  <thead><tr>
+    <th class="sortable">Country</th>
+    <th class="sortable num" data-type="number">Proxies</th>
+    <th class="sortable num" data-type="number">Tests</th>
+    <th class="sortable num" data-type="number">Passed</th>
+    <th class="sortable num" data-type="number">Success rate</th>
+    <th class="sortable num" data-type="number">Median latency</th>
+  </tr></thead>
+  <tbody>
+  {{- range .Countries}}
+    <tr>
+      <td>{{.Flag}} {{.Code}}</td>
+      <td class="num">{{.Proxies}}</td>
+      <td class="num">{{.Tests}}</td>
+      <td class="num">{{.Passed}}</td>
+      <td class="num" data-value="{{pct .SuccessRate}}">{{pct .SuccessRate}}%</td>
+      <td class="num" data-value="{{if .Passed}}{{ms .Median}}{{else}}Infinity{{end}}">{{if .Passed}}{{round .Median}}{{else}}–{{end}}</td>
+    </tr>
+  {{- end}}
+  </tbody>
+</table>
+
+<h2>Results</h2>
+<table class="sortable">
+  <thead><tr>
+    <th class="sortable">Exchange</th>
+    <th class="sortable" data-type="address">Proxy</th>
+    <th class="sortable num" data-type="number">Port</th>
+    <th class="sortable">Country</th>
+    <th class="sortable">Result</th>
+    <th class="sortable num" data-type="number">Latency</th>
+    <th class="sortable">Failure kind</th>
+    <th>Details</th>
+  </tr></thead>
+  <tbody>
+  {{- range .Results}}
+    <tr>
+      <td>{{.Exchange}}</td>
+      <td>{{.ProxyAddress}}</td>
+      <td class="num">{{.Port}}</td>
+      <td>{{.CountryCode}}</td>
+      {{- if .Success}}
+      <td class="good">✓ passed</td>
+      <td class="num" data-value="{{ms .Latency}}">{{round .Latency}}</td>
+      <td></td>
+      <td>{{.Data}}</td>
+      {{- else}}
+      <td class="bad">✗ failed</td>
+      <td class="num" data-value="Infinity">–</td>
+      <td>{{.FailureKind}}</td>
+      <td class="error">{{.Error}}</td>
+      {{- end}}
+    </tr>
+  {{- end}}
+  </tbody>
+</table>
+
+<script>
+// Sort a table by the clicked column; clicking again reverses the order
+document.querySelectorAll("table.sortable").forEach(function (table) {
+  table.querySelectorAll("th.sortable").forEach(function (header, column) {
+    var ascending = true;
+    header.addEventListener("click", function () {
+      var type = header.dataset.type;
+      var key = function (row) {
+        var cell = row.children[column];
+        var value = cell.dataset.value !== undefined ? cell.dataset.value : cell.textContent.trim();
+        if (type === "number") return parseFloat(value);
+        if (type === "address") return value.split(".").map(function (part) { return part.padStart(3, "0"); }).join(".");
+        return value.toLowerCase();
+      };
+      var body = table.tBodies[0];
+      var rows = Array.prototype.slice.call(body.rows);
+      rows.sort(function (a, b) {
+        var x = key(a), y = key(b);
+        var order = x < y ? -1 : x > y ? 1 : 0;
+        return ascending ? order : -order;
+      });
+      rows.forEach(function (row) { body.appendChild(row); });
+      ascending = !ascending;
+    });
+  });
+});
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"go-proxy/exchanges"
+)
+
+// junitTestSuites is the root element of a JUnit XML report
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     float64          `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite holds the tests of one exchange
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Time      float64         `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+// junitTestCase is one (exchange, proxy) test
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Output    string        `xml:"system-out,omitempty"`
+}
+
+// junitFailure carries the classified error of a failed test
+type junitFailure struct {
+	Type    string `xml:"type,attr"`
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// newJUnitReport builds a JUnit report with one test suite per exchange
+// and one test case per proxy
+func newJUnitReport(startedAt time.Time, results []*exchanges.TestResult) *junitTestSuites {
+	sorted := slices.Clone(results)
+	sortResults(sorted, "exchange")
+
+	report := &junitTestSuites{
+		Name:  programName,
+		Tests: len(results),
+		Time:  time.Since(startedAt).Seconds(),
+	}
+	keys, groups := groupResults(sorted, "exchange")
+	for _, exchange := range keys {
+		suite := junitTestSuite{
+			Name:      exchange,
+			Tests:     len(groups[exchange]),
+			Timestamp: startedAt.UTC().Format("2006-01-02T15:04:05"),
+		}
+		for _, result := range groups[exchange] {
+			name := groupKey(result, "proxy")
+			if result.CountryCode != "" {
+				name += " (" + result.CountryCode + ")"
+			}
+			testCase := junitTestCase{
+				Name:      name,
+				Classname: exchange,
+				Time:      result.ResponseTime.Seconds(),
+			}
+			if result.Success {
+				testCase.Output = result.Data
+			} else {
+				kind := result.FailureKind
+				if kind == "" {
+					kind = "unclassified"
+				}
+				testCase.Failure = &junitFailure{Type: kind, Message: result.Error, Text: result.Error}
+				suite.Failures++
+			}
+			suite.Time += testCase.Time
+			suite.Cases = append(suite.Cases, testCase)
+		}
+		report.Failures += suite.Failures
+		report.Suites = append(report.Suites, suite)
+	}
+	return report
+}
+
+// writeJUnit writes the results of a run as JUnit XML
+func writeJUnit(w io.Writer, startedAt time.Time, results []*exchanges.TestResult) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(newJUnitReport(startedAt, results)); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// writeJUnitFile writes the results of a run as a JUnit XML file
+func writeJUnitFile(path string, startedAt time.Time, results []*exchanges.TestResult) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeJUnit(file, startedAt, results); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"go-proxy/exchanges"
+)
+
+func TestWriteJUnit(t *testing.T) {
+	ms := time.Millisecond
+	refused := result("Bybit", 80, false, 0)
+	refused.Error = `dial tcp: <refused> & "closed"`
+	refused.FailureKind = "connection_refused"
+	unclassified := result("Bybit", 81, false, 0)
+	unclassified.Error = "something odd"
+	ipv6 := &exchanges.TestResult{Exchange: "Binance", ProxyAddress: "2001:db8::1", Port: 80, CountryCode: "DE", Success: true, ResponseTime: 250 * ms, Data: "egress 2001:db8::1"}
+	results := []*exchanges.TestResult{refused, result("Binance", 80, true, 100*ms), unclassified, ipv6}
+
+	var buffer bytes.Buffer
+	if err := writeJUnit(&buffer, time.Now(), results); err != nil {
+		t.Fatalf("writeJUnit() error: %v", err)
+	}
+	if !strings.HasPrefix(buffer.String(), xml.Header) {
+		t.Errorf("report does not start with the XML header:\n%s", buffer.String())
+	}
+
+	var report junitTestSuites
+	if err := xml.Unmarshal(buffer.Bytes(), &report); err != nil {
+		t.Fatalf("report is not valid XML: %v\n%s", err, buffer.String())
+	}
+	if report.Name != programName || report.Tests != 4 || report.Failures != 2 {
+		t.Errorf("testsuites name=%q tests=%d failures=%d, want %q, 4 and 2", report.Name, report.Tests, report.Failures, programName)
+	}
+	if len(report.Suites) != 2 || report.Suites[0].Name != "Binance" || report.Suites[1].Name != "Bybit" {
+		t.Fatalf("suites = %+v, want Binance then Bybit", report.Suites)
+	}
+
+	binance := report.Suites[0]
+	if binance.Tests != 2 || binance.Failures != 0 || len(binance.Cases) != 2 {
+		t.Errorf("Binance suite tests=%d failures=%d cases=%d, want 2, 0 and 2", binance.Tests, binance.Failures, len(binance.Cases))
+	}
+	if binance.Time < 0.349 || binance.Time > 0.351 {
+		t.Errorf("Binance suite time = %g, want the sum of its tests, 0.35", binance.Time)
+	}
+	outputs := make(map[string]string)
+	for _, testCase := range binance.Cases {
+		if testCase.Classname != "Binance" || testCase.Failure != nil {
+			t.Errorf("case %+v, want a passed Binance test", testCase)
+		}
+		outputs[testCase.Name] = testCase.Output
+	}
+	if output, ok := outputs["[2001:db8::1]:80 (DE)"]; !ok || output != "egress 2001:db8::1" {
+		t.Errorf("Binance cases %v, want [2001:db8::1]:80 (DE) with the result data as output", outputs)
+	}
+
+	bybit := report.Suites[1]
+	if bybit.Failures != 2 || len(bybit.Cases) != 2 {
+		t.Fatalf("Bybit suite failures=%d cases=%d, want 2 and 2", bybit.Failures, len(bybit.Cases))
+	}
+	failures := make(map[string]*junitFailure)
+	for _, testCase := range bybit.Cases {
+		failures[testCase.Name] = testCase.Failure
+	}
+	if failure := failures["192.0.2.1:80"]; failure == nil || failure.Type != "connection_refused" || failure.Message != refused.Error || failure.Text != refused.Error {
+		t.Errorf("failure of 192.0.2.1:80 = %+v, want the classified error kept verbatim", failure)
+	}
+	if failure := failures["192.0.2.1:81"]; failure == nil || failure.Type != "unclassified" {
+		t.Errorf("failure of 192.0.2.1:81 = %+v, want type unclassified", failure)
+	}
+}
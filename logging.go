@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Accepted values of --log-level and --log-format
+var (
+	logLevels  = []string{"debug", "info", "warn", "error"}
+	logFormats = []string{"text", "json"}
+)
+
+// logger writes diagnostics to stderr, keeping stdout for data. main
+// replaces it once the logging flags are known.
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+// logLevel is the minimum level of logger, for handlers that stand in for it
+var logLevel slog.LevelVar
+
+// newLogger creates the stderr logger for a level and format, falling back
+// to PROXY_LOG_LEVEL and PROXY_LOG_FORMAT, then to info and text. It also
+// sets logLevel.
+func newLogger(level, format string) (*slog.Logger, error) {
+	if level == "" {
+		level = os.Getenv("PROXY_LOG_LEVEL")
+	}
+	if format == "" {
+		format = os.Getenv("PROXY_LOG_FORMAT")
+	}
+
+	logLevel.Set(slog.LevelInfo)
+	options := &slog.HandlerOptions{Level: &logLevel}
+	if level != "" {
+		level = strings.ToLower(level)
+		if !slices.Contains(logLevels, level) {
+			return nil, fmt.Errorf("invalid log level %q: must be one of %s", level, strings.Join(logLevels, ", "))
+		}
+		var parsed slog.Level
+		parsed.UnmarshalText([]byte(level))
+		logLevel.Set(parsed)
+	}
+
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, options)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q: must be one of %s", format, strings.Join(logFormats, ", "))
+}
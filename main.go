@@ -1,55 +1,75 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"net/http"
+	"io/fs"
 	"os"
 	"sort"
-	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"go-proxy/exchanges"
-
-	"github.com/joho/godotenv"
+	"go-proxy/proxypool"
 )
 
-// Define the structure for the API response
-type Proxy struct {
-	ProxyAddress string `json:"proxy_address"`
-	Port         int    `json:"port"`
-	CountryCode  string `json:"country_code"`
-}
+// minAddressWidth is the width of the proxy address column in tables, which
+// fits any IPv4 address; longer addresses such as IPv6 ones widen the column
+const minAddressWidth = 15
 
-type ApiResponse struct {
-	Next    string  `json:"next"`
-	Results []Proxy `json:"results"`
+// Helper function to size the proxy address column for the given addresses
+func addressWidth(addresses ...string) int {
+	width := minAddressWidth
+	for _, address := range addresses {
+		width = max(width, len(address))
+	}
+	return width
 }
 
-// Cache file path
-const cacheFile = "proxy_cache.json"
+// Helper function to size the proxy address column for results
+func resultsAddressWidth(results []*exchanges.TestResult) int {
+	width := minAddressWidth
+	for _, result := range results {
+		width = max(width, len(result.ProxyAddress))
+	}
+	return width
+}
 
 // Helper function to format table with proper column alignment
-func formatTableRow(exchange, proxyAddr string, port int, country, responseTime, data string) string {
-	return fmt.Sprintf("%-12s %-15s %-6d %-8s %-15s %s", exchange, proxyAddr, port, country, responseTime, data)
+func formatTableRow(addrWidth int, exchange, proxyAddr string, port int, country, responseTime, warmTime, data string) string {
+	return fmt.Sprintf("%-12s %-*s %-6d %-8s %-15s %-15s %s", exchange, addrWidth, proxyAddr, port, country, responseTime, warmTime, data)
 }
 
-// Helper function to calculate response time statistics
-func calculateResponseTimeStats(results []*exchanges.TestResult) (min, max, avg, median time.Duration) {
-	if len(results) == 0 {
-		return 0, 0, 0, 0
+// Helper function to format a warm latency, which is zero when it could not be measured
+func formatWarmTime(warm time.Duration) string {
+	if warm == 0 {
+		return "-"
 	}
+	return warm.String()
+}
 
-	// Extract response times
-	var times []time.Duration
+// Helper function to collect cold and warm response times from results
+func collectResponseTimes(results []*exchanges.TestResult) (cold, warm []time.Duration) {
 	for _, result := range results {
-		times = append(times, result.ResponseTime)
+		cold = append(cold, result.ResponseTime)
+		if result.WarmResponseTime > 0 {
+			warm = append(warm, result.WarmResponseTime)
+		}
+	}
+	return cold, warm
+}
+
+// Helper function to calculate response time statistics
+func calculateResponseTimeStats(times []time.Duration) (min, max, avg, median time.Duration) {
+	if len(times) == 0 {
+		return 0, 0, 0, 0
 	}
 
+	// Sort a copy so the caller's slice keeps its order
+	times = append([]time.Duration(nil), times...)
+
 	// Calculate min and max
 	min = times[0]
 	max = times[0]
@@ -80,353 +100,258 @@ func calculateResponseTimeStats(results []*exchanges.TestResult) (min, max, avg,
 	return min, max, avg, median
 }
 
-func handleListCommand() {
-	// Get the proxy list URL from environment variable
-	proxyListURL := os.Getenv("PROXY_LIST")
+func handleListCommand() error {
+	// Get the proxy list URL from the configuration
+	proxyListURL := appConfig.Provider.ListURL
 	if proxyListURL == "" {
-		fmt.Println("PROXY_LIST environment variable is not set")
-		return
+		return configErrorf("no proxy list URL configured; set PROXY_LIST or provider.list_url")
 	}
 
 	// Download the proxy list
-	fmt.Printf("Downloading proxy list from: %s\n", proxyListURL)
-	proxies, err := downloadProxyList(proxyListURL)
+	logger.Info("downloading proxy list", "url", proxyListURL)
+	proxies, err := proxypool.DownloadList(context.Background(), nil, proxyListURL)
 	if err != nil {
-		fmt.Printf("Error downloading proxy list: %v\n", err)
-		return
+		return networkErrorf("%v", err)
 	}
 
 	// Print out the proxies
-	fmt.Printf("Found %d proxies:\n", len(proxies))
+	logger.Info("downloaded proxy list", "proxies", len(proxies))
 	for i, proxy := range proxies {
 		fmt.Printf("%d. %s\n", i+1, strings.TrimSpace(proxy))
 	}
+	return nil
 }
 
-func handleApiCommand() {
-	// Check for --refresh flag
-	refresh := false
-	if len(os.Args) > 2 && os.Args[2] == "--refresh" {
-		refresh = true
-	}
-
+func handleApiCommand(refresh bool) error {
 	// If not refreshing, try to load from cache first
 	if !refresh {
-		if cachedProxies, err := loadFromCache(); err == nil {
-			fmt.Println("Proxy Address\tPort\tCountry")
-			for _, proxy := range cachedProxies {
-				fmt.Printf("%s\t%d\t%s\n", proxy.ProxyAddress, proxy.Port, proxy.CountryCode)
-			}
-			fmt.Fprintf(os.Stderr, "\nTotal proxies loaded from cache: %d\n", len(cachedProxies))
-			return
+		// A pool created empty has a cache, but nothing in it yet
+		if cachedProxies, err := loadFromCache(); err == nil && len(cachedProxies) > 0 {
+			printProxies(cachedProxies)
+			logger.Info("loaded proxies from cache", "proxies", len(cachedProxies), "file", appConfig.Provider.CacheFile)
+			return nil
 		}
 	}
 
-	// Get the API key from environment variable
-	apiKey := os.Getenv("PROXY_API")
-	if apiKey == "" {
-		fmt.Println("PROXY_API environment variable is not set")
-		return
+	if err := appConfig.Provider.checkSources(); err != nil {
+		return err
 	}
-
-	baseURL := "https://proxy.webshare.io/api/v2/proxy/list/?mode=direct&page_size=100"
-	url := baseURL
-
-	client := &http.Client{}
-	fmt.Println("Proxy Address\tPort\tCountry")
-
-	var allProxies []Proxy
-	totalProxies := 0
-	pageCount := 0
-
-	for url != "" {
-		pageCount++
-		fmt.Fprintf(os.Stderr, "Fetching page %d...\n", pageCount)
-
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			log.Fatalf("Error creating request: %v", err)
-		}
-		req.Header.Set("Authorization", "Token "+apiKey)
-
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Fatalf("Error making request: %v", err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			log.Fatalf("API request failed with status: %s", resp.Status)
-		}
-
-		var apiResp ApiResponse
-		decoder := json.NewDecoder(resp.Body)
-		if err := decoder.Decode(&apiResp); err != nil {
-			log.Fatalf("Error decoding JSON: %v", err)
-		}
-
-		for _, proxy := range apiResp.Results {
-			fmt.Printf("%s\t%d\t%s\n", proxy.ProxyAddress, proxy.Port, proxy.CountryCode)
-			allProxies = append(allProxies, proxy)
-			totalProxies++
-		}
-
-		url = apiResp.Next // Move to next page (or exit loop if empty)
+	allProxies, err := fetchProxies(context.Background())
+	if err != nil {
+		return networkErrorf("fetching proxies: %v", err)
 	}
 
+	printProxies(allProxies)
+	totalProxies := len(allProxies)
+
 	// Save to cache
 	if err := saveToCache(allProxies); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: Failed to save to cache: %v\n", err)
+		logger.Warn("failed to save proxy cache", "file", appConfig.Provider.CacheFile, "error", err)
 	}
 
-	fmt.Fprintf(os.Stderr, "\nTotal proxies fetched: %d (across %d pages)\n", totalProxies, pageCount)
+	logger.Info("saved proxies", "proxies", totalProxies, "file", appConfig.Provider.CacheFile)
+	return nil
 }
 
-func loadFromCache() ([]Proxy, error) {
-	data, err := os.ReadFile(cacheFile)
-	if err != nil {
-		return nil, err
+// printProxies prints proxies as tab-separated lines, with the providers
+// that listed each one
+func printProxies(proxies []proxypool.Proxy) {
+	fmt.Println("Proxy Address\tPort\tCountry\tSources")
+	for _, proxy := range proxies {
+		fmt.Printf("%s\t%d\t%s\t%s\n", proxy.ProxyAddress, proxy.Port, proxy.CountryCode, strings.Join(proxy.Sources, ","))
 	}
+}
 
-	var proxies []Proxy
-	if err := json.Unmarshal(data, &proxies); err != nil {
-		return nil, err
+func loadFromCache() ([]proxypool.Proxy, error) {
+	proxies, err := proxypool.LoadCache(appConfig.Provider.CacheFile)
+	if errors.Is(err, fs.ErrNotExist) && appConfig.pool == defaultPoolName && appConfig.Provider.CacheFile != cacheFileName {
+		// Older versions kept the cache in the working directory
+		if _, statErr := os.Stat(cacheFileName); statErr == nil {
+			logger.Warn("found a proxy cache in the working directory, which is no longer used by default; move it or set provider.cache_file",
+				"file", cacheFileName, "cache_file", appConfig.Provider.CacheFile)
+		}
 	}
+	return proxies, err
+}
 
-	return proxies, nil
+func saveToCache(proxies []proxypool.Proxy) error {
+	return proxypool.SaveCache(appConfig.Provider.CacheFile, proxies)
 }
 
-func saveToCache(proxies []Proxy) error {
-	data, err := json.MarshalIndent(proxies, "", "  ")
-	if err != nil {
-		return err
+// resolveTesters returns the testers for the given exchange names, where a
+// single "*" selects every registered exchange. Unknown names are returned
+// as invalid and no testers are returned in that case.
+func resolveTesters(registry *exchanges.Registry, exchangeNames []string) ([]exchanges.ExchangeTester, []string) {
+	availableExchanges := registry.List()
+	if len(exchangeNames) == 1 && exchangeNames[0] == "*" {
+		exchangeNames = availableExchanges
 	}
 
-	return os.WriteFile(cacheFile, data, 0644)
-}
-
-func downloadProxyList(url string) ([]string, error) {
-	// Make HTTP request to download the proxy list
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to download proxy list: %v", err)
+	var testers []exchanges.ExchangeTester
+	var invalids []string
+	for _, name := range exchangeNames {
+		tester, err := registry.Get(name)
+		if err != nil {
+			invalids = append(invalids, name)
+			continue
+		}
+		testers = append(testers, tester)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP request failed with status: %d", resp.StatusCode)
+	if len(invalids) > 0 {
+		return nil, invalids
 	}
+	return testers, nil
+}
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+func handleTestCommand(exchangeNames []string, options testOptions, filter *proxyFilter) error {
+	// JUnit output owns stdout, so the human-readable output goes to stderr
+	out := os.Stdout
+	if options.output == "junit" {
+		out = os.Stderr
 	}
 
-	// Split the content by lines and filter out empty lines
-	lines := strings.Split(string(body), "\n")
-	var proxies []string
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line != "" {
-			proxies = append(proxies, line)
+	// The dashboard takes over the logger before the testers are created
+	var dash *dashboard
+	if options.tui {
+		if isTerminal(out) && isTerminal(os.Stdin) {
+			dash = newDashboard(out)
+			defer dash.close()
+		} else {
+			logger.Info("not a terminal, using plain output instead of --tui")
 		}
 	}
 
-	return proxies, nil
-}
+	if options.proxyFamily != 0 {
+		appConfig.Test.ProxyFamily = options.proxyFamily
+	}
+	registry := newRegistry()
+	defer registry.CloseIdleConnections()
 
-func handleTestCommand() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: ./go-proxy test <exchange> [options]")
-		fmt.Println("Available exchanges:")
-		registry := exchanges.NewRegistry()
-		for _, name := range registry.List() {
-			fmt.Printf("  %s\n", name)
-		}
-		fmt.Println("Use '*' to test all available exchanges")
-		fmt.Println("Options:")
-		fmt.Println("  --limit <number> - Limit the number of proxies to test (e.g., --limit 10)")
-		return
-	}
-
-	// Parse command line flags
-	limit := -1 // -1 means no limit
-	for i := 3; i < len(os.Args); i++ {
-		if os.Args[i] == "--limit" && i+1 < len(os.Args) {
-			if val, err := strconv.Atoi(os.Args[i+1]); err == nil && val > 0 {
-				limit = val
-			} else {
-				fmt.Printf("Error: Invalid limit value '%s'. Must be a positive integer.\n", os.Args[i+1])
-				return
-			}
-			// Remove the --limit and its value from os.Args to avoid confusion
-			os.Args = append(os.Args[:i], os.Args[i+2:]...)
-			break
-		}
+	testers, invalids := resolveTesters(registry, exchangeNames)
+	if len(invalids) > 0 {
+		return usageErrorf("not valid exchange names: %s (available: %s; to test all exchanges, quote the wildcard: \"*\")",
+			strings.Join(invalids, ", "), strings.Join(registry.List(), ", "))
 	}
 
 	proxies, err := loadFromCache()
 	if err != nil {
-		fmt.Printf("Error loading proxies from cache: %v\n", err)
-		fmt.Println("Please run './go-proxy api' first to fetch proxies")
-		return
+		return configErrorf("loading proxies from cache: %v; run './go-proxy api' first to fetch proxies", err)
+	}
+	if len(proxies) == 0 {
+		return configErrorf("no proxies found in cache; run './go-proxy api' first to fetch proxies")
+	}
+
+	// Narrow the pool down with the selection flags
+	proxies = dialableProxies(appConfig.Proxy.withCredentials(proxies), appConfig.Test.ProxyFamily)
+	pool := poolMembers(proxies)
+	pooled := len(proxies)
+	proxies, steps, err := filter.apply(proxies)
+	if err != nil {
+		return configErrorf("selecting proxies: %v", err)
+	}
+	for _, step := range steps {
+		logger.Info("selected proxies", "step", step)
 	}
 	if len(proxies) == 0 {
-		fmt.Println("No proxies found in cache. Please run './go-proxy api' first to fetch proxies")
-		return
+		logger.Warn("no proxies left to test after filtering")
+		return nil
 	}
 
 	// Apply limit if specified
-	if limit > 0 && limit < len(proxies) {
-		proxies = proxies[:limit]
-		fmt.Printf("Limited to first %d proxies from cache\n", limit)
+	if options.limit > 0 && options.limit < len(proxies) {
+		proxies = proxies[:options.limit]
+		logger.Info("limited to the first proxies from the cache", "limit", options.limit)
 	}
 
-	fmt.Printf("Testing %d proxies...\n", len(proxies))
+	logger.Info("testing proxies", "proxies", len(proxies), "exchanges", len(testers))
 
-	// Support multiple exchange names (e.g., if shell expands *)
-	var exchangeNames []string
-	if len(os.Args) > 3 {
-		exchangeNames = os.Args[2:]
-	} else {
-		exchangeNames = []string{os.Args[2]}
-	}
+	// Apply concurrency caps and per-exchange rate limits from the configuration
+	limits := newFanOutLimits(appConfig.Test, testers)
 
-	registry := exchanges.NewRegistry()
-	availableExchanges := registry.List()
-	validExchange := func(name string) bool {
-		for _, ex := range availableExchanges {
-			if ex == name {
-				return true
-			}
+	var gate *dispatchGate
+	if dash != nil {
+		gate = newDispatchGate()
+		var names []string
+		for _, tester := range testers {
+			names = append(names, tester.GetName())
+		}
+		if err := dash.start(gate, names, len(proxies)); err != nil {
+			dash.close()
+			dash = nil
+			logger.Warn("cannot show the dashboard, using plain output", "error", err)
 		}
-		return false
 	}
 
-	var testers []exchanges.ExchangeTester
-	if len(exchangeNames) == 1 && exchangeNames[0] == "*" {
-		for _, name := range availableExchanges {
-			tester, err := registry.Get(name)
-			if err != nil {
-				fmt.Printf("Warning: Could not get tester for %s: %v\n", name, err)
-				continue
-			}
-			testers = append(testers, tester)
-		}
-	} else {
-		invalids := []string{}
-		for _, name := range exchangeNames {
-			if !validExchange(name) {
-				invalids = append(invalids, name)
-			}
-		}
-		if len(invalids) > 0 {
-			fmt.Printf("Error: the following are not valid exchange names: %s\n", strings.Join(invalids, ", "))
-			fmt.Println("Available exchanges:")
-			for _, name := range availableExchanges {
-				fmt.Printf("  %s\n", name)
-			}
-			fmt.Println("\nNote: If you meant to test all exchanges, use quotes: ./go-proxy test \"*\"")
+	startedAt := time.Now()
+	results := runTests(proxies, testers, limits, gate, logger, func(proxy proxypool.Proxy, result *exchanges.TestResult, completed, total int) {
+		if dash != nil {
+			dash.record(result)
 			return
 		}
-		for _, name := range exchangeNames {
-			tester, err := registry.Get(name)
-			if err != nil {
-				fmt.Printf("Warning: Could not get tester for %s: %v\n", name, err)
-				continue
-			}
-			testers = append(testers, tester)
+
+		// Log each test result as it completes
+		progress := fmt.Sprintf("%d/%d", completed, total)
+		if result.Success {
+			logger.Info("test passed", "progress", progress, "exchange", result.Exchange, "proxy", proxy.Key(),
+				"country", result.CountryCode, "latency", result.ResponseTime,
+				"warm_latency", formatWarmTime(result.WarmResponseTime), "data", result.Data)
+		} else {
+			logger.Info("test failed", "progress", progress, "exchange", result.Exchange, "proxy", proxy.Key(),
+				"country", result.CountryCode, "failure_kind", result.FailureKind, "error", result.Error)
 		}
+	})
+	if dash != nil {
+		dash.close()
+	}
+	if gate.isAborted() {
+		logger.Warn("test run aborted", "tests", len(results), "skipped", len(proxies)*len(testers)-len(results))
+	} else {
+		logger.Info("all tests completed", "tests", len(results), "duration", time.Since(startedAt).Round(time.Millisecond))
+	}
+
+	// Append the results to the history store
+	runID := newRunID(startedAt)
+	run := &historyRun{ID: runID, Timestamp: startedAt, Pool: pool, Results: results}
+	if err := appendHistory(run); err != nil {
+		logger.Warn("failed to save test history", "error", err)
+	} else {
+		logger.Info("results saved to history", "run", runID)
 	}
 
-	// Get concurrency limit from env or default to 10
-	concurrency := 10
-	if val := os.Getenv("PROXY_TEST_CONCURRENCY"); val != "" {
-		if n, err := strconv.Atoi(val); err == nil && n > 0 {
-			concurrency = n
+	checkAlerts(context.Background(), newAlertManager(appConfig.Notify), results, len(proxies), pooled, gate.isAborted())
+
+	// Export the run so it can be diffed later
+	if options.exportFile != "" {
+		if err := writeRunExport(options.exportFile, run); err != nil {
+			logger.Warn("failed to export results", "file", options.exportFile, "error", err)
+		} else {
+			logger.Info("results exported", "file", options.exportFile)
 		}
 	}
 
-	var wg sync.WaitGroup
-	results := make(chan *exchanges.TestResult, len(proxies)*len(testers))
-	semaphore := make(chan struct{}, concurrency)
-	totalTests := len(proxies) * len(testers)
-
-	// Progress tracking
-	var progressMutex sync.Mutex
-	completedTests := 0
-
-	for _, tester := range testers {
-		exchangeName := tester.GetName()
-		for _, proxy := range proxies {
-			wg.Add(1)
-			go func(tester exchanges.ExchangeTester, proxy Proxy, exchangeName string) {
-				defer wg.Done()
-				semaphore <- struct{}{}
-				defer func() { <-semaphore }()
-				// Optionally: Add retry logic here (simple 1 retry for transient errors)
-				var result *exchanges.TestResult
-				var err error
-				for attempt := 1; attempt <= 2; attempt++ {
-					result, err = tester.TestProxy(proxy.ProxyAddress, proxy.Port)
-					if err == nil && result.Success {
-						break
-					}
-					time.Sleep(500 * time.Millisecond)
-				}
-				if result == nil {
-					result = &exchanges.TestResult{
-						ProxyAddress: proxy.ProxyAddress,
-						Port:         proxy.Port,
-						CountryCode:  proxy.CountryCode,
-						Success:      false,
-						Error:        fmt.Sprintf("Test error: %v", err),
-						ResponseTime: 0,
-					}
-				}
-				result.Exchange = exchangeName
-				result.CountryCode = proxy.CountryCode
-				results <- result
-
-				// Update progress and print result immediately
-				progressMutex.Lock()
-				completedTests++
-				currentProgress := completedTests
-				progressMutex.Unlock()
-
-				// Print each test result as it completes
-				if result.Success {
-					fmt.Printf("[%3d/%3d] ✅ %-12s - %-15s:%-5d (%-2s) - %-12s - %s\n",
-						currentProgress, totalTests,
-						result.Exchange,
-						result.ProxyAddress,
-						result.Port,
-						result.CountryCode,
-						result.ResponseTime.String(),
-						result.Data)
-				} else {
-					fmt.Printf("[%3d/%3d] ❌ %-12s - %-15s:%-5d (%-2s) - %s\n",
-						currentProgress, totalTests,
-						result.Exchange,
-						result.ProxyAddress,
-						result.Port,
-						result.CountryCode,
-						result.Error)
-				}
-			}(tester, proxy, exchangeName)
+	// Write the shareable reports before the results are reordered
+	if file, set := options.reports["html"]; set {
+		if err := writeHTMLReport(file, runID, startedAt, results); err != nil {
+			logger.Warn("failed to write HTML report", "file", file, "error", err)
+		} else {
+			logger.Info("HTML report written", "file", file)
+		}
+	}
+	if file, set := options.reports["junit"]; set {
+		if err := writeJUnitFile(file, startedAt, results); err != nil {
+			logger.Warn("failed to write JUnit report", "file", file, "error", err)
+		} else {
+			logger.Info("JUnit report written", "file", file)
 		}
 	}
 
-	go func() {
-		wg.Wait()
-		close(results)
-		fmt.Printf("\n=== All tests completed ===\n")
-	}()
+	// Order the report as requested; completion order otherwise
+	sortResults(results, options.sortBy)
 
 	var successfulTests []*exchanges.TestResult
 	var failedTests []*exchanges.TestResult
-	for result := range results {
+	for _, result := range results {
 		if result.Success {
 			successfulTests = append(successfulTests, result)
 		} else {
@@ -434,85 +359,130 @@ func handleTestCommand() {
 		}
 	}
 
-	fmt.Printf("\n=== Test Results ===\n")
-	fmt.Printf("Successful tests: %d\n", len(successfulTests))
-	fmt.Printf("Failed tests: %d\n", len(failedTests))
-	fmt.Printf("Total tests: %d\n", len(successfulTests)+len(failedTests))
+	fmt.Fprintf(out, "\n=== Test Results ===\n")
+	fmt.Fprintf(out, "Successful tests: %d\n", len(successfulTests))
+	fmt.Fprintf(out, "Failed tests: %d\n", len(failedTests))
+	fmt.Fprintf(out, "Total tests: %d\n", len(successfulTests)+len(failedTests))
+
+	if len(results) > 0 {
+		fmt.Fprintf(out, "\n=== Results by Address Family ===\n")
+		printFamilyStats(out, results)
+	}
+
+	if options.matrix {
+		fmt.Fprintf(out, "\n=== Results by Proxy ===\n")
+		printGrouped(out, results, options.groupBy, func(out io.Writer, group []*exchanges.TestResult) {
+			printResultMatrix(out, group, options.sortBy)
+		})
+	}
 
 	if len(successfulTests) > 0 {
-		fmt.Printf("\n=== Successful Tests ===\n")
-		fmt.Printf("%-12s %-15s %-6s %-8s %-15s %s\n", "Exchange", "Proxy Address", "Port", "Country", "Response Time", "Data")
-		fmt.Println(strings.Repeat("-", 90)) // Separator line
-		for _, result := range successfulTests {
-			fmt.Println(formatTableRow(
-				result.Exchange,
-				result.ProxyAddress,
-				result.Port,
-				result.CountryCode,
-				result.ResponseTime.String(),
-				result.Data,
-			))
+		if !options.matrix {
+			fmt.Fprintf(out, "\n=== Successful Tests ===\n")
+			printGrouped(out, successfulTests, options.groupBy, printSuccessTable)
 		}
 
-		// Calculate and display response time statistics
-		min, max, avg, median := calculateResponseTimeStats(successfulTests)
-		fmt.Printf("\nResponse Time Statistics:\n")
-		fmt.Printf("Min: %s\n", min.String())
-		fmt.Printf("Max: %s\n", max.String())
-		fmt.Printf("Avg: %s\n", avg.String())
-		fmt.Printf("Median: %s\n", median.String())
-	}
-
-	if len(failedTests) > 0 {
-		fmt.Printf("\n=== Failed Tests ===\n")
-		fmt.Printf("%-12s %-15s %-6s %-8s %s\n", "Exchange", "Proxy Address", "Port", "Country", "Error")
-		fmt.Println(strings.Repeat("-", 70)) // Separator line
-		for _, result := range failedTests {
-			fmt.Printf("%-12s %-15s %-6d %-8s %s\n",
-				result.Exchange,
-				result.ProxyAddress,
-				result.Port,
-				result.CountryCode,
-				result.Error)
+		// Calculate and display response time statistics, cold and warm separately
+		coldTimes, warmTimes := collectResponseTimes(successfulTests)
+		min, max, avg, median := calculateResponseTimeStats(coldTimes)
+		fmt.Fprintf(out, "\nCold Response Time Statistics (new connection):\n")
+		fmt.Fprintf(out, "Min: %s\n", min.String())
+		fmt.Fprintf(out, "Max: %s\n", max.String())
+		fmt.Fprintf(out, "Avg: %s\n", avg.String())
+		fmt.Fprintf(out, "Median: %s\n", median.String())
+
+		if len(warmTimes) > 0 {
+			min, max, avg, median = calculateResponseTimeStats(warmTimes)
+			fmt.Fprintf(out, "\nWarm Response Time Statistics (reused connection, %d samples):\n", len(warmTimes))
+			fmt.Fprintf(out, "Min: %s\n", min.String())
+			fmt.Fprintf(out, "Max: %s\n", max.String())
+			fmt.Fprintf(out, "Avg: %s\n", avg.String())
+			fmt.Fprintf(out, "Median: %s\n", median.String())
 		}
 	}
+
+	if len(failedTests) > 0 && !options.matrix {
+		fmt.Fprintf(out, "\n=== Failed Tests ===\n")
+		printGrouped(out, failedTests, options.groupBy, printFailureTable)
+	}
+
+	if options.output == "junit" {
+		if err := writeJUnit(os.Stdout, startedAt, results); err != nil {
+			return fmt.Errorf("writing JUnit output: %v", err)
+		}
+	}
+
+	if gate.isAborted() {
+		return fmt.Errorf("test run aborted after %d of %d tests", len(results), len(proxies)*len(testers))
+	}
+
+	// Let CI gate on the overall success rate
+	failed := &testsFailedError{passed: len(successfulTests), total: len(results), failUnder: options.failUnder}
+	if len(results) > 0 && len(successfulTests) == 0 {
+		return failed
+	}
+	if percent(len(successfulTests), len(results)) < options.failUnder {
+		return failed
+	}
+	return nil
 }
 
 func main() {
-	// Load environment variables from .env file
-	err := godotenv.Load()
+	// Pull out the global flags before anything else reads the environment
+	args, globals, err := parseGlobalFlags(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	// Env files are optional; the real environment always takes precedence
+	envFiles, err := loadEnvFiles(globals.envFiles)
+	if err != nil {
+		exit(configErrorf("%v", err))
+	}
+
+	// Diagnostics go to stderr through the logger, data to stdout
+	logger, err = newLogger(globals.logLevel, globals.logFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	source := configSource{Path: findConfigFile(globals.configPath), Profile: globals.profile, Pool: globals.pool, EnvFiles: envFiles}
+	if source.Profile == "" {
+		source.Profile = os.Getenv("PROXY_PROFILE")
+	}
+	if source.Pool == "" {
+		source.Pool = os.Getenv("PROXY_POOL")
+	}
+	// These commands do not depend on the configuration, and the config and
+	// doctor commands report its problems themselves, so they run on a broken file
+	configCommand := len(args) > 0 && (args[0] == "config" || args[0] == "doctor" || args[0] == "help" || args[0] == "completion")
+	cfg, warnings, err := loadConfig(source.Path, source.Profile, source.Pool)
+	if err == nil {
+		if problems := cfg.validate(); len(problems) > 0 {
+			err = fmt.Errorf("%s", strings.Join(problems, "; "))
+		}
+	}
+	if err != nil && !configCommand {
+		exit(configErrorf("loading config: %v", err))
+	}
+	if err == nil {
+		if !configCommand {
+			for _, warning := range warnings {
+				logger.Warn(warning, "file", source.Path)
+			}
+		}
+		appConfig = cfg
+	}
+
+	exit(newRootCommand(source).execute(args))
+}
+
+// exit logs a command's error and exits with its exit code
+func exit(err error) {
 	if err != nil {
-		fmt.Printf("Error loading .env file: %v\n", err)
-		return
-	}
-
-	// Check command line arguments
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: ./go-proxy <command> [options]")
-		fmt.Println("Commands:")
-		fmt.Println("  list - Download and display proxy list from PROXY_LIST URL")
-		fmt.Println("  api  - Fetch proxy list from API using PROXY_API key")
-		fmt.Println("  test - Test proxies with exchange APIs")
-		fmt.Println("Options for api command:")
-		fmt.Println("  --refresh - Force refresh the cached proxy list")
-		fmt.Println("Options for test command:")
-		fmt.Println("  <exchange> - Specific exchange to test (e.g., binance)")
-		fmt.Println("  * - Test all available exchanges")
-		fmt.Println("  --limit <number> - Limit the number of proxies to test (e.g., --limit 10)")
-		return
-	}
-
-	command := os.Args[1]
-
-	switch command {
-	case "list":
-		handleListCommand()
-	case "api":
-		handleApiCommand()
-	case "test":
-		handleTestCommand()
-	default:
-		fmt.Printf("Unknown command: %s\n", command)
-		fmt.Println("Available commands: list, api, test")
+		logger.Error(err.Error(), "exit_code", exitCode(err))
 	}
+	os.Exit(exitCode(err))
 }
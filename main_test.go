@@ -0,0 +1,20 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+)
+
+// useTestConfig makes the defaults, with the data directory in a temporary
+// directory, the effective configuration for the rest of the test, and
+// silences the logger
+func useTestConfig(t *testing.T) *config {
+	t.Helper()
+	saved, savedLogger := appConfig, logger
+	t.Cleanup(func() { appConfig, logger = saved, savedLogger })
+
+	appConfig = defaultConfig()
+	appConfig.DataDir = t.TempDir()
+	logger = slog.New(slog.DiscardHandler)
+	return appConfig
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-proxy/exchanges"
+)
+
+// Histogram bucket upper bounds, in seconds
+var (
+	latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	fetchBuckets   = []float64{0.5, 1, 2.5, 5, 10, 30, 60}
+)
+
+// histogram is a fixed-bucket Prometheus histogram
+type histogram struct {
+	buckets []float64
+	counts  []uint64 // per bucket, not cumulative
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+// observe records one value
+func (h *histogram) observe(value float64) {
+	h.sum += value
+	h.count++
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+			return
+		}
+	}
+}
+
+// write emits the histogram series; labels are pre-rendered as `a="b",` pairs
+func (h *histogram) write(w io.Writer, name, labels string) {
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%g\"} %d\n", name, labels, bound, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	if labels != "" {
+		labels = "{" + strings.TrimSuffix(labels, ",") + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// testCounterKey labels the test counter
+type testCounterKey struct {
+	exchange, outcome, kind string
+}
+
+// latencyKey labels the latency histogram
+type latencyKey struct {
+	exchange, phase string
+}
+
+// healthKey labels the healthy-pool gauge
+type healthKey struct {
+	exchange, country string
+}
+
+// metrics collects the counters exposed on /metrics by long-running modes
+type metrics struct {
+	mutex         sync.Mutex
+	tests         map[testCounterKey]uint64
+	latency       map[latencyKey]*histogram
+	fetchDuration *histogram
+	fetchErrors   uint64
+	poolSize      func() int
+	healthy       func() map[healthKey]int
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		tests:         make(map[testCounterKey]uint64),
+		latency:       make(map[latencyKey]*histogram),
+		fetchDuration: newHistogram(fetchBuckets),
+	}
+}
+
+// exchangeLabel normalises a tester name to the registry name used in labels
+func exchangeLabel(name string) string {
+	return strings.ToLower(name)
+}
+
+// observeResult records the outcome and latency of one test
+func (m *metrics) observeResult(result *exchanges.TestResult) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	exchange := exchangeLabel(result.Exchange)
+	key := testCounterKey{exchange: exchange, outcome: "success"}
+	if !result.Success {
+		key.outcome = "failure"
+		key.kind = result.FailureKind
+	}
+	m.tests[key]++
+
+	if !result.Success {
+		return
+	}
+	m.observeLatency(latencyKey{exchange: exchange, phase: "cold"}, result.ResponseTime)
+	if result.WarmResponseTime > 0 {
+		m.observeLatency(latencyKey{exchange: exchange, phase: "warm"}, result.WarmResponseTime)
+	}
+}
+
+// observeLatency must be called with the mutex held
+func (m *metrics) observeLatency(key latencyKey, latency time.Duration) {
+	h, exists := m.latency[key]
+	if !exists {
+		h = newHistogram(latencyBuckets)
+		m.latency[key] = h
+	}
+	h.observe(latency.Seconds())
+}
+
+// observeFetch records one provider fetch
+func (m *metrics) observeFetch(duration time.Duration, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.fetchDuration.observe(duration.Seconds())
+	if err != nil {
+		m.fetchErrors++
+	}
+}
+
+// ServeHTTP writes every metric in the Prometheus text format
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+func (m *metrics) write(w io.Writer) {
+	// Gauges come from callbacks that take their own locks, so read them first
+	var healthy map[healthKey]int
+	if m.healthy != nil {
+		healthy = m.healthy()
+	}
+	poolSize := 0
+	if m.poolSize != nil {
+		poolSize = m.poolSize()
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	fmt.Fprintln(w, "# HELP go_proxy_tests_total Proxy tests run, by exchange, outcome and failure kind.")
+	fmt.Fprintln(w, "# TYPE go_proxy_tests_total counter")
+	testKeys := make([]testCounterKey, 0, len(m.tests))
+	for key := range m.tests {
+		testKeys = append(testKeys, key)
+	}
+	sort.Slice(testKeys, func(i, j int) bool {
+		return fmt.Sprint(testKeys[i]) < fmt.Sprint(testKeys[j])
+	})
+	for _, key := range testKeys {
+		fmt.Fprintf(w, "go_proxy_tests_total{exchange=%q,outcome=%q,failure_kind=%q} %d\n",
+			escapeLabel(key.exchange), key.outcome, key.kind, m.tests[key])
+	}
+
+	fmt.Fprintln(w, "# HELP go_proxy_test_latency_seconds Latency of successful proxy tests, by exchange and phase (cold or warm).")
+	fmt.Fprintln(w, "# TYPE go_proxy_test_latency_seconds histogram")
+	latencyKeys := make([]latencyKey, 0, len(m.latency))
+	for key := range m.latency {
+		latencyKeys = append(latencyKeys, key)
+	}
+	sort.Slice(latencyKeys, func(i, j int) bool {
+		return fmt.Sprint(latencyKeys[i]) < fmt.Sprint(latencyKeys[j])
+	})
+	for _, key := range latencyKeys {
+		labels := fmt.Sprintf("exchange=%q,phase=%q,", escapeLabel(key.exchange), key.phase)
+		m.latency[key].write(w, "go_proxy_test_latency_seconds", labels)
+	}
+
+	fmt.Fprintln(w, "# HELP go_proxy_healthy_proxies Proxies whose latest test passed, by exchange and country.")
+	fmt.Fprintln(w, "# TYPE go_proxy_healthy_proxies gauge")
+	healthKeys := make([]healthKey, 0, len(healthy))
+	for key := range healthy {
+		healthKeys = append(healthKeys, key)
+	}
+	sort.Slice(healthKeys, func(i, j int) bool {
+		return fmt.Sprint(healthKeys[i]) < fmt.Sprint(healthKeys[j])
+	})
+	for _, key := range healthKeys {
+		fmt.Fprintf(w, "go_proxy_healthy_proxies{exchange=%q,country=%q} %d\n",
+			escapeLabel(key.exchange), escapeLabel(key.country), healthy[key])
+	}
+
+	fmt.Fprintln(w, "# HELP go_proxy_pool_size Proxies currently loaded.")
+	fmt.Fprintln(w, "# TYPE go_proxy_pool_size gauge")
+	fmt.Fprintf(w, "go_proxy_pool_size %d\n", poolSize)
+
+	fmt.Fprintln(w, "# HELP go_proxy_provider_fetch_duration_seconds Duration of proxy list fetches from the provider.")
+	fmt.Fprintln(w, "# TYPE go_proxy_provider_fetch_duration_seconds histogram")
+	m.fetchDuration.write(w, "go_proxy_provider_fetch_duration_seconds", "")
+
+	fmt.Fprintln(w, "# HELP go_proxy_provider_fetch_errors_total Failed proxy list fetches from the provider.")
+	fmt.Fprintln(w, "# TYPE go_proxy_provider_fetch_errors_total counter")
+	fmt.Fprintf(w, "go_proxy_provider_fetch_errors_total %d\n", m.fetchErrors)
+}
+
+// escapeLabel strips characters that %q would render as Go rather than
+// Prometheus escapes; label values here are names and country codes
+func escapeLabel(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return -1
+		}
+		return r
+	}, value)
+}
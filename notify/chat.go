@@ -0,0 +1,45 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// DefaultTelegramURL is the Telegram Bot API endpoint
+const DefaultTelegramURL = "https://api.telegram.org"
+
+// Slack posts notifications to a Slack incoming webhook, or any service
+// accepting the same {"text": ...} payload, such as Mattermost
+type Slack struct {
+	URL    string
+	Client *http.Client // nil uses a client with DefaultTimeout
+}
+
+// Notify posts n as a Slack message
+func (s *Slack) Notify(ctx context.Context, n Notification) error {
+	return PostJSON(ctx, s.Client, s.URL, map[string]string{"text": n.Text()}, nil)
+}
+
+// Telegram sends notifications through a Telegram bot
+type Telegram struct {
+	Token  string
+	ChatID string
+	URL    string       // Bot API endpoint; empty means DefaultTelegramURL
+	Client *http.Client // nil uses a client with DefaultTimeout
+}
+
+// Notify sends n as a Telegram message
+func (t *Telegram) Notify(ctx context.Context, n Notification) error {
+	base := t.URL
+	if base == "" {
+		base = DefaultTelegramURL
+	}
+	endpoint := strings.TrimSuffix(base, "/") + "/bot" + t.Token + "/sendMessage"
+	payload := map[string]any{
+		"chat_id":                  t.ChatID,
+		"text":                     n.Text(),
+		"disable_web_page_preview": true,
+	}
+	return PostJSON(ctx, t.Client, endpoint, payload, nil)
+}
@@ -0,0 +1,86 @@
+// Package notify delivers alerts about proxy pool health to webhooks and
+// chat services.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultTimeout bounds each delivery when a channel has no client of its own
+const DefaultTimeout = 10 * time.Second
+
+// Alert states
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// Notification is one alert state change, or a reminder that an alert is
+// still firing
+type Notification struct {
+	Rule      string    `json:"rule"`
+	Status    string    `json:"status"` // StatusFiring or StatusResolved
+	Message   string    `json:"message"`
+	Value     float64   `json:"value"`     // the measured value, e.g. healthy proxies
+	Threshold float64   `json:"threshold"` // the value the rule compares against
+	Exchange  string    `json:"exchange,omitempty"`
+	Country   string    `json:"country,omitempty"`
+	Since     time.Time `json:"since"` // when the alert started firing
+	Time      time.Time `json:"time"`
+}
+
+// Text formats a notification as a single line for chat messages
+func (n Notification) Text() string {
+	if n.Status == StatusResolved {
+		return fmt.Sprintf("✅ RESOLVED %s: %s", n.Rule, n.Message)
+	}
+	return fmt.Sprintf("🔴 FIRING %s: %s", n.Rule, n.Message)
+}
+
+// Notifier delivers notifications to one channel
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// PostJSON posts payload as JSON to url with the extra headers, and fails
+// unless the response status is 2xx. A nil client uses one with
+// DefaultTimeout.
+func PostJSON(ctx context.Context, client *http.Client, url string, payload any, header http.Header) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return post(ctx, client, url, body, header)
+}
+
+// post sends an already encoded JSON body
+func post(ctx context.Context, client *http.Client, url string, body []byte, header http.Header) error {
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", redactURL(url), response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receiver is a local endpoint recording the last request it was sent
+type receiver struct {
+	server *httptest.Server
+	path   string
+	header http.Header
+	body   []byte
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	t.Helper()
+	r := &receiver{}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.path = req.URL.Path
+		r.header = req.Header.Clone()
+		r.body, _ = io.ReadAll(req.Body)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+var testNotification = Notification{
+	Rule:      "binance-healthy",
+	Status:    StatusFiring,
+	Message:   "3 healthy proxies for binance, below 10",
+	Value:     3,
+	Threshold: 10,
+	Exchange:  "binance",
+	Since:     time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	Time:      time.Date(2026, 1, 2, 3, 9, 5, 0, time.UTC),
+}
+
+func TestSign(t *testing.T) {
+	got := Sign("secret", "1700000000", []byte(`{"rule":"binance"}`))
+	want := "5d91cc7e31851ced18659701c56de66daff9bad24afbb905ef95a7e96f0c7b9c"
+	if got != want {
+		t.Errorf("Sign() = %s, want %s", got, want)
+	}
+	if Sign("other", "1700000000", []byte(`{"rule":"binance"}`)) == want {
+		t.Error("Sign() ignores the secret")
+	}
+	if Sign("secret", "1700000001", []byte(`{"rule":"binance"}`)) == want {
+		t.Error("Sign() ignores the timestamp")
+	}
+}
+
+func TestWebhookSignsRequests(t *testing.T) {
+	r := newReceiver(t, http.StatusNoContent)
+	webhook := &Webhook{URL: r.server.URL + "/hook", Secret: "secret"}
+	if err := webhook.Notify(context.Background(), testNotification); err != nil {
+		t.Fatalf("Notify() error: %v", err)
+	}
+
+	if got := r.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if got := r.header.Get(EventHeader); got != StatusFiring {
+		t.Errorf("%s = %q, want %q", EventHeader, got, StatusFiring)
+	}
+	timestamp := r.header.Get(TimestampHeader)
+	if timestamp == "" {
+		t.Fatalf("%s not set", TimestampHeader)
+	}
+	want := "sha256=" + Sign("secret", timestamp, r.body)
+	if got := r.header.Get(SignatureHeader); got != want {
+		t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+	}
+
+	var sent Notification
+	if err := json.Unmarshal(r.body, &sent); err != nil {
+		t.Fatalf("body is not a notification: %v", err)
+	}
+	if sent != testNotification {
+		t.Errorf("body = %+v, want %+v", sent, testNotification)
+	}
+}
+
+func TestWebhookWithoutSecret(t *testing.T) {
+	r := newReceiver(t, http.StatusOK)
+	webhook := &Webhook{URL: r.server.URL}
+	if err := webhook.Notify(context.Background(), testNotification); err != nil {
+		t.Fatalf("Notify() error: %v", err)
+	}
+	for _, name := range []string{SignatureHeader, TimestampHeader} {
+		if got := r.header.Get(name); got != "" {
+			t.Errorf("%s = %q, want it unset", name, got)
+		}
+	}
+}
+
+func TestSlackPayload(t *testing.T) {
+	r := newReceiver(t, http.StatusOK)
+	slack := &Slack{URL: r.server.URL + "/services/T000/B000/XXXX"}
+	if err := slack.Notify(context.Background(), testNotification); err != nil {
+		t.Fatalf("Notify() error: %v", err)
+	}
+
+	var payload map[string]any
+	if err := json.Unmarshal(r.body, &payload); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	want := map[string]any{"text": "🔴 FIRING binance-healthy: 3 healthy proxies for binance, below 10"}
+	if len(payload) != len(want) || payload["text"] != want["text"] {
+		t.Errorf("payload = %v, want %v", payload, want)
+	}
+}
+
+func TestTelegramPayload(t *testing.T) {
+	r := newReceiver(t, http.StatusOK)
+	telegram := &Telegram{Token: "123:abc", ChatID: "-100200", URL: r.server.URL + "/"}
+	resolved := testNotification
+	resolved.Status = StatusResolved
+	if err := telegram.Notify(context.Background(), resolved); err != nil {
+		t.Fatalf("Notify() error: %v", err)
+	}
+
+	if r.path != "/bot123:abc/sendMessage" {
+		t.Errorf("path = %q, want /bot123:abc/sendMessage", r.path)
+	}
+	var payload struct {
+		ChatID  string `json:"chat_id"`
+		Text    string `json:"text"`
+		Preview bool   `json:"disable_web_page_preview"`
+	}
+	if err := json.Unmarshal(r.body, &payload); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	if payload.ChatID != "-100200" {
+		t.Errorf("chat_id = %q, want -100200", payload.ChatID)
+	}
+	if want := "✅ RESOLVED binance-healthy: 3 healthy proxies for binance, below 10"; payload.Text != want {
+		t.Errorf("text = %q, want %q", payload.Text, want)
+	}
+	if !payload.Preview {
+		t.Error("disable_web_page_preview = false, want true")
+	}
+}
+
+func TestPostJSONRedactsFailures(t *testing.T) {
+	r := newReceiver(t, http.StatusInternalServerError)
+	err := PostJSON(context.Background(), nil, r.server.URL+"/bot123:secret/sendMessage", map[string]string{}, nil)
+	if err == nil {
+		t.Fatal("PostJSON() succeeded on a 500 response")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error %q leaks the URL path", err)
+	}
+	if !strings.Contains(err.Error(), "500") {
+		t.Errorf("error %q does not name the status", err)
+	}
+}
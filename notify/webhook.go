@@ -0,0 +1,65 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Headers set on generic webhook requests
+const (
+	SignatureHeader = "X-Go-Proxy-Signature"
+	TimestampHeader = "X-Go-Proxy-Timestamp"
+	EventHeader     = "X-Go-Proxy-Event"
+)
+
+// Webhook posts notifications as JSON. When Secret is set, each request is
+// signed: SignatureHeader carries "sha256=" and the hex HMAC-SHA256, keyed
+// with Secret, of the timestamp in TimestampHeader, a ".", and the body.
+// Receivers should recompute it and reject old timestamps.
+type Webhook struct {
+	URL    string
+	Secret string
+	Client *http.Client // nil uses a client with DefaultTimeout
+}
+
+// Notify posts n to the webhook
+func (w *Webhook) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set(EventHeader, n.Status)
+	if w.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		header.Set(TimestampHeader, timestamp)
+		header.Set(SignatureHeader, "sha256="+Sign(w.Secret, timestamp, body))
+	}
+	return post(ctx, w.Client, w.URL, body, header)
+}
+
+// Sign returns the hex HMAC-SHA256 signature of a webhook body
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// redactURL drops the path and query of a URL, which for chat webhooks
+// usually hold the secret token, so errors can be logged safely
+func redactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" {
+		return "webhook"
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go-proxy/proxypool"
+)
+
+// defaultPoolName is the pool used without --pool. Its cache is
+// provider.cache_file and its history lives directly in the data directory.
+const defaultPoolName = "default"
+
+// poolsDirName is the directory, next to the default cache and inside the
+// data directory, holding the caches and data of the named pools
+const poolsDirName = "pools"
+
+// poolNamePattern restricts pool names to what is safe in a file name
+var poolNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// checkPoolName reports whether name can be used for a named pool
+func checkPoolName(name string) error {
+	if name == defaultPoolName {
+		return fmt.Errorf("'%s' is the name of the default pool", name)
+	}
+	if !poolNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid pool name '%s': use up to 64 letters, digits, '-' and '_'", name)
+	}
+	return nil
+}
+
+// usePool makes the provider settings of the named pool the effective ones.
+// An empty name selects the default pool. A named pool must be configured
+// under pools or have been created with 'pool create'.
+func (c *config) usePool(name string) error {
+	if name == "" || name == defaultPoolName {
+		c.pool = defaultPoolName
+		return nil
+	}
+	if err := checkPoolName(name); err != nil {
+		return err
+	}
+	if !c.poolExists(name) {
+		return fmt.Errorf("pool '%s' does not exist (available: %s); create it with '%s pool create %s' or configure it under pools",
+			name, strings.Join(c.poolNames(), ", "), programName, name)
+	}
+	c.baseProvider = c.Provider
+	c.pool = name
+	c.Provider = c.poolProvider(name)
+	return nil
+}
+
+// sharedProvider returns the provider section without the overrides of the
+// selected pool
+func (c *config) sharedProvider() providerConfig {
+	if c.pool == defaultPoolName {
+		return c.Provider
+	}
+	return c.baseProvider
+}
+
+// poolProvider returns the effective provider settings of a pool
+func (c *config) poolProvider(name string) providerConfig {
+	provider := c.sharedProvider()
+	if name == defaultPoolName {
+		return provider
+	}
+	override := c.Pools[name].Provider
+	if override.ListURL != "" {
+		provider.ListURL = override.ListURL
+	}
+	if override.APIKey != "" {
+		provider.APIKey = override.APIKey
+	}
+	if override.WebshareURL != "" {
+		provider.WebshareURL = override.WebshareURL
+	}
+	if len(override.Lists) > 0 {
+		provider.Lists = override.Lists
+	}
+	if len(override.Sources) > 0 {
+		provider.Sources = override.Sources
+	}
+	applyProviderEnv(&provider)
+	provider.CacheFile = c.poolCacheFile(name)
+	return provider
+}
+
+// poolCacheFile returns the path of a pool's proxy cache
+func (c *config) poolCacheFile(name string) string {
+	if name == defaultPoolName {
+		return c.sharedProvider().CacheFile
+	}
+	if path := c.Pools[name].Provider.CacheFile; path != "" {
+		return path
+	}
+	return filepath.Join(c.poolsDir(), name+".json")
+}
+
+// poolsDir returns the directory holding the caches of the named pools
+func (c *config) poolsDir() string {
+	return filepath.Join(filepath.Dir(c.sharedProvider().CacheFile), poolsDirName)
+}
+
+// poolExists reports whether a pool is configured or has a cache
+func (c *config) poolExists(name string) bool {
+	if name == defaultPoolName {
+		return true
+	}
+	if _, configured := c.Pools[name]; configured {
+		return true
+	}
+	_, err := os.Stat(c.poolCacheFile(name))
+	return err == nil
+}
+
+// poolNames lists the default pool followed by the configured pools and the
+// pools found in the pools directory, sorted
+func (c *config) poolNames() []string {
+	var names []string
+	for name := range c.Pools {
+		names = append(names, name)
+	}
+	entries, _ := os.ReadDir(c.poolsDir())
+	for _, entry := range entries {
+		name, isCache := strings.CutSuffix(entry.Name(), ".json")
+		if isCache && !entry.IsDir() && checkPoolName(name) == nil && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append([]string{defaultPoolName}, names...)
+}
+
+// poolDataDir returns the directory holding the history and other data of a
+// pool: the data directory itself for the default pool, and pools/<name>
+// inside it for the others
+func poolDataDir(name string) (string, error) {
+	dir, err := sharedDataDir()
+	if err != nil || name == defaultPoolName {
+		return dir, err
+	}
+	return filepath.Join(dir, poolsDirName, name), nil
+}
+
+// loadPool reads the proxies cached for a pool
+func loadPool(name string) ([]proxypool.Proxy, error) {
+	if !appConfig.poolExists(name) {
+		return nil, usageErrorf("pool '%s' does not exist (available: %s)", name, strings.Join(appConfig.poolNames(), ", "))
+	}
+	proxies, err := proxypool.LoadCache(appConfig.poolCacheFile(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, configErrorf("pool '%s' has no proxies yet; fetch them with '%s --pool %s api --refresh'", name, programName, name)
+	}
+	if err != nil {
+		return nil, configErrorf("loading pool '%s': %v", name, err)
+	}
+	return proxies, nil
+}
+
+// checkTargetPool validates the name of a pool a command writes to
+func checkTargetPool(name string) error {
+	if name == defaultPoolName {
+		return nil
+	}
+	if err := checkPoolName(name); err != nil {
+		return usageErrorf("%v", err)
+	}
+	return nil
+}
+
+// handlePoolList prints every pool with the size and age of its cache. The
+// selected pool is marked with an asterisk.
+func handlePoolList() error {
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(table, "  Pool\tProxies\tUpdated\tCache")
+	for _, name := range appConfig.poolNames() {
+		marker := " "
+		if name == appConfig.pool {
+			marker = "*"
+		}
+		path := appConfig.poolCacheFile(name)
+		count, updated := "-", "never"
+		if info, err := os.Stat(path); err == nil {
+			updated = info.ModTime().Format(time.DateTime)
+			if proxies, err := proxypool.LoadCache(path); err == nil {
+				count = fmt.Sprint(len(proxies))
+			} else {
+				count = "unreadable"
+			}
+		}
+		fmt.Fprintf(table, "%s %s\t%s\t%s\t%s\n", marker, name, count, updated, path)
+	}
+	return table.Flush()
+}
+
+// handlePoolCreate creates an empty named pool
+func handlePoolCreate(name string) error {
+	if err := checkPoolName(name); err != nil {
+		return usageErrorf("%v", err)
+	}
+	path := appConfig.poolCacheFile(name)
+	if _, err := os.Stat(path); err == nil {
+		return usageErrorf("pool '%s' already exists (%s)", name, path)
+	}
+	if err := proxypool.SaveCache(path, []proxypool.Proxy{}); err != nil {
+		return fmt.Errorf("creating pool '%s': %v", name, err)
+	}
+	fmt.Printf("Created pool %s (%s)\n", name, path)
+	fmt.Printf("Fetch its proxies with '%s --pool %s api --refresh'\n", programName, name)
+	return nil
+}
+
+// handlePoolCopy replaces the proxies of target with those of source. An
+// existing target is only replaced with force. Test history is not copied.
+func handlePoolCopy(source, target string, force bool) error {
+	if err := checkTargetPool(target); err != nil {
+		return err
+	}
+	if source == target {
+		return usageErrorf("cannot copy pool '%s' onto itself", source)
+	}
+	proxies, err := loadPool(source)
+	if err != nil {
+		return err
+	}
+	path := appConfig.poolCacheFile(target)
+	if _, err := os.Stat(path); err == nil && !force {
+		return usageErrorf("pool '%s' already exists; pass --force to replace its proxies, or use 'pool merge'", target)
+	}
+	if err := proxypool.SaveCache(path, proxies); err != nil {
+		return fmt.Errorf("saving pool '%s': %v", target, err)
+	}
+	fmt.Printf("Copied %d proxies from %s to %s\n", len(proxies), source, target)
+	return nil
+}
+
+// handlePoolMerge adds the proxies of the source pools that target does not
+// have yet, creating target if needed. Proxies are matched as by
+// proxypool.Merge; a proxy in several pools keeps the provenance of each, and
+// target's country codes win over those of the sources.
+func handlePoolMerge(sources []string, target string) error {
+	if err := checkTargetPool(target); err != nil {
+		return err
+	}
+	if slices.Contains(sources, target) {
+		return usageErrorf("cannot merge pool '%s' into itself", target)
+	}
+
+	path := appConfig.poolCacheFile(target)
+	current, err := proxypool.LoadCache(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return configErrorf("loading pool '%s': %v", target, err)
+	}
+	existing, _ := proxypool.Merge(proxypool.Listing{Proxies: current})
+
+	listings := []proxypool.Listing{{Proxies: existing}}
+	incoming := 0
+	for _, source := range sources {
+		proxies, err := loadPool(source)
+		if err != nil {
+			return err
+		}
+		listings = append(listings, proxypool.Listing{Proxies: proxies})
+		incoming += len(proxies)
+	}
+	merged, conflicts := proxypool.Merge(listings...)
+
+	if err := proxypool.SaveCache(path, merged); err != nil {
+		return fmt.Errorf("saving pool '%s': %v", target, err)
+	}
+	added := len(merged) - len(existing)
+	fmt.Printf("Merged %d new proxies from %s into %s (%d duplicates skipped, %d total)\n",
+		added, strings.Join(sources, ", "), target, incoming-added, len(merged))
+	if len(conflicts) > 0 {
+		logger.Warn("kept the target pool's country code for proxies whose pools disagree", "proxies", len(conflicts))
+	}
+	return nil
+}
+
+// handlePoolDelete removes the cache of a named pool and, with purge, its
+// test history and other data
+func handlePoolDelete(name string, purge bool) error {
+	if name == defaultPoolName {
+		return usageErrorf("the default pool cannot be deleted")
+	}
+	if !appConfig.poolExists(name) {
+		return usageErrorf("pool '%s' does not exist (available: %s)", name, strings.Join(appConfig.poolNames(), ", "))
+	}
+
+	path := appConfig.poolCacheFile(name)
+	for _, file := range []string{path, path + ".lock"} {
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("deleting pool '%s': %v", name, err)
+		}
+	}
+	if purge {
+		dir, err := poolDataDir(name)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("deleting the data of pool '%s': %v", name, err)
+		}
+	}
+
+	fmt.Printf("Deleted pool %s\n", name)
+	if _, configured := appConfig.Pools[name]; configured {
+		logger.Warn("the pool is still configured; remove it from pools in the config file", "pool", name)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"go-proxy/exchanges"
+	"go-proxy/proxypool"
+)
+
+// Names of the providers configured directly in the provider section.
+// Further plain-text lists are named in provider.lists.
+const (
+	webshareProvider = "webshare" // provider.api_key and provider.webshare_url
+	listProvider     = "list"     // provider.list_url
+)
+
+// sourceNames returns the providers api --refresh combines, highest
+// priority first: provider.sources, or only Webshare when it is not set
+func (p providerConfig) sourceNames() []string {
+	if len(p.Sources) > 0 {
+		return p.Sources
+	}
+	return []string{webshareProvider}
+}
+
+// checkSources reports the first provider to fetch that is missing its settings
+func (p providerConfig) checkSources() error {
+	for _, name := range p.sourceNames() {
+		switch {
+		case name == webshareProvider && p.APIKey == "":
+			return configErrorf("no API key configured; set PROXY_API or provider.api_key")
+		case name == listProvider && p.ListURL == "":
+			return configErrorf("no proxy list URL configured; set PROXY_LIST or provider.list_url")
+		}
+	}
+	return nil
+}
+
+// source creates the proxy source of a provider
+func (p providerConfig) source(name string) proxypool.Source {
+	switch name {
+	case webshareProvider:
+		source := proxypool.NewWebshareSource(p.APIKey)
+		source.URL = p.WebshareURL
+		source.Logger = logger
+		source.OnPage = func(page int) {
+			logger.Info("fetching proxy page", "source", name, "page", page)
+		}
+		return source
+	case listProvider:
+		return &proxypool.ListSource{URL: p.ListURL, Logger: logger}
+	}
+	return &proxypool.ListSource{URL: p.Lists[name], Logger: logger}
+}
+
+// fetchProxies fetches every provider of the selected pool and merges their
+// lists. A proxy listed by several providers appears once, tagged with all
+// of them, and keeps the country of the highest-priority provider that has
+// one. Any provider failing fails the fetch, so a partial list never
+// replaces the cache.
+func fetchProxies(ctx context.Context) ([]proxypool.Proxy, error) {
+	provider := appConfig.Provider
+	if err := provider.checkSources(); err != nil {
+		return nil, err
+	}
+
+	var listings []proxypool.Listing
+	total := 0
+	for _, name := range provider.sourceNames() {
+		proxies, err := provider.source(name).Fetch(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		logger.Info("fetched proxies", "source", name, "proxies", len(proxies))
+		listings = append(listings, proxypool.Listing{Source: name, Proxies: proxies})
+		total += len(proxies)
+	}
+
+	proxies, conflicts := proxypool.Merge(listings...)
+	for _, conflict := range conflicts {
+		logger.Debug("country codes disagree", "proxy", conflict.Proxy.Key(), "kept", conflict.Proxy.CountryCode,
+			"source", conflict.Source, "dropped", conflict.Country)
+	}
+	if len(listings) > 1 || len(proxies) < total {
+		logger.Info("merged proxies", "proxies", len(proxies), "duplicates", total-len(proxies), "country_conflicts", len(conflicts))
+	}
+	return proxies, nil
+}
+
+// validateProvider reports problems in a provider section at key. lists are
+// the named lists its sources may refer to, which a pool may inherit.
+func validateProvider(key string, p providerConfig, lists map[string]string, report func(format string, args ...any), checkURL func(key, value string)) {
+	checkURL(key+".list_url", p.ListURL)
+	checkURL(key+".webshare_url", p.WebshareURL)
+
+	names := make([]string, 0, len(p.Lists))
+	for name := range p.Lists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == webshareProvider || name == listProvider {
+			report("%s.lists.%s: '%s' is reserved for the provider section's own settings", key, name, name)
+		}
+		if p.Lists[name] == "" {
+			report("%s.lists.%s: URL must not be empty", key, name)
+		}
+		checkURL(key+".lists."+name, p.Lists[name])
+	}
+
+	available := []string{webshareProvider, listProvider}
+	for name := range lists {
+		if !slices.Contains(available, name) {
+			available = append(available, name)
+		}
+	}
+	sort.Strings(available[2:])
+	var seen []string
+	for _, name := range p.Sources {
+		switch {
+		case slices.Contains(seen, name):
+			report("%s.sources: '%s' is listed twice", key, name)
+		case !slices.Contains(available, name):
+			report("%s.sources: unknown provider '%s' (available: %s)", key, name, strings.Join(available, ", "))
+		}
+		seen = append(seen, name)
+	}
+}
+
+// sourceStats counts, for one provider, the proxies it listed and how many
+// of them no other provider listed
+type sourceStats struct {
+	name          string
+	proxies, only int
+}
+
+// handlePoolStats prints how many proxies each provider contributed to a
+// pool and how much the providers overlap
+func handlePoolStats(name string) error {
+	proxies, err := loadPool(name)
+	if err != nil {
+		return err
+	}
+
+	// Providers in configured priority order, then any others found in the cache
+	order := slices.Clone(appConfig.poolProvider(name).sourceNames())
+	for _, proxy := range proxies {
+		for _, source := range proxy.Sources {
+			if !slices.Contains(order, source) {
+				order = append(order, source)
+			}
+		}
+	}
+
+	stats := make(map[string]*sourceStats, len(order))
+	for _, source := range order {
+		stats[source] = &sourceStats{name: source}
+	}
+	overlap := make(map[[2]string]int)
+	listedBy := make(map[int]int) // number of providers -> proxies
+	for _, proxy := range proxies {
+		listedBy[len(proxy.Sources)]++
+		for _, source := range proxy.Sources {
+			stats[source].proxies++
+			if len(proxy.Sources) == 1 {
+				stats[source].only++
+			}
+			for _, other := range proxy.Sources {
+				overlap[[2]string{source, other}]++
+			}
+		}
+	}
+
+	fmt.Printf("Pool %s: %d proxies from %s\n\n", name, len(proxies), strings.Join(order, ", "))
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(table, "Provider\tProxies\tOnly here\tShared")
+	for _, source := range order {
+		s := stats[source]
+		fmt.Fprintf(table, "%s\t%d\t%d\t%d\n", s.name, s.proxies, s.only, s.proxies-s.only)
+	}
+	untagged := listedBy[0]
+	if untagged > 0 {
+		fmt.Fprintf(table, "(unknown)\t%d\n", untagged)
+	}
+	table.Flush()
+	if untagged > 0 {
+		fmt.Println("\nProxies of unknown provenance were cached before providers were tracked; refresh the pool to tag them.")
+	}
+
+	families := make(map[string]int)
+	for _, proxy := range proxies {
+		families[exchanges.AddressFamily(proxy.ProxyAddress)]++
+	}
+	fmt.Printf("\nAddress families: %d IPv4, %d IPv6, %d host names\n",
+		families[exchanges.FamilyIPv4], families[exchanges.FamilyIPv6], families[""])
+
+	if len(order) > 1 {
+		fmt.Println("\nOverlap (proxies listed by both providers):")
+		table = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintf(table, "\t%s\n", strings.Join(order, "\t"))
+		for _, row := range order {
+			fmt.Fprint(table, row)
+			for _, column := range order {
+				fmt.Fprintf(table, "\t%d", overlap[[2]string{row, column}])
+			}
+			fmt.Fprintln(table)
+		}
+		table.Flush()
+
+		fmt.Println("\nProviders listing each proxy:")
+		for count := len(order); count >= 1; count-- {
+			if listedBy[count] > 0 {
+				fmt.Printf("  %d of %d: %d proxies (%.1f%%)\n", count, len(order), listedBy[count], percent(listedBy[count], len(proxies)))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package proxypool
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAffinityTTL is how long an idle affinity key keeps its proxy
+const DefaultAffinityTTL = 30 * time.Minute
+
+// SessionSeparator splits a proxy username into user and session, as in "user-session-abc"
+const SessionSeparator = "-session-"
+
+// AffinityKeyFunc extracts the affinity key of a request. Requests with the
+// same non-empty key are routed through the same upstream proxy.
+type AffinityKeyFunc func(req *http.Request) string
+
+type affinityContextKey struct{}
+
+// WithAffinityKey returns a context that pins requests made with it to one proxy
+func WithAffinityKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, affinityContextKey{}, key)
+}
+
+// ContextAffinity reads the key set with WithAffinityKey
+func ContextAffinity() AffinityKeyFunc {
+	return func(req *http.Request) string {
+		key, _ := req.Context().Value(affinityContextKey{}).(string)
+		return key
+	}
+}
+
+// HeaderAffinity reads the key from a request header
+func HeaderAffinity(name string) AffinityKeyFunc {
+	return func(req *http.Request) string {
+		return req.Header.Get(name)
+	}
+}
+
+// SourceIPAffinity uses the client's IP address as the key. It only applies
+// to requests received by a server, such as ForwardProxy.
+func SourceIPAffinity() AffinityKeyFunc {
+	return func(req *http.Request) string {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			return req.RemoteAddr
+		}
+		return host
+	}
+}
+
+// UsernameSessionAffinity uses the session suffix of the Proxy-Authorization
+// username, so "user-session-abc" yields the key "abc"
+func UsernameSessionAffinity() AffinityKeyFunc {
+	return func(req *http.Request) string {
+		username, _, ok := ProxyBasicAuth(req)
+		if !ok {
+			return ""
+		}
+		_, session := SplitSessionUsername(username)
+		return session
+	}
+}
+
+// FirstAffinity returns the first non-empty key produced by funcs
+func FirstAffinity(funcs ...AffinityKeyFunc) AffinityKeyFunc {
+	return func(req *http.Request) string {
+		for _, f := range funcs {
+			if key := f(req); key != "" {
+				return key
+			}
+		}
+		return ""
+	}
+}
+
+// SplitSessionUsername splits "user-session-abc" into "user" and "abc". A
+// username without a session suffix is returned unchanged with no session.
+func SplitSessionUsername(username string) (user, session string) {
+	index := strings.LastIndex(username, SessionSeparator)
+	if index < 0 {
+		return username, ""
+	}
+	return username[:index], username[index+len(SessionSeparator):]
+}
+
+// ProxyBasicAuth returns the credentials of the request's Proxy-Authorization header
+func ProxyBasicAuth(req *http.Request) (username, password string, ok bool) {
+	auth, found := strings.CutPrefix(req.Header.Get("Proxy-Authorization"), "Basic ")
+	if !found {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth)
+	if err != nil {
+		return "", "", false
+	}
+	username, password, ok = strings.Cut(string(decoded), ":")
+	return username, password, ok
+}
+
+// affinityEntry binds a key to a proxy until it expires
+type affinityEntry struct {
+	proxy   Proxy
+	expires time.Time
+}
+
+// AffinityTable maps affinity keys to upstream proxies. A binding lasts for
+// TTL after its last use and is only moved to another proxy early when its
+// proxy is no longer healthy for the requested host.
+type AffinityTable struct {
+	TTL time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]*affinityEntry
+}
+
+// NewAffinityTable creates an affinity table with the given TTL
+func NewAffinityTable(ttl time.Duration) *AffinityTable {
+	if ttl <= 0 {
+		ttl = DefaultAffinityTTL
+	}
+	return &AffinityTable{
+		TTL:     ttl,
+		entries: make(map[string]*affinityEntry),
+	}
+}
+
+// Select returns the proxy bound to key if it is still usable for host, and
+// otherwise binds key to the next healthy proxy from pool
+func (a *AffinityTable) Select(pool *Pool, key, host string, exclude map[string]bool) (Proxy, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := time.Now()
+	if entry, exists := a.entries[key]; exists && now.Before(entry.expires) && !exclude[entry.proxy.ID()] {
+		if pool.isHealthy(entry.proxy, host) {
+			entry.expires = now.Add(a.TTL)
+			return entry.proxy, true
+		}
+	}
+
+	proxy, ok := pool.Next(host, exclude)
+	if !ok {
+		return Proxy{}, false
+	}
+	a.entries[key] = &affinityEntry{proxy: proxy, expires: now.Add(a.TTL)}
+	a.pruneLocked(now)
+	return proxy, true
+}
+
+// Lookup returns the proxy currently bound to key, if any
+func (a *AffinityTable) Lookup(key string) (Proxy, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	entry, exists := a.entries[key]
+	if !exists || time.Now().After(entry.expires) {
+		return Proxy{}, false
+	}
+	return entry.proxy, true
+}
+
+// Len returns the number of live bindings
+func (a *AffinityTable) Len() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.pruneLocked(time.Now())
+	return len(a.entries)
+}
+
+// pruneLocked drops expired bindings; it must be called with the mutex held
+func (a *AffinityTable) pruneLocked(now time.Time) {
+	for key, entry := range a.entries {
+		if now.After(entry.expires) {
+			delete(a.entries, key)
+		}
+	}
+}
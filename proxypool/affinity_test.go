@@ -0,0 +1,158 @@
+package proxypool
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newTestPool returns a pool refreshed with the given proxies
+func newTestPool(t *testing.T, proxies ...Proxy) *Pool {
+	t.Helper()
+	pool := New(StaticSource(proxies))
+	if err := pool.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func TestSplitSessionUsername(t *testing.T) {
+	tests := []struct {
+		username, user, session string
+	}{
+		{"alice", "alice", ""},
+		{"alice-session-abc", "alice", "abc"},
+		{"alice-session-", "alice", ""},
+		{"a-session-b-session-c", "a-session-b", "c"},
+	}
+	for _, test := range tests {
+		user, session := SplitSessionUsername(test.username)
+		if user != test.user || session != test.session {
+			t.Errorf("SplitSessionUsername(%q) = %q, %q; want %q, %q", test.username, user, session, test.user, test.session)
+		}
+	}
+}
+
+func TestAffinityKeyFuncs(t *testing.T) {
+	request := func(header http.Header) *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.Header = header
+		req.RemoteAddr = "[2001:db8::7]:51000"
+		return req
+	}
+	basic := func(credentials string) http.Header {
+		return http.Header{"Proxy-Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))}}
+	}
+
+	tests := []struct {
+		name string
+		key  AffinityKeyFunc
+		req  *http.Request
+		want string
+	}{
+		{"header", HeaderAffinity("X-Session"), request(http.Header{"X-Session": {"s1"}}), "s1"},
+		{"missing header", HeaderAffinity("X-Session"), request(http.Header{}), ""},
+		{"source IP", SourceIPAffinity(), request(http.Header{}), "2001:db8::7"},
+		{"username session", UsernameSessionAffinity(), request(basic("alice-session-abc:pw")), "abc"},
+		{"username without session", UsernameSessionAffinity(), request(basic("alice:pw")), ""},
+		{"not basic auth", UsernameSessionAffinity(), request(http.Header{"Proxy-Authorization": {"Bearer x"}}), ""},
+		{"context", ContextAffinity(), request(http.Header{}).WithContext(WithAffinityKey(context.Background(), "c1")), "c1"},
+		{"first non-empty", FirstAffinity(HeaderAffinity("X-Session"), SourceIPAffinity()), request(http.Header{}), "2001:db8::7"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.key(test.req); got != test.want {
+				t.Errorf("key = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAffinityTableSticks(t *testing.T) {
+	pool := newTestPool(t, Proxy{ProxyAddress: "192.0.2.1", Port: 1}, Proxy{ProxyAddress: "192.0.2.1", Port: 2}, Proxy{ProxyAddress: "192.0.2.1", Port: 3})
+	table := NewAffinityTable(time.Minute)
+
+	first, ok := table.Select(pool, "alice", "example.com", nil)
+	if !ok {
+		t.Fatal("Select() found no proxy")
+	}
+	other, _ := table.Select(pool, "bob", "example.com", nil)
+	if other.Key() == first.Key() {
+		t.Errorf("keys alice and bob share %s, want round-robin between keys", first.Key())
+	}
+	for range 5 {
+		if proxy, _ := table.Select(pool, "alice", "example.com", nil); proxy.Key() != first.Key() {
+			t.Fatalf("alice moved from %s to %s", first.Key(), proxy.Key())
+		}
+	}
+	if proxy, ok := table.Lookup("alice"); !ok || proxy.Key() != first.Key() {
+		t.Errorf("Lookup(alice) = %s, %t; want %s", proxy.Key(), ok, first.Key())
+	}
+	if table.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", table.Len())
+	}
+}
+
+func TestAffinityTableMoves(t *testing.T) {
+	tests := []struct {
+		name    string
+		disturb func(pool *Pool, bound Proxy) map[string]bool // returns the exclude set
+	}{
+		{"proxy failed for the host", func(pool *Pool, bound Proxy) map[string]bool {
+			pool.MarkFailed(bound, "example.com")
+			return nil
+		}},
+		{"proxy excluded", func(pool *Pool, bound Proxy) map[string]bool {
+			return map[string]bool{bound.Key(): true}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := newTestPool(t, Proxy{ProxyAddress: "192.0.2.1", Port: 1}, Proxy{ProxyAddress: "192.0.2.1", Port: 2})
+			table := NewAffinityTable(time.Minute)
+			bound, _ := table.Select(pool, "alice", "example.com", nil)
+
+			exclude := test.disturb(pool, bound)
+			moved, ok := table.Select(pool, "alice", "example.com", exclude)
+			if !ok || moved.Key() == bound.Key() {
+				t.Fatalf("alice stayed on %s, want another proxy", bound.Key())
+			}
+			if proxy, _ := table.Lookup("alice"); proxy.Key() != moved.Key() {
+				t.Errorf("alice is bound to %s, want the new proxy %s", proxy.Key(), moved.Key())
+			}
+		})
+	}
+}
+
+func TestAffinityTableExpires(t *testing.T) {
+	pool := newTestPool(t, Proxy{ProxyAddress: "192.0.2.1", Port: 1})
+	table := NewAffinityTable(10 * time.Millisecond)
+	table.Select(pool, "alice", "example.com", nil)
+
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := table.Lookup("alice"); ok {
+		t.Error("Lookup() found a binding past its TTL")
+	}
+	if table.Len() != 0 {
+		t.Errorf("Len() = %d after the TTL, want 0", table.Len())
+	}
+}
+
+func TestAffinityTableWithoutHealthyProxy(t *testing.T) {
+	proxy := Proxy{ProxyAddress: "192.0.2.1", Port: 1}
+	pool := newTestPool(t, proxy)
+	table := NewAffinityTable(0)
+	if table.TTL != DefaultAffinityTTL {
+		t.Errorf("TTL = %s, want the default %s", table.TTL, DefaultAffinityTTL)
+	}
+
+	pool.MarkFailed(proxy, "example.com")
+	if _, ok := table.Select(pool, "alice", "example.com", nil); ok {
+		t.Error("Select() returned a proxy cooling down")
+	}
+	if table.Len() != 0 {
+		t.Errorf("Len() = %d, want no binding", table.Len())
+	}
+}
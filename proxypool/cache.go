@@ -0,0 +1,167 @@
+package proxypool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// The cache may hold proxy credentials, so it is only readable by its owner
+const (
+	cacheFileMode = 0600
+	cacheDirMode  = 0700
+)
+
+// CacheSource reads proxies from a JSON cache file written by SaveCache
+type CacheSource struct {
+	Path string
+}
+
+// Fetch loads the cache file
+func (s *CacheSource) Fetch(ctx context.Context) ([]Proxy, error) {
+	return LoadCache(s.Path)
+}
+
+// LoadCache reads a JSON cache file of proxies, holding a shared lock so it
+// never reads while SaveCache is replacing the file
+func LoadCache(path string) ([]Proxy, error) {
+	data, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var proxies []Proxy
+	if err := json.Unmarshal(data, &proxies); err != nil {
+		return nil, err
+	}
+
+	return proxies, nil
+}
+
+// SaveCache writes proxies to a JSON cache file, creating its directory if
+// needed. The file is written to a temporary file in the same directory and
+// renamed over the old one under an exclusive lock, so a crash or a
+// concurrent writer never leaves a partial cache.
+func SaveCache(path string, proxies []Proxy) error {
+	data, err := json.MarshalIndent(proxies, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFile(path, data)
+}
+
+// ReadFile reads a file written by WriteFile or UpdateFile, holding a
+// shared lock so it never reads while the file is being replaced
+func ReadFile(path string) ([]byte, error) {
+	// Without a lock file, as when the directory is missing or read-only,
+	// read anyway: writes are atomic, so a read never sees half a file
+	if unlock, err := lockCache(path, false); err == nil {
+		defer unlock()
+	}
+	return os.ReadFile(path)
+}
+
+// WriteFile replaces the file at path with data the way SaveCache replaces
+// the cache: atomically, under an exclusive lock, and readable only by its
+// owner. Its directory is created if needed.
+func WriteFile(path string, data []byte) error {
+	return UpdateFile(path, func([]byte) ([]byte, error) { return data, nil })
+}
+
+// UpdateFile replaces the file at path with what update returns for its
+// current content, nil if it does not exist yet. The read and the write
+// happen under one exclusive lock, so updates from several processes take
+// turns instead of overwriting each other. Nothing is written when update
+// fails.
+func UpdateFile(path string, update func(data []byte) ([]byte, error)) error {
+	if err := os.MkdirAll(filepath.Dir(path), cacheDirMode); err != nil {
+		return err
+	}
+	unlock, err := lockCache(path, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if data, err = update(data); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// AppendFile appends data to the file at path under the exclusive lock of
+// UpdateFile, so an append never lands in a file that an update is about to
+// replace. The file and its directory are created if needed.
+func AppendFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), cacheDirMode); err != nil {
+		return err
+	}
+	unlock, err := lockCache(path, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, cacheFileMode)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// lockCache takes an advisory lock on the cache at path, shared or
+// exclusive, and returns the function releasing it. The lock is held on a
+// separate ".lock" file, since the cache file itself is replaced on every
+// write.
+func lockCache(path string, exclusive bool) (func(), error) {
+	file, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, cacheFileMode)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file, exclusive); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(file)
+		file.Close()
+	}, nil
+}
+
+// writeFileAtomic replaces path with data through a temporary file and a
+// rename, so readers see either the old or the new content
+func writeFileAtomic(path string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name()) // fails harmlessly once renamed
+
+	if err := temp.Chmod(cacheFileMode); err != nil {
+		temp.Close()
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
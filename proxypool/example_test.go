@@ -0,0 +1,27 @@
+package proxypool_test
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"go-proxy/exchanges"
+	"go-proxy/proxypool"
+)
+
+// A service keeping a pool of Webshare proxies healthy for Binance and
+// sending its requests through them
+func Example() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := proxypool.New(proxypool.NewWebshareSource("api-key"))
+	pool.AddProbe(exchanges.NewBinanceTester(exchanges.NewTransportPool()), "*.binance.com")
+	go pool.Run(ctx, 5*time.Minute, func(err error) {
+		log.Printf("proxy pool: %v", err)
+	})
+
+	client := &http.Client{Transport: proxypool.NewRoundTripper(pool)}
+	_ = client // client.Get("https://api.binance.com/api/v3/time") goes through a healthy proxy
+}
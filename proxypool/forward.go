@@ -0,0 +1,243 @@
+package proxypool
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// SessionHeader is the request header ForwardProxy reads an affinity key from
+const SessionHeader = "X-Proxy-Session"
+
+// Headers that apply to a single hop and are never forwarded upstream
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	SessionHeader,
+}
+
+// ForwardProxy is an HTTP forward proxy that sends client traffic through
+// the healthy proxies of a RoundTripper's pool. Plain HTTP requests go
+// through the RoundTripper; CONNECT tunnels are opened through an upstream
+// proxy chosen the same way.
+type ForwardProxy struct {
+	Transport *RoundTripper
+
+	// AffinityKey extracts the affinity key of a client request. It defaults
+	// to the proxy username session suffix, then the X-Proxy-Session header.
+	AffinityKey AffinityKeyFunc
+
+	// Authenticate, if set, must accept the client's proxy credentials. The
+	// username is passed without its session suffix.
+	Authenticate func(username, password string) bool
+
+	// DialTimeout limits opening a tunnel through an upstream proxy
+	DialTimeout time.Duration
+}
+
+// NewForwardProxy creates a forward proxy that routes through transport
+func NewForwardProxy(transport *RoundTripper) *ForwardProxy {
+	return &ForwardProxy{
+		Transport:   transport,
+		DialTimeout: 10 * time.Second,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (f *ForwardProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.Authenticate != nil {
+		username, password, ok := ProxyBasicAuth(r)
+		user, _ := SplitSessionUsername(username)
+		if !ok || !f.Authenticate(user, password) {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="go-proxy"`)
+			http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+			return
+		}
+	}
+
+	key := f.affinityKey(r)
+	if r.Method == http.MethodConnect {
+		f.handleConnect(w, r, key)
+		return
+	}
+
+	if !r.URL.IsAbs() {
+		http.Error(w, "this is a proxy; requests must use an absolute URL", http.StatusBadRequest)
+		return
+	}
+
+	outgoing := r.Clone(r.Context())
+	outgoing.RequestURI = ""
+	for _, header := range hopHeaders {
+		outgoing.Header.Del(header)
+	}
+
+	resp, err := f.Transport.roundTrip(outgoing, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, header := range hopHeaders {
+		resp.Header.Del(header)
+	}
+	for name, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// affinityKey returns the affinity key of a client request
+func (f *ForwardProxy) affinityKey(r *http.Request) string {
+	if f.Transport.Affinity == nil {
+		return ""
+	}
+	if f.AffinityKey == nil {
+		return FirstAffinity(UsernameSessionAffinity(), HeaderAffinity(SessionHeader))(r)
+	}
+	return f.AffinityKey(r)
+}
+
+// handleConnect opens a tunnel to r.Host through an upstream proxy, failing
+// over to another proxy if the tunnel cannot be opened, or connecting
+// directly when the host's rule falls back to FallbackDirect
+func (f *ForwardProxy) handleConnect(w http.ResponseWriter, r *http.Request, key string) {
+	host := r.URL.Hostname()
+	tried := make(map[string]bool)
+
+	var upstream net.Conn
+	var upstreamReader *bufio.Reader
+	var lastErr error
+	for attempt := 1; attempt <= f.Transport.maxAttempts(); attempt++ {
+		proxy, ok := f.Transport.selectProxy(key, host, tried)
+		if !ok {
+			if f.Transport.Pool.Fallback(host) == FallbackDirect {
+				conn, err := (&net.Dialer{Timeout: f.DialTimeout}).DialContext(r.Context(), "tcp", r.Host)
+				if err != nil {
+					lastErr = fmt.Errorf("direct connection to %s: %v", r.Host, err)
+				} else {
+					upstream, upstreamReader = conn, bufio.NewReader(conn)
+				}
+			}
+			break
+		}
+		tried[proxy.ID()] = true
+
+		conn, reader, err := f.dialTunnel(r.Context(), proxy, r.Host)
+		if err == nil {
+			upstream, upstreamReader = conn, reader
+			break
+		}
+		f.Transport.Pool.MarkFailed(proxy, host)
+		lastErr = err
+	}
+
+	if upstream == nil {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("%w for %s", ErrNoHealthyProxy, host)
+		}
+		http.Error(w, lastErr.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection cannot be hijacked", http.StatusInternalServerError)
+		return
+	}
+	client, clientBuffer, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer client.Close()
+
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+
+	// Copy in both directions until either side closes
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, clientBuffer)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, upstreamReader)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// dialTunnel connects to proxy and asks it to CONNECT to target. The returned
+// reader must be used for reading, as it may hold buffered tunnel data.
+func (f *ForwardProxy) dialTunnel(ctx context.Context, proxy Proxy, target string) (net.Conn, *bufio.Reader, error) {
+	proxyURL, err := proxy.URL()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid proxy %s: %v", proxy.Key(), err)
+	}
+	if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" {
+		return nil, nil, fmt.Errorf("proxy %s: cannot tunnel through a %s proxy, only http and https", proxy.Key(), proxyURL.Scheme)
+	}
+
+	dialer := &net.Dialer{Timeout: f.DialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("proxyconnect %s: %v", proxy.Key(), err)
+	}
+	if proxyURL.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+	}
+	if f.DialTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(f.DialTimeout))
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("proxyconnect %s: %v", proxy.Key(), err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("proxyconnect %s: %v", proxy.Key(), err)
+	}
+	// The body of a successful CONNECT response is the tunnel itself
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, nil, fmt.Errorf("proxy %s refused tunnel: %s", proxy.Key(), resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, reader, nil
+}
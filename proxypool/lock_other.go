@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package proxypool
+
+import "os"
+
+// Advisory locks are not available here, so cache access is not serialised
+// between processes; writes are still atomic.
+
+func lockFile(file *os.File, exclusive bool) error { return nil }
+
+func unlockFile(file *os.File) error { return nil }
@@ -0,0 +1,96 @@
+package proxypool
+
+import (
+	"slices"
+	"strings"
+)
+
+// Listing is the list of proxies one provider returned
+type Listing struct {
+	Source  string // provider name added to each proxy's Sources; empty adds none
+	Proxies []Proxy
+}
+
+// Conflict records a listing that reported a different country for a proxy
+// than the one kept
+type Conflict struct {
+	Proxy   Proxy  // the merged proxy, with the country code that was kept
+	Source  string // the listing whose country code was dropped
+	Country string // the dropped country code
+}
+
+// identity is what makes two listed proxies the same proxy: the scheme,
+// http when none is listed, the host, ignoring its case, the port and the
+// credentials. A gateway listed once per user or session is as many proxies.
+type identity struct {
+	scheme   string
+	host     string
+	port     int
+	username string
+	password string
+}
+
+// identityOf returns the identity of p
+func identityOf(p Proxy) identity {
+	scheme := strings.ToLower(p.Scheme)
+	if scheme == "" {
+		scheme = "http"
+	}
+	return identity{
+		scheme:   scheme,
+		host:     strings.ToLower(p.ProxyAddress),
+		port:     p.Port,
+		username: p.Username,
+		password: p.Password,
+	}
+}
+
+// Merge combines listings, given highest priority first, into one list in
+// which every proxy appears once. Proxies are the same when their scheme,
+// host, port and credentials match. The merged proxy takes its fields from
+// the highest-priority listing, its country code from the highest-priority
+// listing that has one, and its Sources from every listing that has it.
+// Proxies keep the order in which they were first seen.
+func Merge(listings ...Listing) ([]Proxy, []Conflict) {
+	var merged []Proxy
+	var conflicts []Conflict
+	index := make(map[identity]int)
+
+	for _, listing := range listings {
+		for _, proxy := range listing.Proxies {
+			sources := slices.Clone(proxy.Sources)
+			if listing.Source != "" {
+				sources = append(sources, listing.Source)
+			}
+
+			id := identityOf(proxy)
+			i, seen := index[id]
+			if !seen {
+				proxy.Sources = nil
+				index[id] = len(merged)
+				merged = append(merged, proxy)
+				i = len(merged) - 1
+			}
+
+			existing := &merged[i]
+			for _, source := range sources {
+				if !slices.Contains(existing.Sources, source) {
+					existing.Sources = append(existing.Sources, source)
+				}
+			}
+			switch {
+			case !seen || proxy.CountryCode == "" || strings.EqualFold(proxy.CountryCode, existing.CountryCode):
+			case existing.CountryCode == "":
+				existing.CountryCode = proxy.CountryCode
+			default:
+				conflicts = append(conflicts, Conflict{Proxy: *existing, Source: listing.Source, Country: proxy.CountryCode})
+			}
+		}
+	}
+
+	// Conflicts hold copies, so give them the final Sources
+	for i := range conflicts {
+		conflicts[i].Proxy = merged[index[identityOf(conflicts[i].Proxy)]]
+	}
+	return merged, conflicts
+}
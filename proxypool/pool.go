@@ -0,0 +1,387 @@
+package proxypool
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"go-proxy/exchanges"
+)
+
+// Default tuning for a new Pool
+const (
+	DefaultConcurrency     = 10
+	DefaultFailureCooldown = time.Minute
+)
+
+// orDiscard returns logger, or a logger that discards everything when it is nil
+func orDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return logger
+}
+
+// probe is a health check covering a set of destination host patterns
+type probe struct {
+	tester exchanges.ExchangeTester
+	hosts  []string
+}
+
+// Pool holds the proxies from a Source and their health per probe. It is
+// safe for concurrent use.
+type Pool struct {
+	// Concurrency caps the number of probe tests in flight during Check
+	Concurrency int
+	// FailureCooldown is how long a proxy reported by MarkFailed is skipped for that host
+	FailureCooldown time.Duration
+	// Logger receives debug records of refreshes, checks and failures; nil is silent
+	Logger *slog.Logger
+
+	source Source
+
+	mutex   sync.RWMutex
+	proxies []Proxy
+	probes  []*probe
+	rules   []*Rule
+	health  map[string]map[string]bool // proxy ID -> probe name -> passed
+	failed  map[string]time.Time       // proxy ID + "|" + host -> skipped until
+	next    map[string]int             // round-robin position per host
+}
+
+// New creates an empty pool backed by source; call Refresh and Check, or Run,
+// to populate it
+func New(source Source) *Pool {
+	return &Pool{
+		Concurrency:     DefaultConcurrency,
+		FailureCooldown: DefaultFailureCooldown,
+		source:          source,
+		health:          make(map[string]map[string]bool),
+		failed:          make(map[string]time.Time),
+		next:            make(map[string]int),
+	}
+}
+
+// AddProbe registers an exchange tester as the health probe for requests to
+// hosts matching any of the patterns. A pattern is an exact host name or
+// "*.example.com", which matches example.com and all of its subdomains.
+func (p *Pool) AddProbe(tester exchanges.ExchangeTester, hostPatterns ...string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.probes = append(p.probes, &probe{tester: tester, hosts: hostPatterns})
+}
+
+// AddRule appends a routing rule. For each request the first rule matching
+// its host decides which proxies are eligible; hosts without a rule fall back
+// to the probes registered with AddProbe.
+func (p *Pool) AddRule(rule Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.rules = append(p.rules, &rule)
+	return nil
+}
+
+// Rules returns the routing rules in the order they are evaluated
+func (p *Pool) Rules() []Rule {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	rules := make([]Rule, 0, len(p.rules))
+	for _, rule := range p.rules {
+		rules = append(rules, *rule)
+	}
+	return rules
+}
+
+// ruleFor returns the first rule matching host; it must be called with the mutex held
+func (p *Pool) ruleFor(host string) *Rule {
+	for _, rule := range p.rules {
+		if rule.Matches(host) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Fallback returns the fallback that applies to host when no eligible proxy
+// is available. Hosts without a rule fail closed.
+func (p *Pool) Fallback(host string) Fallback {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if rule := p.ruleFor(host); rule != nil {
+		return rule.fallback()
+	}
+	return FallbackFailClosed
+}
+
+// Eligible returns the proxies that currently satisfy rule, ignoring its
+// fallback and any MarkFailed cooldown
+func (p *Pool) Eligible(rule Rule) []Proxy {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	var eligible []Proxy
+	for _, proxy := range p.proxies {
+		if rule.allowsCountry(proxy.CountryCode) && rule.passed(p.health[proxy.ID()]) {
+			eligible = append(eligible, proxy)
+		}
+	}
+	return eligible
+}
+
+// Refresh replaces the pool's proxies with the current list from the source.
+// Health results are kept for proxies that remain in the pool.
+func (p *Pool) Refresh(ctx context.Context) error {
+	proxies, err := p.source.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.proxies = proxies
+	current := make(map[string]bool, len(proxies))
+	for _, proxy := range proxies {
+		current[proxy.ID()] = true
+	}
+	for key := range p.health {
+		if !current[key] {
+			delete(p.health, key)
+		}
+	}
+	now := time.Now()
+	for key, until := range p.failed {
+		if now.After(until) {
+			delete(p.failed, key)
+		}
+	}
+	orDiscard(p.Logger).Debug("pool refreshed", "proxies", len(proxies))
+	return nil
+}
+
+// Check runs every probe against every proxy and records the results
+func (p *Pool) Check(ctx context.Context) error {
+	p.mutex.RLock()
+	proxies := append([]Proxy(nil), p.proxies...)
+	probes := append([]*probe(nil), p.probes...)
+	p.mutex.RUnlock()
+
+	concurrency := p.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	semaphore := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for _, pr := range probes {
+		for _, proxy := range proxies {
+			wg.Add(1)
+			go func(tester exchanges.ExchangeTester, proxy Proxy) {
+				defer wg.Done()
+				select {
+				case semaphore <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+				defer func() { <-semaphore }()
+
+				result, err := TestProxy(ctx, tester, proxy)
+				p.SetHealth(proxy, tester.GetName(), err == nil && result != nil && result.Success)
+			}(pr.tester, proxy)
+		}
+	}
+	wg.Wait()
+
+	orDiscard(p.Logger).Debug("pool checked", "proxies", len(proxies), "probes", len(probes))
+	return ctx.Err()
+}
+
+// SetHealth records the result of a probe run outside of Check, for callers
+// that test the pool themselves. probeName is the tester's GetName().
+func (p *Pool) SetHealth(proxy Proxy, probeName string, passed bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	health, exists := p.health[proxy.ID()]
+	if !exists {
+		health = make(map[string]bool)
+		p.health[proxy.ID()] = health
+	}
+	health[probeName] = passed
+}
+
+// Run refreshes and checks the pool immediately and then every interval
+// until ctx is cancelled. Errors from a round are passed to onError, if set,
+// and do not stop the loop.
+func (p *Pool) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := p.Refresh(ctx)
+		if err == nil {
+			err = p.Check(ctx)
+		}
+		if err != nil && onError != nil && !errors.Is(err, context.Canceled) {
+			onError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Proxies returns every proxy currently in the pool
+func (p *Pool) Proxies() []Proxy {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return append([]Proxy(nil), p.proxies...)
+}
+
+// Healthy returns the proxies usable for requests to host, leaving out those
+// cooling down after MarkFailed. When a rule matches host, these are the
+// proxies allowed by the rule, widened to untested ones for FallbackAny.
+// Otherwise they are the proxies that passed every probe covering host, or
+// every proxy when no probe covers it.
+func (p *Pool) Healthy(host string) []Proxy {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.healthyLocked(host)
+}
+
+// healthyLocked must be called with the mutex held
+func (p *Pool) healthyLocked(host string) []Proxy {
+	if rule := p.ruleFor(host); rule != nil {
+		return p.ruleHealthyLocked(rule, host)
+	}
+
+	var probeNames []string
+	for _, pr := range p.probes {
+		if matchesAnyHost(pr.hosts, host) {
+			probeNames = append(probeNames, pr.tester.GetName())
+		}
+	}
+
+	now := time.Now()
+	var healthy []Proxy
+	for _, proxy := range p.proxies {
+		if until, failed := p.failed[proxy.ID()+"|"+host]; failed && now.Before(until) {
+			continue
+		}
+		passed := true
+		for _, name := range probeNames {
+			if !p.health[proxy.ID()][name] {
+				passed = false
+				break
+			}
+		}
+		if passed {
+			healthy = append(healthy, proxy)
+		}
+	}
+	return healthy
+}
+
+// ruleHealthyLocked applies rule to the pool; it must be called with the mutex held
+func (p *Pool) ruleHealthyLocked(rule *Rule, host string) []Proxy {
+	now := time.Now()
+	var healthy, others []Proxy
+	for _, proxy := range p.proxies {
+		if until, failed := p.failed[proxy.ID()+"|"+host]; failed && now.Before(until) {
+			continue
+		}
+		if !rule.allowsCountry(proxy.CountryCode) {
+			continue
+		}
+		if rule.passed(p.health[proxy.ID()]) {
+			healthy = append(healthy, proxy)
+		} else {
+			others = append(others, proxy)
+		}
+	}
+
+	if len(healthy) == 0 && rule.fallback() == FallbackAny {
+		return others
+	}
+	return healthy
+}
+
+// isHealthy reports whether proxy is currently usable for host
+func (p *Pool) isHealthy(proxy Proxy, host string) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	for _, healthy := range p.healthyLocked(host) {
+		if healthy.ID() == proxy.ID() {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the next healthy proxy for host in round-robin order, skipping
+// any proxy whose ID is in exclude. It returns false when no proxy is
+// available.
+func (p *Pool) Next(host string, exclude map[string]bool) (Proxy, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	healthy := p.healthyLocked(host)
+	for i := 0; i < len(healthy); i++ {
+		position := p.next[host] % len(healthy)
+		p.next[host] = position + 1
+		if !exclude[healthy[position].ID()] {
+			return healthy[position], true
+		}
+	}
+	return Proxy{}, false
+}
+
+// MarkFailed takes a proxy out of rotation for host for the failure cooldown
+func (p *Pool) MarkFailed(proxy Proxy, host string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	cooldown := p.FailureCooldown
+	if cooldown <= 0 {
+		cooldown = DefaultFailureCooldown
+	}
+	p.failed[proxy.ID()+"|"+host] = time.Now().Add(cooldown)
+	orDiscard(p.Logger).Debug("proxy marked failed", "proxy", proxy.ID(), "host", host, "cooldown", cooldown)
+}
+
+// matchesAnyHost reports whether host matches any of the patterns
+func matchesAnyHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if MatchHost(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchHost reports whether host matches pattern. "*.example.com" matches
+// example.com and every subdomain; other patterns use path.Match syntax.
+func MatchHost(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	host = strings.ToLower(host)
+
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return host == suffix || strings.HasSuffix(host, "."+suffix)
+	}
+	matched, err := path.Match(pattern, host)
+	return err == nil && matched
+}
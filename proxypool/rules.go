@@ -0,0 +1,118 @@
+package proxypool
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Fallback decides what happens to a request when no tested proxy matches its rule
+type Fallback string
+
+const (
+	// FallbackFailClosed rejects the request with ErrNoHealthyProxy
+	FallbackFailClosed Fallback = "fail-closed"
+	// FallbackAny uses any proxy allowed by the rule's country filters, tested or not
+	FallbackAny Fallback = "any"
+	// FallbackDirect sends the request without a proxy
+	FallbackDirect Fallback = "direct"
+)
+
+// Rule routes requests for a set of destination hosts through the proxies
+// that passed one exchange tester, optionally restricted by country
+type Rule struct {
+	// Hosts are host patterns as accepted by MatchHost
+	Hosts []string `json:"hosts"`
+	// Exchange is the registry name of the tester whose results select the
+	// proxies. Empty means every proxy is eligible.
+	Exchange string `json:"exchange,omitempty"`
+	// Countries, if set, lists the only country codes allowed
+	Countries []string `json:"countries,omitempty"`
+	// ExcludeCountries lists country codes that are never used
+	ExcludeCountries []string `json:"exclude_countries,omitempty"`
+	// Fallback applies when no eligible proxy is available; defaults to FallbackFailClosed
+	Fallback Fallback `json:"fallback,omitempty"`
+}
+
+// Matches reports whether the rule covers host
+func (r *Rule) Matches(host string) bool {
+	return matchesAnyHost(r.Hosts, host)
+}
+
+// Validate checks the rule's hosts and fallback
+func (r *Rule) Validate() error {
+	if len(r.Hosts) == 0 {
+		return fmt.Errorf("rule has no hosts")
+	}
+	for _, host := range r.Hosts {
+		if strings.TrimSpace(host) == "" {
+			return fmt.Errorf("rule has an empty host pattern")
+		}
+	}
+	switch r.Fallback {
+	case "", FallbackFailClosed, FallbackAny, FallbackDirect:
+		return nil
+	}
+	return fmt.Errorf("rule for %s has unknown fallback %q (want %s, %s or %s)",
+		strings.Join(r.Hosts, ","), r.Fallback, FallbackFailClosed, FallbackAny, FallbackDirect)
+}
+
+// fallback returns the effective fallback
+func (r *Rule) fallback() Fallback {
+	if r.Fallback == "" {
+		return FallbackFailClosed
+	}
+	return r.Fallback
+}
+
+// allowsCountry reports whether the country filters admit code
+func (r *Rule) allowsCountry(code string) bool {
+	for _, excluded := range r.ExcludeCountries {
+		if strings.EqualFold(excluded, code) {
+			return false
+		}
+	}
+	if len(r.Countries) == 0 {
+		return true
+	}
+	for _, allowed := range r.Countries {
+		if strings.EqualFold(allowed, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// passed reports whether health satisfies the rule's exchange. Health is
+// keyed by tester name, which matches the registry name case-insensitively.
+func (r *Rule) passed(health map[string]bool) bool {
+	if r.Exchange == "" {
+		return true
+	}
+	for name, passed := range health {
+		if strings.EqualFold(name, r.Exchange) {
+			return passed
+		}
+	}
+	return false
+}
+
+// LoadRules reads a JSON array of rules from path and validates them
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %v", path, err)
+	}
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+	}
+	return rules, nil
+}
@@ -0,0 +1,172 @@
+package proxypool
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRuleValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		want string // empty for a valid rule
+	}{
+		{"minimal", Rule{Hosts: []string{"*.binance.com"}}, ""},
+		{"direct fallback", Rule{Hosts: []string{"a.example"}, Fallback: FallbackDirect}, ""},
+		{"no hosts", Rule{}, "no hosts"},
+		{"blank host", Rule{Hosts: []string{"a.example", " "}}, "empty host pattern"},
+		{"unknown fallback", Rule{Hosts: []string{"a.example"}, Fallback: "retry"}, `unknown fallback "retry"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.rule.Validate()
+			if test.want == "" && err != nil {
+				t.Errorf("Validate() error: %v", err)
+			}
+			if test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+				t.Errorf("Validate() error = %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string // error substring; empty to expect success
+	}{
+		{"valid", `[{"hosts":["*.binance.com"],"exchange":"binance","countries":["DE"],"fallback":"any"}]`, ""},
+		{"not JSON", `hosts: ["*.binance.com"]`, "invalid rules file"},
+		{"invalid rule", `[{"hosts":["a.example"]},{"hosts":[]}]`, "rule 2: rule has no hosts"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.json")
+			if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			rules, err := LoadRules(path)
+			if test.want == "" {
+				if err != nil || len(rules) != 1 || rules[0].Exchange != "binance" {
+					t.Errorf("LoadRules() = %+v, %v; want the binance rule", rules, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("LoadRules() error = %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+
+	if _, err := LoadRules(filepath.Join(t.TempDir(), "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("LoadRules() of a missing file error = %v, want not exist", err)
+	}
+}
+
+func TestPoolRules(t *testing.T) {
+	de := Proxy{ProxyAddress: "192.0.2.1", Port: 1, CountryCode: "DE"}
+	fr := Proxy{ProxyAddress: "192.0.2.2", Port: 1, CountryCode: "FR"}
+	us := Proxy{ProxyAddress: "192.0.2.3", Port: 1, CountryCode: "us"}
+
+	tests := []struct {
+		name     string
+		rule     Rule
+		passed   []Proxy // proxies that passed the Binance tester
+		healthy  []Proxy
+		fallback Fallback
+	}{
+		{"exchange", Rule{Exchange: "binance"}, []Proxy{de, us}, []Proxy{de, us}, FallbackFailClosed},
+		{"any exchange", Rule{}, nil, []Proxy{de, fr, us}, FallbackFailClosed},
+		{"countries", Rule{Exchange: "binance", Countries: []string{"US", "fr"}}, []Proxy{de, fr, us}, []Proxy{fr, us}, FallbackFailClosed},
+		{"excluded countries", Rule{Exchange: "binance", ExcludeCountries: []string{"de"}}, []Proxy{de, fr}, []Proxy{fr}, FallbackFailClosed},
+		{"fail closed", Rule{Exchange: "binance", Fallback: FallbackFailClosed}, nil, nil, FallbackFailClosed},
+		{"fallback to any", Rule{Exchange: "binance", Countries: []string{"DE", "FR"}, Fallback: FallbackAny}, nil, []Proxy{de, fr}, FallbackAny},
+		{"any only when none passed", Rule{Exchange: "binance", Fallback: FallbackAny}, []Proxy{fr}, []Proxy{fr}, FallbackAny},
+		{"direct", Rule{Exchange: "binance", Fallback: FallbackDirect}, nil, nil, FallbackDirect},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := newTestPool(t, de, fr, us)
+			test.rule.Hosts = []string{"*.binance.com"}
+			if err := pool.AddRule(test.rule); err != nil {
+				t.Fatal(err)
+			}
+			for _, proxy := range []Proxy{de, fr, us} {
+				pool.SetHealth(proxy, "Binance", slices.ContainsFunc(test.passed, func(p Proxy) bool { return p.Key() == proxy.Key() }))
+			}
+
+			if got := keys(pool.Healthy("api.binance.com")); !slices.Equal(got, keys(test.healthy)) {
+				t.Errorf("Healthy() = %v, want %v", got, keys(test.healthy))
+			}
+			if got := pool.Fallback("api.binance.com"); got != test.fallback {
+				t.Errorf("Fallback() = %s, want %s", got, test.fallback)
+			}
+			// Hosts without a rule are not restricted by it
+			if got := pool.Healthy("example.com"); len(got) != 3 {
+				t.Errorf("Healthy() of an unruled host = %v, want every proxy", keys(got))
+			}
+		})
+	}
+}
+
+func TestPoolRulesFirstMatchWins(t *testing.T) {
+	de := Proxy{ProxyAddress: "192.0.2.1", Port: 1, CountryCode: "DE"}
+	fr := Proxy{ProxyAddress: "192.0.2.2", Port: 1, CountryCode: "FR"}
+	pool := newTestPool(t, de, fr)
+	pool.AddRule(Rule{Hosts: []string{"fapi.binance.com"}, Countries: []string{"FR"}})
+	pool.AddRule(Rule{Hosts: []string{"*.binance.com"}, Countries: []string{"DE"}})
+	if err := pool.AddRule(Rule{Hosts: []string{"x.example"}, Fallback: "retry"}); err == nil {
+		t.Error("AddRule() accepted an invalid rule")
+	}
+
+	if got := keys(pool.Healthy("fapi.binance.com")); !slices.Equal(got, []string{fr.Key()}) {
+		t.Errorf("Healthy(fapi.binance.com) = %v, want the first rule's FR proxy", got)
+	}
+	if got := keys(pool.Healthy("api.binance.com")); !slices.Equal(got, []string{de.Key()}) {
+		t.Errorf("Healthy(api.binance.com) = %v, want the second rule's DE proxy", got)
+	}
+	if rules := pool.Rules(); len(rules) != 2 || rules[0].Hosts[0] != "fapi.binance.com" {
+		t.Errorf("Rules() = %+v, want the two valid rules in order", rules)
+	}
+
+	// Eligible ignores the failure cooldown
+	pool.MarkFailed(de, "api.binance.com")
+	if got := pool.Healthy("api.binance.com"); len(got) != 0 {
+		t.Errorf("Healthy() = %v, want the cooling down proxy left out", keys(got))
+	}
+	if got := keys(pool.Eligible(pool.Rules()[1])); !slices.Equal(got, []string{de.Key()}) {
+		t.Errorf("Eligible() = %v, want the DE proxy", got)
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"*.binance.com", "binance.com", true},
+		{"*.binance.com", "api.BINANCE.com", true},
+		{"*.binance.com", "notbinance.com", false},
+		{"api.binance.com", "api.binance.com", true},
+		{"api?.binance.com", "api3.binance.com", true},
+		{"api.binance.com", "fapi.binance.com", false},
+	}
+	for _, test := range tests {
+		if got := MatchHost(test.pattern, test.host); got != test.want {
+			t.Errorf("MatchHost(%q, %q) = %t, want %t", test.pattern, test.host, got, test.want)
+		}
+	}
+}
+
+// keys returns the keys of proxies, in order
+func keys(proxies []Proxy) []string {
+	var keys []string
+	for _, proxy := range proxies {
+		keys = append(keys, proxy.Key())
+	}
+	return keys
+}
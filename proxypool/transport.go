@@ -0,0 +1,186 @@
+package proxypool
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go-proxy/exchanges"
+)
+
+// DefaultMaxAttempts is how many proxies a RoundTripper tries per request
+const DefaultMaxAttempts = 3
+
+// ErrNoHealthyProxy is returned when the pool has no usable proxy for a host
+var ErrNoHealthyProxy = errors.New("proxypool: no healthy proxy available")
+
+// RoundTripper sends each request through a healthy proxy for the request's
+// host. When a proxy fails at the transport level, or rejects the proxy
+// credentials, it is marked failed and the request is retried through
+// another proxy, as long as the request body can be replayed. Requests with
+// non-idempotent methods are only retried when the proxy failed before the
+// request could reach the destination, so an order is never sent twice.
+// When no proxy is available and the host's rule falls back to
+// FallbackDirect, the request is sent without a proxy.
+type RoundTripper struct {
+	Pool        *Pool
+	MaxAttempts int // defaults to DefaultMaxAttempts
+
+	// Affinity, if set, pins requests that share an affinity key to one proxy
+	Affinity *AffinityTable
+	// AffinityKey extracts the key of a request; defaults to ContextAffinity
+	AffinityKey AffinityKeyFunc
+
+	transports *exchanges.TransportPool
+	direct     *http.Transport
+}
+
+// NewRoundTripper creates a RoundTripper that routes through pool
+func NewRoundTripper(pool *Pool) *RoundTripper {
+	return &RoundTripper{
+		Pool:       pool,
+		transports: exchanges.NewTransportPool(),
+		direct:     newDirectTransport(),
+	}
+}
+
+// newDirectTransport returns a transport that never uses a proxy
+func newDirectTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	return transport
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.roundTrip(req, t.affinityKey(req))
+}
+
+// affinityKey returns the affinity key of req, or "" when affinity is off
+func (t *RoundTripper) affinityKey(req *http.Request) string {
+	if t.Affinity == nil {
+		return ""
+	}
+	if t.AffinityKey == nil {
+		return ContextAffinity()(req)
+	}
+	return t.AffinityKey(req)
+}
+
+// selectProxy picks the proxy for host, honouring the affinity key if set
+func (t *RoundTripper) selectProxy(key, host string, exclude map[string]bool) (Proxy, bool) {
+	if key != "" && t.Affinity != nil {
+		return t.Affinity.Select(t.Pool, key, host, exclude)
+	}
+	return t.Pool.Next(host, exclude)
+}
+
+// maxAttempts returns the configured attempt limit
+func (t *RoundTripper) maxAttempts() int {
+	if t.MaxAttempts <= 0 {
+		return DefaultMaxAttempts
+	}
+	return t.MaxAttempts
+}
+
+// roundTrip sends req with an already extracted affinity key
+func (t *RoundTripper) roundTrip(req *http.Request, key string) (*http.Response, error) {
+	maxAttempts := t.maxAttempts()
+	// A body that cannot be rewound allows a single attempt only
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		maxAttempts = 1
+	}
+
+	host := req.URL.Hostname()
+	tried := make(map[string]bool)
+	var lastErr error
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		proxy, ok := t.selectProxy(key, host, tried)
+		if !ok {
+			if t.Pool.Fallback(host) == FallbackDirect {
+				return t.sendDirect(req, attempt)
+			}
+			break
+		}
+		tried[proxy.ID()] = true
+
+		resp, err := t.send(req, proxy, attempt)
+		if err == nil && resp.StatusCode != http.StatusProxyAuthRequired {
+			return resp, nil
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("proxy %s: Proxy Authentication Required", proxy.Key())
+		}
+
+		t.Pool.MarkFailed(proxy, host)
+		lastErr = err
+		if req.Context().Err() != nil || !retryable(req, err) {
+			return nil, lastErr
+		}
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("%w for %s", ErrNoHealthyProxy, host)
+}
+
+// retryable reports whether a failed request may be sent again through another proxy
+func retryable(req *http.Request, err error) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	// The request never left the proxy when the tunnel could not be opened
+	switch exchanges.ClassifyRequestError(err) {
+	case exchanges.FailureProxyConnect, exchanges.FailureProxyAuth:
+		return true
+	}
+	return false
+}
+
+// send performs one attempt through proxy
+func (t *RoundTripper) send(req *http.Request, proxy Proxy, attempt int) (*http.Response, error) {
+	proxyURL, err := proxy.URL()
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %s: %v", proxy.Key(), err)
+	}
+
+	outgoing, err := rewind(req, attempt)
+	if err != nil {
+		return nil, err
+	}
+	return t.transports.Get(proxyURL).RoundTrip(outgoing)
+}
+
+// sendDirect performs one attempt without a proxy
+func (t *RoundTripper) sendDirect(req *http.Request, attempt int) (*http.Response, error) {
+	outgoing, err := rewind(req, attempt)
+	if err != nil {
+		return nil, err
+	}
+	return t.direct.RoundTrip(outgoing)
+}
+
+// rewind returns req with a fresh body for attempts after the first
+func rewind(req *http.Request, attempt int) (*http.Request, error) {
+	if attempt == 1 || req.GetBody == nil {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	outgoing := req.Clone(req.Context())
+	outgoing.Body = body
+	return outgoing, nil
+}
+
+// CloseIdleConnections closes idle connections to every proxy
+func (t *RoundTripper) CloseIdleConnections() {
+	t.transports.CloseIdleConnections()
+	t.direct.CloseIdleConnections()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go-proxy/exchanges"
+)
+
+func TestTokenBucketPacing(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		waits int
+		min   time.Duration // the waits beyond the burst, at rate
+	}{
+		{"within burst", 100, 5, 5, 0},
+		{"beyond burst", 200, 2, 12, 50 * time.Millisecond},
+		{"burst below one", 200, 0, 11, 50 * time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bucket := newTokenBucket(test.rate, test.burst)
+			start := time.Now()
+			for range test.waits {
+				bucket.Wait()
+			}
+			elapsed := time.Since(start)
+			if elapsed < test.min-5*time.Millisecond {
+				t.Errorf("%d waits took %s, want at least %s", test.waits, elapsed, test.min)
+			}
+			if elapsed > test.min+500*time.Millisecond {
+				t.Errorf("%d waits took %s, want about %s", test.waits, elapsed, test.min)
+			}
+		})
+	}
+}
+
+func TestTokenBucketRefills(t *testing.T) {
+	bucket := newTokenBucket(1000, 3)
+	for range 3 {
+		bucket.Wait()
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// Refilled past the burst, the bucket holds only the burst
+	start := time.Now()
+	for range 3 {
+		bucket.Wait()
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("waits on a refilled bucket took %s", elapsed)
+	}
+	if bucket.tokens >= 1 {
+		t.Errorf("tokens = %g after taking the burst, want less than 1", bucket.tokens)
+	}
+}
+
+func TestNewFanOutLimits(t *testing.T) {
+	registry := exchanges.NewRegistry()
+	binance, _ := registry.Get("binance")
+	coinbase, _ := registry.Get("coinbase")
+	testers := []exchanges.ExchangeTester{binance, coinbase}
+
+	tests := []struct {
+		name  string
+		test  testConfig
+		rates map[string]rateLimit
+	}{
+		{"unlimited", testConfig{Burst: 1}, map[string]rateLimit{}},
+		{
+			"shared rate",
+			testConfig{Rate: 5, Burst: 2},
+			map[string]rateLimit{binance.GetName(): {5, 2}, coinbase.GetName(): {5, 2}},
+		},
+		{
+			"per exchange override",
+			testConfig{Rate: 5, Burst: 2, Exchanges: map[string]exchangeConfig{"binance": {Rate: 1, Burst: 4}}},
+			map[string]rateLimit{binance.GetName(): {1, 4}, coinbase.GetName(): {5, 2}},
+		},
+		{
+			"only one exchange limited",
+			testConfig{Burst: 1, Exchanges: map[string]exchangeConfig{"coinbase": {Rate: 3}}},
+			map[string]rateLimit{coinbase.GetName(): {3, 1}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limits := newFanOutLimits(test.test, testers)
+			if len(limits.rates) != len(test.rates) {
+				t.Fatalf("rates = %v, want %v", limits.rates, test.rates)
+			}
+			for name, want := range test.rates {
+				if got := limits.rates[name]; got != want {
+					t.Errorf("rate of %s = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
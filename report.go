@@ -0,0 +1,300 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go-proxy/exchanges"
+)
+
+// Accepted values of the test report flags
+var (
+	reportSortKeys  = []string{"latency", "country", "exchange", "proxy"}
+	reportGroupKeys = []string{"exchange", "country", "proxy", "family"}
+)
+
+// Report formats accepted by --report
+var reportFormats = []string{"html", "junit"}
+
+// reportTargets is the value of the repeatable --report flag: files to
+// write, keyed by format. The flag takes the format and the file as two
+// arguments, "--report html report.html", which the command line parser
+// hands over joined as "html=report.html"; that form is accepted too.
+type reportTargets map[string]string
+
+func (r reportTargets) String() string {
+	var targets []string
+	for _, format := range reportFormats {
+		if file, set := r[format]; set {
+			targets = append(targets, format+"="+file)
+		}
+	}
+	return strings.Join(targets, ",")
+}
+
+func (r reportTargets) Set(value string) error {
+	format, file, _ := strings.Cut(value, "=")
+	if !slices.Contains(reportFormats, format) {
+		return fmt.Errorf("unsupported report format %q: must be one of %s", format, strings.Join(reportFormats, ", "))
+	}
+	if file == "" {
+		return fmt.Errorf("missing file after the format, e.g. --report %s report.%s", format, format)
+	}
+	r[format] = file
+	return nil
+}
+
+// secondArg names the file, the second argument of --report
+func (r reportTargets) secondArg() string { return "file" }
+
+// compareProxy orders results by proxy: IP addresses before host names, IP
+// addresses numerically and host names lexically, then by port
+func compareProxy(a, b *exchanges.TestResult) int {
+	addrA, errA := netip.ParseAddr(a.ProxyAddress)
+	addrB, errB := netip.ParseAddr(b.ProxyAddress)
+	var c int
+	switch {
+	case errA == nil && errB == nil:
+		c = addrA.Compare(addrB)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	default:
+		c = strings.Compare(a.ProxyAddress, b.ProxyAddress)
+	}
+	return cmp.Or(c, cmp.Compare(a.Port, b.Port))
+}
+
+// sortResults orders results by the given key, keeping completion order
+// when the key is empty. Failed tests have no meaningful latency, so they
+// sort after successful ones by latency.
+func sortResults(results []*exchanges.TestResult, by string) {
+	var compare func(a, b *exchanges.TestResult) int
+	switch by {
+	case "latency":
+		compare = func(a, b *exchanges.TestResult) int {
+			if a.Success != b.Success {
+				if a.Success {
+					return -1
+				}
+				return 1
+			}
+			return cmp.Compare(a.ResponseTime, b.ResponseTime)
+		}
+	case "country":
+		compare = func(a, b *exchanges.TestResult) int {
+			return cmp.Or(cmp.Compare(a.CountryCode, b.CountryCode), compareProxy(a, b), cmp.Compare(a.Exchange, b.Exchange))
+		}
+	case "exchange":
+		compare = func(a, b *exchanges.TestResult) int {
+			return cmp.Or(cmp.Compare(a.Exchange, b.Exchange), compareProxy(a, b))
+		}
+	case "proxy":
+		compare = func(a, b *exchanges.TestResult) int {
+			return cmp.Or(compareProxy(a, b), cmp.Compare(a.Exchange, b.Exchange))
+		}
+	default:
+		return
+	}
+	slices.SortStableFunc(results, compare)
+}
+
+// groupKey returns the value a result is grouped by
+func groupKey(result *exchanges.TestResult, by string) string {
+	switch by {
+	case "exchange":
+		return result.Exchange
+	case "country":
+		if result.CountryCode == "" {
+			return "unknown"
+		}
+		return result.CountryCode
+	case "proxy":
+		return exchanges.ProxyHostPort(result.ProxyAddress, result.Port)
+	case "family":
+		if result.Family == "" {
+			return "unknown"
+		}
+		return result.Family
+	}
+	return ""
+}
+
+// printFamilyStats prints to out the success rate and cold latency of the tests
+// over each address family, IPv4 first. Tests that never reached a proxy
+// given by host name have no family and are counted as unknown.
+func printFamilyStats(out io.Writer, results []*exchanges.TestResult) {
+	_, groups := groupResults(results, "family")
+	table := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(table, "Family\tTests\tPassed\tSuccess\tAvg Cold\tMedian Cold")
+	for _, family := range []string{exchanges.FamilyIPv4, exchanges.FamilyIPv6, "unknown"} {
+		group := groups[family]
+		if len(group) == 0 {
+			continue
+		}
+		var passed []*exchanges.TestResult
+		for _, result := range group {
+			if result.Success {
+				passed = append(passed, result)
+			}
+		}
+		avg, median := "-", "-"
+		if len(passed) > 0 {
+			coldTimes, _ := collectResponseTimes(passed)
+			_, _, avgTime, medianTime := calculateResponseTimeStats(coldTimes)
+			avg, median = avgTime.String(), medianTime.String()
+		}
+		fmt.Fprintf(table, "%s\t%d\t%d\t%.1f%%\t%s\t%s\n",
+			family, len(group), len(passed), percent(len(passed), len(group)), avg, median)
+	}
+	table.Flush()
+}
+
+// groupResults splits results into groups in order of first appearance, so
+// the groups follow the sort order
+func groupResults(results []*exchanges.TestResult, by string) ([]string, map[string][]*exchanges.TestResult) {
+	var keys []string
+	groups := make(map[string][]*exchanges.TestResult)
+	for _, result := range results {
+		key := groupKey(result, by)
+		if _, exists := groups[key]; !exists {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], result)
+	}
+	return keys, groups
+}
+
+// printGrouped prints results with printTable, once per group when by is set
+func printGrouped(out io.Writer, results []*exchanges.TestResult, by string, printTable func(io.Writer, []*exchanges.TestResult)) {
+	if by == "" {
+		printTable(out, results)
+		return
+	}
+	keys, groups := groupResults(results, by)
+	for i, key := range keys {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintf(out, "--- %s: %s (%d) ---\n", by, key, len(groups[key]))
+		printTable(out, groups[key])
+	}
+}
+
+// printSuccessTable prints successful results with their latencies
+func printSuccessTable(out io.Writer, results []*exchanges.TestResult) {
+	width := resultsAddressWidth(results)
+	fmt.Fprintf(out, "%-12s %-*s %-6s %-8s %-15s %-15s %s\n", "Exchange", width, "Proxy Address", "Port", "Country", "Cold Time", "Warm Time", "Data")
+	fmt.Fprintln(out, strings.Repeat("-", 91+width)) // Separator line
+	for _, result := range results {
+		fmt.Fprintln(out, formatTableRow(
+			width,
+			result.Exchange,
+			result.ProxyAddress,
+			result.Port,
+			result.CountryCode,
+			result.ResponseTime.String(),
+			formatWarmTime(result.WarmResponseTime),
+			result.Data,
+		))
+	}
+}
+
+// printFailureTable prints failed results with their errors
+func printFailureTable(out io.Writer, results []*exchanges.TestResult) {
+	width := resultsAddressWidth(results)
+	fmt.Fprintf(out, "%-12s %-*s %-6s %-8s %s\n", "Exchange", width, "Proxy Address", "Port", "Country", "Error")
+	fmt.Fprintln(out, strings.Repeat("-", 55+width)) // Separator line
+	for _, result := range results {
+		fmt.Fprintf(out, "%-12s %-*s %-6d %-8s %s\n",
+			result.Exchange,
+			width, result.ProxyAddress,
+			result.Port,
+			result.CountryCode,
+			result.Error)
+	}
+}
+
+// matrixRow is one proxy in the matrix view
+type matrixRow struct {
+	proxy   *exchanges.TestResult // any result of the proxy, for its address and country
+	results map[string]*exchanges.TestResult
+	passed  int
+	latency time.Duration // mean latency of the passed tests
+}
+
+// printResultMatrix prints one row per proxy and one column per exchange,
+// each cell showing whether the test passed and its latency. Rows are in
+// proxy order unless sorted by country or latency.
+func printResultMatrix(out io.Writer, results []*exchanges.TestResult, sortBy string) {
+	var exchangeNames []string
+	var rows []*matrixRow
+	byProxy := make(map[string]*matrixRow)
+	for _, result := range results {
+		if !slices.Contains(exchangeNames, result.Exchange) {
+			exchangeNames = append(exchangeNames, result.Exchange)
+		}
+		key := groupKey(result, "proxy")
+		row, exists := byProxy[key]
+		if !exists {
+			row = &matrixRow{proxy: result, results: make(map[string]*exchanges.TestResult)}
+			byProxy[key] = row
+			rows = append(rows, row)
+		}
+		row.results[result.Exchange] = result
+		if result.Success {
+			row.latency += result.ResponseTime
+			row.passed++
+		}
+	}
+	slices.Sort(exchangeNames)
+	for _, row := range rows {
+		if row.passed > 0 {
+			row.latency /= time.Duration(row.passed)
+		}
+	}
+
+	switch sortBy {
+	case "", "exchange":
+		// Exchanges are the columns, so keep the rows in proxy order
+		slices.SortStableFunc(rows, func(a, b *matrixRow) int { return compareProxy(a.proxy, b.proxy) })
+	case "latency":
+		// Rank proxies by their mean latency, those that passed nothing last
+		slices.SortStableFunc(rows, func(a, b *matrixRow) int {
+			if (a.passed > 0) != (b.passed > 0) {
+				if a.passed > 0 {
+					return -1
+				}
+				return 1
+			}
+			return cmp.Compare(a.latency, b.latency)
+		})
+	}
+
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "Proxy\tCountry\t%s\tPassed\n", strings.Join(exchangeNames, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(exchangeNames))
+		for i, name := range exchangeNames {
+			result, tested := row.results[name]
+			switch {
+			case !tested:
+				cells[i] = "-"
+			case result.Success:
+				cells[i] = "✓ " + result.ResponseTime.Round(time.Millisecond).String()
+			default:
+				cells[i] = "✗"
+			}
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%d/%d\n",
+			groupKey(row.proxy, "proxy"), row.proxy.CountryCode, strings.Join(cells, "\t"), row.passed, len(row.results))
+	}
+	table.Flush()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"go-proxy/exchanges"
+)
+
+func TestSortResultsByProxy(t *testing.T) {
+	// In the expected order
+	ordered := []*exchanges.TestResult{
+		{ProxyAddress: "192.0.2.1", Port: 80},
+		{ProxyAddress: "192.0.2.1", Port: 8080},
+		{ProxyAddress: "192.0.2.10", Port: 80},
+		{ProxyAddress: "2001:db8::1", Port: 80},
+		{ProxyAddress: "2001:db8::a", Port: 80},
+		{ProxyAddress: "0proxy.example", Port: 80},
+		{ProxyAddress: "a.example", Port: 80},
+		{ProxyAddress: "proxy.example", Port: 3128},
+		{ProxyAddress: "proxy.example", Port: 8080},
+	}
+	var want []string
+	for _, result := range ordered {
+		want = append(want, exchanges.ProxyHostPort(result.ProxyAddress, result.Port))
+	}
+
+	// The order is total: every pair compares as its positions do
+	for i, a := range ordered {
+		for j, b := range ordered {
+			if got := compareProxy(a, b); got != cmp.Compare(i, j) {
+				t.Errorf("compareProxy(%s, %s) = %d, want %d", want[i], want[j], got, cmp.Compare(i, j))
+			}
+		}
+	}
+
+	random := rand.New(rand.NewPCG(1, 1))
+	for range 20 {
+		results := slices.Clone(ordered)
+		random.Shuffle(len(results), func(i, j int) { results[i], results[j] = results[j], results[i] })
+		sortResults(results, "proxy")
+
+		var got []string
+		for _, result := range results {
+			got = append(got, exchanges.ProxyHostPort(result.ProxyAddress, result.Port))
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("sorted by proxy = %v, want %v", got, want)
+		}
+	}
+}
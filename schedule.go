@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"go-proxy/notify"
+	"go-proxy/proxypool"
+)
+
+// defaultJobTimeout limits a scheduled run without a timeout of its own
+const defaultJobTimeout = 30 * time.Minute
+
+// testOptions returns the test command options equivalent to the job's
+// selection keys. A zero seed is replaced by a random one.
+func (j jobConfig) testOptions() testOptions {
+	options := testOptions{
+		limit:            j.Limit,
+		exportFile:       j.Export,
+		countries:        j.Country,
+		excludeCountries: j.ExcludeCountry,
+		ports:            j.Port,
+		cidrs:            j.CIDR,
+		onlyFailed:       j.OnlyFailedLastRun,
+		onlyHealthy:      j.OnlyHealthyLastRun,
+		sample:           j.Sample,
+		seed:             j.Seed,
+		stratify:         j.Stratify,
+	}
+	if options.seed == 0 {
+		options.seed = rand.Uint64()
+	}
+	return options
+}
+
+// timeout returns how long one run of the job may take
+func (j jobConfig) timeout() time.Duration {
+	if j.Timeout > 0 {
+		return j.Timeout
+	}
+	return defaultJobTimeout
+}
+
+// scheduledJob is a job with its parsed schedule and next run time
+type scheduledJob struct {
+	jobConfig
+	schedule *cronSchedule
+	next     time.Time
+}
+
+// jobSummary is the outcome of one job run, posted to the job's webhook
+type jobSummary struct {
+	Job       string    `json:"job"`
+	RunID     string    `json:"run_id,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+	Proxies   int       `json:"proxies"`
+	Tests     int       `json:"tests"`
+	Passed    int       `json:"passed"`
+	Failed    int       `json:"failed"`
+	TimedOut  bool      `json:"timed_out,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// handleScheduleCommand runs the configured jobs, or only the named ones, on
+// their cron schedules until interrupted. With once, each job runs
+// immediately, one after another, and the command exits.
+//
+// Runs never overlap: jobs run one at a time in the order they fall due, and
+// a job that fell due while another was running starts right after it. Runs
+// missed entirely are skipped rather than queued.
+func handleScheduleCommand(names []string, once bool) error {
+	jobs, err := selectJobs(appConfig.Schedule.Jobs, names)
+	if err != nil {
+		return err
+	}
+	alerts := newAlertManager(appConfig.Notify)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if once {
+		var errs []error
+		for _, job := range jobs {
+			if err := runJob(ctx, job.jobConfig, alerts); err != nil {
+				errs = append(errs, fmt.Errorf("job %s: %w", job.Name, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	now := time.Now()
+	for _, job := range jobs {
+		job.next = job.schedule.next(now)
+		if job.next.IsZero() {
+			return configErrorf("job %s: cron expression %q never fires", job.Name, job.Cron)
+		}
+		logger.Info("job scheduled", "job", job.Name, "cron", job.Cron, "next", job.next.Format(time.RFC3339))
+	}
+
+	for {
+		due := slices.MinFunc(jobs, func(a, b *scheduledJob) int { return a.next.Compare(b.next) })
+		timer := time.NewTimer(time.Until(due.next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Info("shutting down")
+			return nil
+		case <-timer.C:
+		}
+
+		scheduled := due.next
+		if err := runJob(ctx, due.jobConfig, alerts); err != nil {
+			logger.Error("job failed", "job", due.Name, "error", err)
+		}
+		if ctx.Err() != nil {
+			logger.Info("shutting down")
+			return nil
+		}
+
+		// Other jobs that fell due meanwhile keep their past run time and
+		// start next; this job's own runs that passed meanwhile are skipped
+		now := time.Now()
+		due.next = due.schedule.next(now)
+		if !due.schedule.next(scheduled).After(now) {
+			logger.Warn("skipped runs that fell due while the job was running", "job", due.Name)
+		}
+		logger.Info("job scheduled", "job", due.Name, "next", due.next.Format(time.RFC3339))
+	}
+}
+
+// selectJobs parses the schedules of the named jobs, or of every job when
+// no names are given
+func selectJobs(configured []jobConfig, names []string) ([]*scheduledJob, error) {
+	if len(configured) == 0 {
+		return nil, configErrorf("no jobs configured; add them under schedule.jobs in the config file")
+	}
+
+	var jobs []*scheduledJob
+	var unknown []string
+	for _, name := range names {
+		if !slices.ContainsFunc(configured, func(job jobConfig) bool { return job.Name == name }) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		available := make([]string, len(configured))
+		for i, job := range configured {
+			available[i] = job.Name
+		}
+		return nil, usageErrorf("unknown jobs: %s (available: %s)", strings.Join(unknown, ", "), strings.Join(available, ", "))
+	}
+
+	for _, job := range configured {
+		if len(names) > 0 && !slices.Contains(names, job.Name) {
+			continue
+		}
+		schedule, err := parseCron(job.Cron)
+		if err != nil {
+			return nil, configErrorf("job %s: %v", job.Name, err)
+		}
+		jobs = append(jobs, &scheduledJob{jobConfig: job, schedule: schedule})
+	}
+	return jobs, nil
+}
+
+// runJob runs one job to completion or until its timeout, saves the results
+// to history and the job's export file, checks the alert rules, and posts a
+// summary to its webhook. Tests still running at the timeout finish; the rest
+// are skipped.
+func runJob(ctx context.Context, job jobConfig, alerts *alertManager) error {
+	ctx, cancel := context.WithTimeout(ctx, job.timeout())
+	defer cancel()
+
+	startedAt := time.Now()
+	summary := jobSummary{Job: job.Name, StartedAt: startedAt}
+	logger.Info("job started", "job", job.Name)
+
+	err := runJobTests(ctx, job, alerts, startedAt, &summary)
+	summary.Duration = time.Since(startedAt).Round(time.Millisecond).String()
+	if err != nil {
+		summary.Error = err.Error()
+	} else {
+		logger.Info("job finished", "job", job.Name, "run", summary.RunID, "passed", summary.Passed,
+			"total", summary.Tests, "duration", summary.Duration)
+	}
+
+	if job.Webhook != "" {
+		if err := postWebhook(ctx, job.Webhook, summary); err != nil {
+			logger.Warn("failed to post job webhook", "job", job.Name, "error", err)
+		}
+	}
+	return err
+}
+
+// runJobTests does the work of runJob, filling in summary as it goes
+func runJobTests(ctx context.Context, job jobConfig, alerts *alertManager, startedAt time.Time, summary *jobSummary) error {
+	registry := newRegistry()
+	defer registry.CloseIdleConnections()
+
+	exchangeNames := []string{"*"}
+	if job.Exchanges != "" {
+		exchangeNames = splitList(job.Exchanges)
+	}
+	testers, invalids := resolveTesters(registry, exchangeNames)
+	if len(invalids) > 0 {
+		return configErrorf("not valid exchange names: %s", strings.Join(invalids, ", "))
+	}
+
+	proxies, err := loadJobProxies(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	proxies = dialableProxies(appConfig.Proxy.withCredentials(proxies), appConfig.Test.ProxyFamily)
+	pool := poolMembers(proxies)
+	pooled := len(proxies)
+	options := job.testOptions()
+	filter, err := newProxyFilter(options)
+	if err != nil {
+		return configErrorf("%v", err)
+	}
+	proxies, steps, err := filter.apply(proxies)
+	if err != nil {
+		return configErrorf("selecting proxies: %v", err)
+	}
+	for _, step := range steps {
+		logger.Info("selected proxies", "job", job.Name, "step", step)
+	}
+	if options.limit > 0 && options.limit < len(proxies) {
+		proxies = proxies[:options.limit]
+	}
+	summary.Proxies = len(proxies)
+
+	// The timeout stops dispatch; tests in flight finish on their own timeouts
+	gate := newDispatchGate()
+	go func() {
+		<-ctx.Done()
+		gate.abort()
+	}()
+
+	runID := newRunID(startedAt)
+	results := runTests(proxies, testers, newFanOutLimits(appConfig.Test, testers), gate, logger, nil)
+	summary.RunID = runID
+	summary.Tests = len(results)
+	for _, result := range results {
+		if result.Success {
+			summary.Passed++
+		} else {
+			summary.Failed++
+		}
+	}
+
+	run := &historyRun{ID: runID, Timestamp: startedAt, Pool: pool, Results: results}
+	if err := appendHistory(run); err != nil {
+		logger.Warn("failed to save test history", "job", job.Name, "error", err)
+	}
+	if job.Export != "" {
+		file := strings.ReplaceAll(job.Export, "{run}", runID)
+		if err := writeRunExport(file, run); err != nil {
+			logger.Warn("failed to export results", "job", job.Name, "file", file, "error", err)
+		} else {
+			logger.Info("results exported", "job", job.Name, "file", file)
+		}
+	}
+
+	checkAlerts(ctx, alerts, results, len(proxies), pooled, gate.isAborted())
+	if !gate.isAborted() {
+		return nil
+	}
+	summary.TimedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+	if summary.TimedOut {
+		return fmt.Errorf("timed out after %s with %d of %d tests done", job.timeout(), len(results), len(proxies)*len(testers))
+	}
+	return fmt.Errorf("interrupted with %d of %d tests done", len(results), len(proxies)*len(testers))
+}
+
+// loadJobProxies returns the proxies a job tests: freshly fetched from the
+// provider when the job refreshes, falling back to the cache if that fails,
+// and from the cache otherwise
+func loadJobProxies(ctx context.Context, job jobConfig) ([]proxypool.Proxy, error) {
+	if job.Refresh {
+		if err := appConfig.Provider.checkSources(); err != nil {
+			return nil, err
+		}
+		proxies, err := fetchProxies(ctx)
+		if err == nil {
+			if err := saveToCache(proxies); err != nil {
+				logger.Warn("failed to save to cache", "job", job.Name, "error", err)
+			}
+			return proxies, nil
+		}
+		logger.Warn("failed to refresh proxies, using cache", "job", job.Name, "error", err)
+	}
+
+	proxies, err := loadFromCache()
+	if err != nil {
+		return nil, configErrorf("loading proxies from cache: %v", err)
+	}
+	if len(proxies) == 0 {
+		return nil, configErrorf("no proxies found in cache")
+	}
+	return proxies, nil
+}
+
+// postWebhook posts summary as JSON to url
+func postWebhook(ctx context.Context, url string, summary jobSummary) error {
+	// A run that timed out still reports, so give the webhook its own deadline
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), notify.DefaultTimeout)
+	defer cancel()
+	return notify.PostJSON(ctx, nil, url, summary, http.Header{"User-Agent": {programName}})
+}
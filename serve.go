@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go-proxy/exchanges"
+	"go-proxy/proxypool"
+)
+
+// Number of recent attempts kept per proxy for the admin API
+const recentAttemptLimit = 20
+
+// attempt is one test of a proxy, as shown by the admin API
+type attempt struct {
+	RunID    string    `json:"run_id,omitempty"`
+	TestedAt time.Time `json:"tested_at"`
+	*exchanges.TestResult
+}
+
+// monitor keeps the proxy pool under continuous test and tracks the latest
+// result of every (proxy, exchange) pair
+type monitor struct {
+	registry *exchanges.Registry
+	testers  []exchanges.ExchangeTester
+	metrics  *metrics
+	refresh  bool
+	alerts   *alertManager // nil when no alert rules are configured
+
+	// pool mirrors the active proxies and their health for the routing frontend
+	pool *proxypool.Pool
+
+	// roundMutex serialises full test rounds; retests asks the main loop for one
+	roundMutex sync.Mutex
+	retests    chan struct{}
+
+	mutex       sync.RWMutex
+	proxies     []proxypool.Proxy
+	latest      map[string]*exchanges.TestResult // keyed by latestKey
+	attempts    map[string][]attempt             // keyed by proxy ID, oldest first
+	quarantined map[string]bool                  // keyed by proxy ID
+}
+
+// latestKey identifies a (proxy, exchange) pair in monitor.latest
+func latestKey(proxy proxypool.Proxy, exchange string) string {
+	return proxy.ID() + "/" + exchange
+}
+
+func newMonitor(registry *exchanges.Registry, testers []exchanges.ExchangeTester, refresh bool) *monitor {
+	m := &monitor{
+		registry:    registry,
+		testers:     testers,
+		metrics:     newMetrics(),
+		refresh:     refresh,
+		retests:     make(chan struct{}, 1),
+		latest:      make(map[string]*exchanges.TestResult),
+		attempts:    make(map[string][]attempt),
+		quarantined: make(map[string]bool),
+	}
+	m.metrics.healthy = m.healthyCounts
+	m.metrics.poolSize = func() int {
+		m.mutex.RLock()
+		defer m.mutex.RUnlock()
+		return len(m.proxies)
+	}
+
+	// The monitor runs the testers itself and feeds their results to the pool
+	m.pool = proxypool.New(proxypool.SourceFunc(func(ctx context.Context) ([]proxypool.Proxy, error) {
+		return m.activeProxies(), nil
+	}))
+	m.pool.Logger = logger
+	for _, tester := range testers {
+		if provider, ok := tester.(exchanges.HostProvider); ok {
+			m.pool.AddProbe(tester, provider.Hosts()...)
+		}
+	}
+	return m
+}
+
+// loadRules adds the routing rules from path to the pool. Each rule's
+// exchange must be a registry name tested by this monitor, since its proxies
+// are chosen from that exchange's results.
+func (m *monitor) loadRules(path string) error {
+	rules, err := proxypool.LoadRules(path)
+	if err != nil {
+		return err
+	}
+
+	for i, rule := range rules {
+		if rule.Exchange != "" {
+			tester, err := m.registry.Get(rule.Exchange)
+			if err != nil {
+				return fmt.Errorf("rule %d: %v", i+1, err)
+			}
+			if !m.tests(tester) {
+				return fmt.Errorf("rule %d: exchange '%s' is not tested; add it to --exchanges", i+1, rule.Exchange)
+			}
+		}
+		if err := m.pool.AddRule(rule); err != nil {
+			return fmt.Errorf("rule %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// tests reports whether tester is one of the monitor's testers
+func (m *monitor) tests(tester exchanges.ExchangeTester) bool {
+	for _, candidate := range m.testers {
+		if candidate == tester {
+			return true
+		}
+	}
+	return false
+}
+
+// syncPool refreshes the routing pool from the active proxies
+func (m *monitor) syncPool() {
+	m.pool.Refresh(context.Background())
+}
+
+// loadProxies reloads the pool, fetching from the provider when refresh is
+// enabled, forced, or the cache is missing, and from the cache otherwise
+func (m *monitor) loadProxies(forceRefresh bool) error {
+	proxies, err := loadFromCache()
+	if m.refresh || forceRefresh || err != nil {
+		if sourceErr := appConfig.Provider.checkSources(); sourceErr != nil {
+			if err != nil {
+				return configErrorf("no cached proxies (%v) and %v", err, sourceErr)
+			}
+			return sourceErr
+		}
+
+		startTime := time.Now()
+		fetched, fetchErr := fetchProxies(context.Background())
+		m.metrics.observeFetch(time.Since(startTime), fetchErr)
+		if fetchErr != nil {
+			if err == nil {
+				// Keep testing the cached pool when a refresh fails
+				logger.Warn("failed to refresh proxies, using cache", "error", fetchErr)
+			} else {
+				return networkErrorf("fetching proxies: %v", fetchErr)
+			}
+		} else {
+			proxies = fetched
+			if err := saveToCache(proxies); err != nil {
+				logger.Warn("failed to save to cache", "error", err)
+			}
+		}
+	}
+
+	proxies = dialableProxies(appConfig.Proxy.withCredentials(proxies), appConfig.Test.ProxyFamily)
+
+	m.mutex.Lock()
+	m.proxies = proxies
+
+	// Forget results for proxies that left the pool
+	current := make(map[string]bool, len(proxies))
+	for _, proxy := range proxies {
+		current[proxy.ID()] = true
+	}
+	for key := range m.latest {
+		if !current[key[:strings.LastIndex(key, "/")]] {
+			delete(m.latest, key)
+		}
+	}
+	for key := range m.attempts {
+		if !current[key] {
+			delete(m.attempts, key)
+		}
+	}
+	m.mutex.Unlock()
+
+	m.syncPool()
+	return nil
+}
+
+// recordResult stores a finished test of proxy in the monitor's state and metrics
+func (m *monitor) recordResult(runID string, proxy proxypool.Proxy, result *exchanges.TestResult) {
+	m.metrics.observeResult(result)
+	m.pool.SetHealth(proxy, result.Exchange, result.Success)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.latest[latestKey(proxy, result.Exchange)] = result
+
+	key := proxy.ID()
+	attempts := append(m.attempts[key], attempt{RunID: runID, TestedAt: time.Now(), TestResult: result})
+	if len(attempts) > recentAttemptLimit {
+		attempts = attempts[len(attempts)-recentAttemptLimit:]
+	}
+	m.attempts[key] = attempts
+}
+
+// activeProxies returns the proxies that are not quarantined
+func (m *monitor) activeProxies() []proxypool.Proxy {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var proxies []proxypool.Proxy
+	for _, proxy := range m.proxies {
+		if !m.quarantined[proxy.ID()] {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// runRound tests every active proxy once and records the results. Once ctx
+// is done, no further tests are started.
+func (m *monitor) runRound(ctx context.Context) {
+	m.roundMutex.Lock()
+	defer m.roundMutex.Unlock()
+
+	proxies := m.activeProxies()
+
+	// Shutdown stops dispatch; tests in flight finish on their own timeouts
+	gate := newDispatchGate()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			gate.abort()
+		case <-done:
+		}
+	}()
+
+	startedAt := time.Now()
+	runID := newRunID(startedAt)
+	results := runTests(proxies, m.testers, newFanOutLimits(appConfig.Test, m.testers), gate, logger, func(proxy proxypool.Proxy, result *exchanges.TestResult, completed, total int) {
+		m.recordResult(runID, proxy, result)
+	})
+	m.registry.CloseIdleConnections()
+
+	passed := 0
+	for _, result := range results {
+		if result.Success {
+			passed++
+		}
+	}
+
+	run := &historyRun{ID: runID, Timestamp: startedAt, Pool: poolMembers(proxies), Results: results}
+	if err := appendHistory(run); err != nil {
+		logger.Warn("failed to save test history", "error", err)
+	}
+	checkAlerts(ctx, m.alerts, results, len(proxies), len(proxies), gate.isAborted())
+
+	message := "test run finished"
+	if gate.isAborted() {
+		message = "test run stopped for shutdown"
+	}
+	logger.Info(message, "run", runID, "passed", passed, "total", len(results),
+		"duration", time.Since(startedAt).Round(time.Millisecond))
+}
+
+// retestProxy immediately tests one proxy against every exchange. The
+// results update the monitor's state but are not written to history.
+func (m *monitor) retestProxy(proxy proxypool.Proxy) []*exchanges.TestResult {
+	results := runTests([]proxypool.Proxy{proxy}, m.testers, newFanOutLimits(appConfig.Test, m.testers), nil, logger, func(proxy proxypool.Proxy, result *exchanges.TestResult, completed, total int) {
+		m.recordResult("", proxy, result)
+	})
+	return results
+}
+
+// requestRound asks the main loop to start a full round as soon as it is
+// idle; requests made while one is already pending are merged
+func (m *monitor) requestRound() {
+	select {
+	case m.retests <- struct{}{}:
+	default:
+	}
+}
+
+// findProxy looks up a proxy in the pool by its ID
+func (m *monitor) findProxy(id string) (proxypool.Proxy, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, proxy := range m.proxies {
+		if proxy.ID() == id {
+			return proxy, true
+		}
+	}
+	return proxypool.Proxy{}, false
+}
+
+// setQuarantined quarantines or releases a proxy and persists the quarantine list
+func (m *monitor) setQuarantined(key string, quarantined bool) error {
+	m.mutex.Lock()
+	if quarantined {
+		m.quarantined[key] = true
+	} else {
+		delete(m.quarantined, key)
+	}
+	keys := make([]string, 0, len(m.quarantined))
+	for k := range m.quarantined {
+		keys = append(keys, k)
+	}
+	m.mutex.Unlock()
+
+	m.syncPool()
+	return saveQuarantine(keys)
+}
+
+// healthyCounts returns how many proxies currently pass each exchange, per
+// country. Every (exchange, country) seen in the pool is present, even at zero.
+// Quarantined proxies never count as healthy.
+func (m *monitor) healthyCounts() map[healthKey]int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	counts := make(map[healthKey]int)
+	for _, proxy := range m.proxies {
+		quarantined := m.quarantined[proxy.ID()]
+		for _, tester := range m.testers {
+			key := healthKey{exchange: exchangeLabel(tester.GetName()), country: proxy.CountryCode}
+			result, exists := m.latest[latestKey(proxy, tester.GetName())]
+			if exists && result.Success && !quarantined {
+				counts[key]++
+			} else if _, seen := counts[key]; !seen {
+				counts[key] = 0
+			}
+		}
+	}
+	return counts
+}
+
+// newForwardProxy builds the routing frontend. Sessions are keyed by the
+// proxy username suffix ("user-session-abc") or the X-Proxy-Session header,
+// and optionally by the client's source IP.
+func newForwardProxy(pool *proxypool.Pool, password string, affinityTTL time.Duration, bySourceIP bool) *proxypool.ForwardProxy {
+	transport := proxypool.NewRoundTripper(pool)
+	transport.Affinity = proxypool.NewAffinityTable(affinityTTL)
+
+	frontend := proxypool.NewForwardProxy(transport)
+	keys := []proxypool.AffinityKeyFunc{
+		proxypool.UsernameSessionAffinity(),
+		proxypool.HeaderAffinity(proxypool.SessionHeader),
+	}
+	if bySourceIP {
+		keys = append(keys, proxypool.SourceIPAffinity())
+	}
+	frontend.AffinityKey = proxypool.FirstAffinity(keys...)
+
+	if password != "" {
+		frontend.Authenticate = func(username, candidate string) bool {
+			return subtle.ConstantTimeCompare([]byte(candidate), []byte(password)) == 1
+		}
+	}
+	return frontend
+}
+
+func handleServeCommand(options serveConfig) error {
+	registry := newRegistry()
+	defer registry.Transports().Close()
+
+	testers, invalids := resolveTesters(registry, strings.Split(options.Exchanges, ","))
+	if len(invalids) > 0 {
+		return usageErrorf("not valid exchange names: %s (available: %s)",
+			strings.Join(invalids, ", "), strings.Join(registry.List(), ", "))
+	}
+
+	m := newMonitor(registry, testers, options.Refresh)
+	m.alerts = newAlertManager(appConfig.Notify)
+	if options.Rules != "" {
+		if err := m.loadRules(options.Rules); err != nil {
+			return configErrorf("loading routing rules: %v", err)
+		}
+	}
+	// The quarantine must be in place before the first sync makes proxies routable
+	quarantined, err := loadQuarantine()
+	if err != nil && !os.IsNotExist(err) {
+		logger.Warn("failed to load quarantine list", "error", err)
+	}
+	for _, key := range quarantined {
+		m.quarantined[key] = true
+	}
+	if err := m.loadProxies(false); err != nil {
+		return fmt.Errorf("loading proxies: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.metrics)
+	if options.AdminToken != "" {
+		registerAdminRoutes(mux, m, options.AdminToken)
+		logger.Info("admin API enabled under /admin/")
+	}
+	server := &http.Server{Addr: options.Listen, Handler: mux}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// A server that cannot listen stops serve with its error
+	serverErr := make(chan error, 2)
+
+	if options.ProxyListen != "" {
+		frontend := newForwardProxy(m.pool, options.ProxyPassword, options.AffinityTTL, options.AffinitySourceIP)
+		proxyServer := &http.Server{Addr: options.ProxyListen, Handler: frontend}
+		defer proxyServer.Close()
+
+		if options.ProxyPassword == "" {
+			logger.Warn("forward proxy has no password; anyone who can reach it can use it")
+		}
+		go func() {
+			logger.Info("forward proxy listening", "address", options.ProxyListen)
+			if err := proxyServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serverErr <- networkErrorf("forward proxy failed: %v", err)
+				stop()
+			}
+		}()
+	}
+
+	go func() {
+		logger.Info("serving metrics", "url", options.Listen+"/metrics")
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- networkErrorf("HTTP server failed: %v", err)
+			stop()
+		}
+	}()
+
+	ticker := time.NewTicker(options.Interval)
+	defer ticker.Stop()
+
+	for {
+		m.runRound(ctx)
+
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+			select {
+			case err := <-serverErr:
+				return err
+			default:
+			}
+			logger.Info("shutting down")
+			return nil
+		case <-ticker.C:
+		case <-m.retests:
+		}
+
+		if m.refresh {
+			if err := m.loadProxies(false); err != nil {
+				logger.Warn("failed to reload proxies", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// The dashboard needs termios; elsewhere --tui falls back to plain output
+
+func isTerminal(file *os.File) bool { return false }
+
+func terminalSize(file *os.File) (int, int) { return 80, 24 }
+
+func enableKeyInput(file *os.File) (func(), error) {
+	return nil, errors.New("terminal key input is not supported on this platform")
+}
@@ -0,0 +1,52 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ioctl runs a terminal ioctl on fd with arg pointing at its argument
+func ioctl(fd uintptr, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// isTerminal reports whether file is a terminal
+func isTerminal(file *os.File) bool {
+	var state syscall.Termios
+	return ioctl(file.Fd(), ioctlGetTermios, unsafe.Pointer(&state)) == nil
+}
+
+// terminalSize returns the width and height of the terminal, or 80x24 when
+// they are unknown
+func terminalSize(file *os.File) (int, int) {
+	var size struct{ rows, cols, xpixel, ypixel uint16 }
+	if err := ioctl(file.Fd(), syscall.TIOCGWINSZ, unsafe.Pointer(&size)); err != nil || size.cols == 0 || size.rows == 0 {
+		return 80, 24
+	}
+	return int(size.cols), int(size.rows)
+}
+
+// enableKeyInput switches the terminal to read single keys without echo,
+// with Ctrl-C delivered as a key rather than a signal. Output processing is
+// left on. The returned function restores the previous mode.
+func enableKeyInput(file *os.File) (func(), error) {
+	var saved syscall.Termios
+	if err := ioctl(file.Fd(), ioctlGetTermios, unsafe.Pointer(&saved)); err != nil {
+		return nil, err
+	}
+	state := saved
+	state.Lflag &^= syscall.ICANON | syscall.ECHO | syscall.ISIG
+	state.Iflag &^= syscall.ICRNL | syscall.IXON
+	state.Cc[syscall.VMIN] = 1
+	state.Cc[syscall.VTIME] = 0
+	if err := ioctl(file.Fd(), ioctlSetTermios, unsafe.Pointer(&state)); err != nil {
+		return nil, err
+	}
+	return func() { ioctl(file.Fd(), ioctlSetTermios, unsafe.Pointer(&saved)) }, nil
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go-proxy/exchanges"
+)
+
+// Dashboard layout and refresh rate
+const (
+	dashboardRefresh   = 200 * time.Millisecond
+	dashboardLogLines  = 3   // latest log lines shown under the table
+	dashboardSparkline = 200 // successful latencies kept for the sparkline
+	dashboardMinBar    = 10
+	dashboardMaxBar    = 50
+)
+
+// Sort orders the dashboard table cycles through; "" shows the newest
+// results first
+var dashboardSortKeys = append([]string{""}, reportSortKeys...)
+
+// sparkBlocks draw the latency sparkline, lowest to highest
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// exchangeProgress counts the tests of one exchange
+type exchangeProgress struct {
+	total, completed, passed int
+}
+
+// dashboard is the live view of a test run shown with --tui: a progress bar
+// per exchange, the running success rate, a latency sparkline and a table of
+// the results so far. Keys filter the table to failures, change its order,
+// pause dispatch and abort the run.
+//
+// While it is open the dashboard takes over the package logger, showing the
+// latest lines itself and logging every record normally once it closes.
+type dashboard struct {
+	out      *os.File
+	previous *slog.Logger // logger to restore on close
+
+	mutex        sync.Mutex
+	gate         *dispatchGate
+	startedAt    time.Time
+	total        int
+	completed    int
+	passed       int
+	exchanges    []string // in tester order
+	progress     map[string]*exchangeProgress
+	results      []*exchanges.TestResult
+	latencies    []time.Duration // latest successful latencies
+	sortIndex    int             // into dashboardSortKeys
+	failuresOnly bool
+	paused       bool
+	logLines     []string
+	records      []slog.Record // logged on close
+
+	running bool
+	restore func()        // restores the terminal mode
+	redraw  chan struct{} // asks for a redraw before the next tick
+	done    chan struct{} // closed to stop refreshing
+	stopped chan struct{} // closed once refreshing has stopped
+}
+
+// newDashboard creates a dashboard drawing on out and redirects the package
+// logger to it. Call start when the run begins and close when it ends; close
+// may be called more than once.
+func newDashboard(out *os.File) *dashboard {
+	d := &dashboard{
+		out:      out,
+		previous: logger,
+		progress: make(map[string]*exchangeProgress),
+		redraw:   make(chan struct{}, 1),
+	}
+	options := &slog.HandlerOptions{Level: &logLevel}
+	logger = slog.New(dashboardHandler{slog.NewTextHandler(dashboardLog{d}, options), d})
+	return d
+}
+
+// dashboardHandler formats records for the dashboard's log lines and keeps
+// them to be logged by the real logger later
+type dashboardHandler struct {
+	slog.Handler
+	d *dashboard
+}
+
+func (h dashboardHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.d.mutex.Lock()
+	h.d.records = append(h.d.records, record.Clone())
+	h.d.mutex.Unlock()
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h dashboardHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return dashboardHandler{h.Handler.WithAttrs(attrs), h.d}
+}
+
+func (h dashboardHandler) WithGroup(name string) slog.Handler {
+	return dashboardHandler{h.Handler.WithGroup(name), h.d}
+}
+
+// dashboardLog receives the formatted log lines of a dashboard
+type dashboardLog struct{ d *dashboard }
+
+func (l dashboardLog) Write(p []byte) (int, error) {
+	l.d.mutex.Lock()
+	defer l.d.mutex.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		l.d.logLines = append(l.d.logLines, line)
+	}
+	if len(l.d.logLines) > dashboardLogLines {
+		l.d.logLines = slices.Clone(l.d.logLines[len(l.d.logLines)-dashboardLogLines:])
+	}
+	return len(p), nil
+}
+
+// start opens the dashboard for proxiesPerExchange tests of each exchange
+// and starts reading keys. Pause and abort act on gate.
+func (d *dashboard) start(gate *dispatchGate, exchangeNames []string, proxiesPerExchange int) error {
+	restore, err := enableKeyInput(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	d.gate = gate
+	d.startedAt = time.Now()
+	d.exchanges = exchangeNames
+	for _, name := range exchangeNames {
+		d.progress[name] = &exchangeProgress{total: proxiesPerExchange}
+	}
+	d.total = len(exchangeNames) * proxiesPerExchange
+	d.running = true
+	d.restore = restore
+	d.done = make(chan struct{})
+	d.stopped = make(chan struct{})
+	d.mutex.Unlock()
+
+	// Switch to the alternate screen and hide the cursor
+	fmt.Fprint(d.out, "\x1b[?1049h\x1b[?25l")
+	go d.readKeys()
+	go d.refresh()
+	return nil
+}
+
+// record adds a finished test to the dashboard
+func (d *dashboard) record(result *exchanges.TestResult) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.results = append(d.results, result)
+	d.completed++
+	progress := d.progress[result.Exchange]
+	if progress != nil {
+		progress.completed++
+	}
+	if result.Success {
+		d.passed++
+		if progress != nil {
+			progress.passed++
+		}
+		d.latencies = append(d.latencies, result.ResponseTime)
+		if len(d.latencies) > dashboardSparkline {
+			d.latencies = d.latencies[len(d.latencies)-dashboardSparkline:]
+		}
+	}
+}
+
+// stop stops refreshing and gives the screen and terminal back
+func (d *dashboard) stop() {
+	d.mutex.Lock()
+	running := d.running
+	d.running = false
+	d.mutex.Unlock()
+	if !running {
+		return
+	}
+
+	close(d.done)
+	<-d.stopped
+	fmt.Fprint(d.out, "\x1b[?25h\x1b[?1049l")
+	d.restore()
+}
+
+// close stops the dashboard, restores the package logger and logs every
+// record the dashboard held back
+func (d *dashboard) close() {
+	d.stop()
+	logger = d.previous
+
+	d.mutex.Lock()
+	records := d.records
+	d.records = nil
+	d.mutex.Unlock()
+	handler := logger.Handler()
+	for _, record := range records {
+		if handler.Enabled(context.Background(), record.Level) {
+			handler.Handle(context.Background(), record)
+		}
+	}
+}
+
+// readKeys handles key presses until the dashboard stops. The last read
+// stays blocked until the next key, which is then ignored.
+func (d *dashboard) readKeys() {
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		key, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		select {
+		case <-d.done:
+			return
+		default:
+		}
+
+		d.mutex.Lock()
+		switch key {
+		case 'f':
+			d.failuresOnly = !d.failuresOnly
+		case 's':
+			d.sortIndex = (d.sortIndex + 1) % len(dashboardSortKeys)
+		case 'p', ' ':
+			d.paused = !d.paused
+			d.gate.setPaused(d.paused)
+		case 'q', 3: // 3 is Ctrl-C, which arrives as a key
+			d.gate.abort()
+		}
+		d.mutex.Unlock()
+
+		select {
+		case d.redraw <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// refresh redraws the dashboard on every tick and key press until stopped
+func (d *dashboard) refresh() {
+	defer close(d.stopped)
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+	for {
+		d.draw()
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		case <-d.redraw:
+		}
+	}
+}
+
+// draw renders one frame to fit the terminal
+func (d *dashboard) draw() {
+	width, height := terminalSize(d.out)
+	d.mutex.Lock()
+	lines := d.render(width, height)
+	d.mutex.Unlock()
+
+	var frame strings.Builder
+	frame.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i > 0 {
+			frame.WriteString("\n")
+		}
+		frame.WriteString(truncate(line, width))
+		frame.WriteString("\x1b[K")
+	}
+	frame.WriteString("\x1b[J")
+	fmt.Fprint(d.out, frame.String())
+}
+
+// render returns the lines of a frame, the results table filling whatever
+// height the other sections leave
+func (d *dashboard) render(width, height int) []string {
+	state := "running"
+	switch {
+	case d.gate.isAborted():
+		state = "aborting, waiting for tests in flight"
+	case d.paused:
+		state = "paused"
+	}
+	header := []string{
+		fmt.Sprintf("%s test  %s  %s", programName, time.Since(d.startedAt).Round(time.Second), state),
+		fmt.Sprintf("%d/%d tests  %d passed  %.1f%% success", d.completed, d.total, d.passed, percent(d.passed, d.completed)),
+		"",
+	}
+
+	nameWidth := 0
+	for _, name := range d.exchanges {
+		nameWidth = max(nameWidth, len(name))
+	}
+	barWidth := min(max(width-nameWidth-30, dashboardMinBar), dashboardMaxBar)
+	for _, name := range d.exchanges {
+		progress := d.progress[name]
+		header = append(header, fmt.Sprintf("%-*s %s %*d/%d  %5.1f%%",
+			nameWidth, name, progressBar(progress.completed, progress.total, barWidth),
+			len(fmt.Sprint(progress.total)), progress.completed, progress.total,
+			percent(progress.passed, progress.completed)))
+	}
+	header = append(header, "", d.renderSparkline(width), "")
+
+	// The results so far, filtered and sorted as chosen
+	rows := slices.Clone(d.results)
+	if d.failuresOnly {
+		rows = slices.DeleteFunc(rows, func(result *exchanges.TestResult) bool { return result.Success })
+	}
+	sortKey := dashboardSortKeys[d.sortIndex]
+	if sortKey == "" {
+		slices.Reverse(rows)
+	} else {
+		sortResults(rows, sortKey)
+	}
+	title := "Results, newest first"
+	if sortKey != "" {
+		title = "Results by " + sortKey
+	}
+	if d.failuresOnly {
+		title += ", failures only"
+	}
+	// Room for "address:port", widened by IPv6 addresses
+	proxyWidth := resultsAddressWidth(d.results) + 6
+	header = append(header, fmt.Sprintf("%s (%d)", title, len(rows)),
+		fmt.Sprintf("%-12s %-*s %-7s %s", "Exchange", proxyWidth, "Proxy", "Country", "Result"))
+
+	footer := []string{""}
+	footer = append(footer, d.logLines...)
+	pauseKey := "p pause"
+	if d.paused {
+		pauseKey = "p resume"
+	}
+	footer = append(footer, fmt.Sprintf("f failures only  s sort  %s  q abort", pauseKey))
+
+	room := max(height-len(header)-len(footer), 0)
+	lines := header
+	for _, result := range rows[:min(room, len(rows))] {
+		lines = append(lines, resultRow(result, proxyWidth))
+	}
+	for range room - min(room, len(rows)) {
+		lines = append(lines, "")
+	}
+	return append(lines, footer...)
+}
+
+// renderSparkline draws the latest successful latencies, scaled between
+// their minimum and maximum
+func (d *dashboard) renderSparkline(width int) string {
+	if len(d.latencies) == 0 {
+		return "Latency  no successful tests yet"
+	}
+	latencies := d.latencies[max(len(d.latencies)-max(width-40, 10), 0):]
+	low, high := slices.Min(latencies), slices.Max(latencies)
+	spark := make([]rune, len(latencies))
+	for i, latency := range latencies {
+		level := 0
+		if high > low {
+			level = int(float64(latency-low) / float64(high-low) * float64(len(sparkBlocks)-1))
+		}
+		spark[i] = sparkBlocks[level]
+	}
+	return fmt.Sprintf("Latency  %s  %s-%s", string(spark),
+		low.Round(time.Millisecond), high.Round(time.Millisecond))
+}
+
+// progressBar draws completed out of total as a bar of the given width
+func progressBar(completed, total, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = completed * width / total
+	}
+	return strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+}
+
+// resultRow formats one result for the dashboard table
+func resultRow(result *exchanges.TestResult, proxyWidth int) string {
+	outcome := "✓ " + result.ResponseTime.Round(time.Millisecond).String()
+	if !result.Success {
+		outcome = "✗ " + result.Error
+		if result.FailureKind != "" {
+			outcome = "✗ " + result.FailureKind + ": " + result.Error
+		}
+	}
+	return fmt.Sprintf("%-12s %-*s %-7s %s", result.Exchange, proxyWidth, groupKey(result, "proxy"), result.CountryCode, outcome)
+}
+
+// truncate cuts line to at most width characters
+func truncate(line string, width int) string {
+	if utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	runes := []rune(line)
+	if width <= 1 {
+		return string(runes[:max(width, 0)])
+	}
+	return string(runes[:width-1]) + "…"
+}